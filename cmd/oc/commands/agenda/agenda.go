@@ -367,7 +367,7 @@ func (self *CommandAgenda) RenderAgendaEntry(v common.Todo, index int) string {
 
 func (self *CommandAgenda) HandleShortcuts(event *tcell.EventKey) *tcell.EventKey {
 	// When a popup is open, let it handle all input
-	if self.pages != nil && self.pages.HasPage("statusPopup") {
+	if self.pages != nil && (self.pages.HasPage("statusPopup") || self.pages.HasPage("checkboxPopup")) {
 		return event
 	}
 	if event.Key() == tcell.KeyEscape && self.viewMode == viewMonth {
@@ -471,6 +471,26 @@ func (self *CommandAgenda) HandleShortcuts(event *tcell.EventKey) *tcell.EventKe
 			return nil
 		}
 		return event
+	case 'x':
+		if self.viewMode == viewMonth && self.monthCursorEntry >= 0 {
+			todos := self.monthDayTodos[self.monthCursorDay]
+			if self.monthCursorEntry < len(todos) {
+				self.showCheckboxPopupFor(todos[self.monthCursorEntry], self.monthGrid, func() {
+					self.fetchAllTodos(self.core)
+					self.renderMonthGrid()
+				})
+			}
+			return nil
+		}
+		if self.viewMode == viewDay {
+			if t := self.getSelectedDayTodo(); t != nil {
+				self.showCheckboxPopupFor(*t, self.out, func() {
+					self.ShowAgendaPane(self.core)
+				})
+			}
+			return nil
+		}
+		return event
 	}
 	if event.Key() == tcell.KeyEnter {
 		if self.viewMode == viewMonth {
@@ -1298,6 +1318,93 @@ func (self *CommandAgenda) showStatusPopupFor(t common.Todo, focusTarget tview.P
 	self.app.SetFocus(list)
 }
 
+// Show the checkbox items of an entry, enter ticks or unticks the selected item.
+func (self *CommandAgenda) showCheckboxPopupFor(t common.Todo, focusTarget tview.Primitive, onDone func()) {
+	if t.Hash == "" {
+		return
+	}
+	var items []common.CheckboxItem
+	params := map[string]string{}
+	commands.SendReceiveGet(self.core, fmt.Sprintf("checkbox/%s", t.Hash), params, &items)
+	if len(items) == 0 {
+		return
+	}
+
+	list := tview.NewList()
+	list.SetBorder(true).SetTitle(fmt.Sprintf(" Checkboxes: %s ", t.Headline))
+	list.SetTitleColor(tcell.ColorSkyblue)
+	list.ShowSecondaryText(false)
+	list.SetHighlightFullLine(true)
+	list.SetSelectedBackgroundColor(tcell.ColorDarkMagenta)
+
+	changed := false
+	closePopup := func() {
+		self.pages.RemovePage("checkboxPopup")
+		self.app.SetFocus(focusTarget)
+		if changed && onDone != nil {
+			onDone()
+		}
+	}
+	var fill func()
+	fill = func() {
+		cur := list.GetCurrentItem()
+		list.Clear()
+		for _, it := range items {
+			item := it // capture for closure
+			color := "white"
+			switch item.Status {
+			case "X", "x":
+				color = "green"
+			case "-":
+				color = "yellow"
+			}
+			cell := fmt.Sprintf("%s[%s]%s[-] %s", strings.Repeat("  ", item.Depth), color, tview.Escape("["+item.Status+"]"), tview.Escape(item.Text))
+			list.AddItem(cell, "", 0, func() {
+				// Toggle on the server and reload, parents may have changed too
+				target := common.PreciseTarget{Target: common.Target{Type: "hash", Id: t.Hash}, Row: item.Row}
+				var result common.ResultMsg
+				commands.SendReceivePost(self.core, "checkbox/toggle", &target, &result)
+				changed = true
+				commands.SendReceiveGet(self.core, fmt.Sprintf("checkbox/%s", t.Hash), params, &items)
+				fill()
+			})
+		}
+		list.SetCurrentItem(cur)
+	}
+	fill()
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			closePopup()
+			return nil
+		}
+		return event
+	})
+
+	maxLen := len(t.Headline) + 16
+	for _, it := range items {
+		if l := len(it.Text) + 2*it.Depth + 8; l > maxLen {
+			maxLen = l
+		}
+	}
+	popupWidth := maxLen
+	if popupWidth > 80 {
+		popupWidth = 80
+	}
+	popupHeight := len(items) + 2
+	self.pages.AddPage("checkboxPopup",
+		tview.NewFlex().
+			AddItem(nil, 0, 1, false).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(nil, 0, 1, false).
+				AddItem(list, popupHeight, 0, true).
+				AddItem(nil, 0, 1, false),
+				popupWidth, 0, true).
+			AddItem(nil, 0, 1, false),
+		true, true)
+	self.app.SetFocus(list)
+}
+
 func (self *CommandAgenda) switchView(mode int) {
 	self.viewMode = mode
 	self.layout.Clear()
@@ -1335,13 +1442,15 @@ func (self *CommandAgenda) updateStatusBar() {
 		self.statusBar.SetCell(0, 2, tview.NewTableCell(" ,/.:Month "))
 		self.statusBar.SetCell(0, 3, tview.NewTableCell(" n:Today "))
 		self.statusBar.SetCell(0, 4, tview.NewTableCell(" t:Status "))
-		self.statusBar.SetCell(0, 5, tview.NewTableCell(" d:Day View "))
+		self.statusBar.SetCell(0, 5, tview.NewTableCell(" x:Checkboxes "))
+		self.statusBar.SetCell(0, 6, tview.NewTableCell(" d:Day View "))
 	default:
 		self.statusBar.SetCell(0, 0, tview.NewTableCell(" j/k:Entry "))
 		self.statusBar.SetCell(0, 1, tview.NewTableCell(" ,/.:Day "))
 		self.statusBar.SetCell(0, 2, tview.NewTableCell(" n:Today "))
 		self.statusBar.SetCell(0, 3, tview.NewTableCell(" t:Status "))
-		self.statusBar.SetCell(0, 4, tview.NewTableCell(" m:Month View "))
+		self.statusBar.SetCell(0, 4, tview.NewTableCell(" x:Checkboxes "))
+		self.statusBar.SetCell(0, 5, tview.NewTableCell(" m:Month View "))
 	}
 }
 
@@ -1349,7 +1458,7 @@ func (self *CommandAgenda) ShowAgendaPane(core *commands.Core) {
 	self.out.Clear()
 	//self.Core = core
 	params := map[string]string{
-		"query": fmt.Sprintf(`!IsProject() && !IsArchived() && IsTodo() && OnDate("%s")`, self.CurDate.Format("2006 02 01")),
+		"query": fmt.Sprintf(`!IsProject() && !IsArchived() && IsTodo() && OnDate("%s")`, self.CurDate.Format("2006-01-02")),
 	}
 	//self.Error = core.ws.Call("Db.QueryTodosExp", self.Query, &self.Reply)
 	self.Reply = common.Todos{}
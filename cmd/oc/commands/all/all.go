@@ -17,6 +17,11 @@ import (
 	_ "github.com/ihdavids/orgs/cmd/oc/commands/new"
 	_ "github.com/ihdavids/orgs/cmd/oc/commands/projects"
 	_ "github.com/ihdavids/orgs/cmd/oc/commands/refile"
+	_ "github.com/ihdavids/orgs/cmd/oc/commands/resolveclock"
+	_ "github.com/ihdavids/orgs/cmd/oc/commands/search"
 	_ "github.com/ihdavids/orgs/cmd/oc/commands/serve"
+	_ "github.com/ihdavids/orgs/cmd/oc/commands/table"
 	_ "github.com/ihdavids/orgs/cmd/oc/commands/taggroups"
+	_ "github.com/ihdavids/orgs/cmd/oc/commands/undo"
+	_ "github.com/ihdavids/orgs/cmd/oc/commands/user"
 )
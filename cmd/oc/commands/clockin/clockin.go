@@ -11,7 +11,9 @@ import (
 )
 
 type ClockIn struct {
-	Hash string
+	Hash      string
+	History   bool
+	Interrupt bool
 }
 
 func (self *ClockIn) Unmarshal(unmarshal func(interface{}) error) error {
@@ -23,11 +25,53 @@ func (self *ClockIn) StartPlugin(manager *common.PluginManager) {
 
 func (self *ClockIn) SetupParameters(fset *flag.FlagSet) {
 	fset.StringVar(&self.Hash, "hash", "", "hash of heading to clock into")
+	fset.BoolVar(&self.History, "history", false, "pick from recently clocked headings")
+	fset.BoolVar(&self.Interrupt, "interrupt", false, "pause the running clock and resume it when this one is clocked out")
+}
+
+func pick(labels []string) int {
+	f, err := fzf.New(
+		fzf.WithPrompt("Clock into> "),
+		fzf.WithCountViewEnabled(true),
+		fzf.WithCountView(func(meta fzf.CountViewMeta) string {
+			return fmt.Sprintf("headings: %d", meta.ItemsCount)
+		}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	idxs, err := f.Find(labels, func(i int) string { return labels[i] })
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(idxs) == 0 {
+		return -1
+	}
+	return idxs[0]
 }
 
 func (self *ClockIn) Exec(core *commands.Core) {
 	hash := self.Hash
-	if hash == "" {
+	var target common.Target
+	if hash == "" && self.History {
+		var qry map[string]string = map[string]string{}
+		var history []common.ClockHistoryEntry
+		commands.SendReceiveGet(core, "clock/history", qry, &history)
+		if len(history) == 0 {
+			fmt.Println("No recently clocked headings")
+			return
+		}
+		labels := make([]string, len(history))
+		for i, h := range history {
+			labels[i] = fmt.Sprintf("%s  (%s) %s", h.Headline, h.Filename, h.LastClocked.Format("2006-01-02 15:04"))
+		}
+		idx := pick(labels)
+		if idx < 0 {
+			fmt.Println("No heading selected")
+			return
+		}
+		target = history[idx].Target
+	} else if hash == "" {
 		// Get all headings from the server
 		var qry map[string]string = map[string]string{}
 		var headings common.Todos
@@ -47,34 +91,27 @@ func (self *ClockIn) Exec(core *commands.Core) {
 			labels[i] = fmt.Sprintf("%s%s  (%s)", prefix, h.Headline, h.Filename)
 		}
 
-		f, err := fzf.New(
-			fzf.WithPrompt("Clock into> "),
-			fzf.WithCountViewEnabled(true),
-			fzf.WithCountView(func(meta fzf.CountViewMeta) string {
-				return fmt.Sprintf("headings: %d", meta.ItemsCount)
-			}),
-		)
-		if err != nil {
-			log.Fatal(err)
-		}
-		idxs, err := f.Find(labels, func(i int) string { return labels[i] })
-		if err != nil {
-			log.Fatal(err)
-		}
-		if len(idxs) == 0 {
+		idx := pick(labels)
+		if idx < 0 {
 			fmt.Println("No heading selected")
 			return
 		}
-		hash = headings[idxs[0]].Hash
+		hash = headings[idx].Hash
+	}
+	if hash != "" {
+		target = common.Target{
+			Id:   hash,
+			Type: "hash",
+		}
 	}
 
-	// Clock in via the server (this also clocks out any active clock)
-	target := common.Target{
-		Id:   hash,
-		Type: "hash",
+	// Clock in via the server (this also clocks out or pauses any active clock)
+	api := "clockin"
+	if self.Interrupt {
+		api += "?interrupt=true"
 	}
 	var reply common.ResultMsg
-	commands.SendReceivePost(core, "clockin", &target, &reply)
+	commands.SendReceivePost(core, api, &target, &reply)
 	if reply.Ok {
 		fmt.Printf("OK: %s\n", reply.Msg)
 	} else {
@@ -11,9 +11,12 @@ import (
 )
 
 type ClockData struct {
-	Active bool
-	Time   org.OrgDate
-	Target common.Target
+	Active   bool
+	Time     org.OrgDate
+	Target   common.Target
+	Paused   []common.Target
+	Dangling bool
+	LastSeen time.Time
 }
 
 type Clocks struct {
@@ -44,6 +47,12 @@ func (self *Clocks) Exec(core *commands.Core) {
 	fmt.Printf("  Target:  %s :: %s\n", data.Target.Filename, data.Target.Id)
 	fmt.Printf("  Started: %s\n", data.Time.Start.Format("2006-01-02 15:04"))
 	fmt.Printf("  Elapsed: %dh %02dm\n", hours, mins)
+	if data.Dangling {
+		fmt.Printf("  Dangling: last seen running %s, resolve it with oc resolveclock\n", data.LastSeen.Format("2006-01-02 15:04"))
+	}
+	for i := len(data.Paused) - 1; i >= 0; i-- {
+		fmt.Printf("  Paused:  %s :: %s\n", data.Paused[i].Filename, data.Paused[i].Id)
+	}
 }
 
 // init function is called at boot
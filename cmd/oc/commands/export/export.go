@@ -14,6 +14,7 @@ type Export struct {
 	Format   string
 	Local    string
 	Parent   string
+	Post     bool
 }
 
 func (self *Export) Unmarshal(unmarshal func(interface{}) error) error {
@@ -30,6 +31,7 @@ func (self *Export) SetupParameters(fset *flag.FlagSet) {
 	fset.StringVar(&(self.Format), "f", "mermaid", "export format")
 	fset.StringVar(&(self.Local), "l", "t", "local or not")
 	fset.StringVar(&(self.Parent), "parent", "", "parent identifier")
+	fset.BoolVar(&(self.Post), "post", false, "export with a POST, needed by exporters that change the file like confluence")
 }
 
 func (self *Export) Exec(core *commands.Core) {
@@ -42,8 +44,16 @@ func (self *Export) Exec(core *commands.Core) {
 	qry["parent"] = self.Parent
 	var reply common.Result = common.Result{}
 
-	//func SendReceiveGet[RPC any, RESP any](core *Core, name string, args *RPC, resp *RESP) {
-	commands.SendReceiveGet(core, fmt.Sprintf("file/%s", self.Format), qry, &reply)
+	if self.Post {
+		args := common.ExportToFile{Query: self.Query, Props: map[string]string{"parent": self.Parent}}
+		if self.Local == "t" {
+			args.Filename = self.Filename
+		}
+		commands.SendReceivePost(core, fmt.Sprintf("file/%s", self.Format), &args, &reply)
+	} else {
+		//func SendReceiveGet[RPC any, RESP any](core *Core, name string, args *RPC, resp *RESP) {
+		commands.SendReceiveGet(core, fmt.Sprintf("file/%s", self.Format), qry, &reply)
+	}
 	//commands.SendReceiveRpc(core, "Db.ExportToFile", &query, &reply)
 	if reply.Ok {
 		fmt.Printf("OK")
@@ -56,6 +66,6 @@ func (self *Export) Exec(core *commands.Core) {
 func init() {
 	commands.AddCmd("export", "export a given module",
 		func() commands.Cmd {
-			return &Export{"./out.html", "IsTask() && HasProperty(\"EFFORT\")", "mermaid", "t", ""}
+			return &Export{"./out.html", "IsTask() && HasProperty(\"EFFORT\")", "mermaid", "t", "", false}
 		})
 }
@@ -0,0 +1,58 @@
+package resolveclock
+
+// Resolve a clock that has been left running for too long.
+//
+// keep      keep all of the time and leave the clock running
+// subtract  drop -idle minutes, or everything after -at, and keep clocking
+// clockout  clock out -idle minutes ago or at -at
+// cancel    throw the clock away
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ihdavids/orgs/cmd/oc/commands"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+type ResolveClock struct {
+	Action string
+	Idle   int
+	At     string
+}
+
+func (self *ResolveClock) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *ResolveClock) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *ResolveClock) SetupParameters(fset *flag.FlagSet) {
+	fset.StringVar(&self.Action, "action", "", "keep, subtract, clockout or cancel")
+	fset.IntVar(&self.Idle, "idle", 0, "idle minutes to drop from the end of the clock")
+	fset.StringVar(&self.At, "at", "", "time the clock should have stopped, like \"2024-01-15 17:30\"")
+}
+
+func (self *ResolveClock) Exec(core *commands.Core) {
+	if self.Action == "" {
+		fmt.Println("Err: -action is required, one of keep, subtract, clockout or cancel")
+		return
+	}
+	req := common.ClockResolveRequest{Action: self.Action, IdleMins: self.Idle, At: self.At}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, "clock/resolve", &req, &reply)
+	if reply.Ok {
+		fmt.Printf("OK: %s\n", reply.Msg)
+	} else {
+		fmt.Printf("Err: %s\n", reply.Msg)
+	}
+}
+
+// init function is called at boot
+func init() {
+	commands.AddCmd("resolveclock", "resolve a clock that was left running",
+		func() commands.Cmd {
+			return &ResolveClock{}
+		})
+}
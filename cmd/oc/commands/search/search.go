@@ -0,0 +1,101 @@
+package search
+
+// Search runs a ranked full text search on the server
+// and lets you pick a result with an fzf interface.
+//
+// Selecting a result opens it in your chosen editor
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ihdavids/orgs/cmd/oc/commands"
+	"github.com/ihdavids/orgs/internal/common"
+	fzf "github.com/junegunn/fzf/src"
+)
+
+type SearchQuery struct {
+	Query string
+	Limit int
+	List  bool
+}
+
+func (self *SearchQuery) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *SearchQuery) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *SearchQuery) SetupParameters(fset *flag.FlagSet) {
+	fset.StringVar(&(self.Query), "query", "", "Query, supports \"phrases\", prefix*, field:term and -term")
+	fset.IntVar(&(self.Limit), "limit", 50, "Maximum number of results")
+	fset.BoolVar(&(self.List), "list", false, "Print the results instead of picking one")
+}
+
+func (self *SearchQuery) Exec(core *commands.Core) {
+	var qry map[string]string = map[string]string{}
+	// A file path can hold anything but NUL.
+	delimeter := "\x00"
+	qry["query"] = self.Query
+	qry["limit"] = strconv.Itoa(self.Limit)
+	var reply []common.SearchResult
+
+	commands.SendReceiveGet(core, "textsearch", qry, &reply)
+	if reply == nil {
+		fmt.Printf("Err")
+		return
+	}
+	if self.List {
+		for _, r := range reply {
+			fmt.Printf("%6.2f %s:%d %s\n", r.Score, r.Todo.Filename, r.Todo.LineNum+1, r.Todo.Headline)
+			if r.Snippet != "" {
+				fmt.Printf("       %s\n", r.Snippet)
+			}
+		}
+		return
+	}
+	inputChan := make(chan string)
+	go func() {
+		for _, r := range reply {
+			inputChan <- strings.Join([]string{r.Todo.Filename, strconv.Itoa(r.Todo.LineNum + 1), r.Todo.Headline + " | " + r.Snippet}, delimeter)
+		}
+		close(inputChan)
+	}()
+
+	output := []string{}
+	outputChan := make(chan string)
+	go func() {
+		for s := range outputChan {
+			output = append(output, s)
+		}
+	}()
+
+	var options *fzf.Options = nil
+	options, _ = fzf.ParseOptions(
+		true, // whether to load defaults ($FZF_DEFAULT_OPTS_FILE and $FZF_DEFAULT_OPTS)
+		// Keep the server ranking rather than letting fzf resort the list.
+		[]string{"--border", "--reverse", "--no-sort", "--delimiter", delimeter, "--with-nth", "3", "--preview", "bat --style=numbers --color=always --highlight-line {2} {1} -n -H {2}", "--preview-window", "border-bottom,+{2}+3/3"},
+	)
+
+	options.Input = inputChan
+	options.Output = outputChan
+
+	fzf.Run(options)
+
+	for _, o := range output {
+		os := strings.SplitN(o, delimeter, 3)
+		line, _ := strconv.Atoi(os[1])
+		core.LaunchEditor(os[0], line)
+	}
+}
+
+// init function is called at boot
+func init() {
+	commands.AddCmd("search", "ranked full text search of the headings in the DB",
+		func() commands.Cmd {
+			return &SearchQuery{}
+		})
+}
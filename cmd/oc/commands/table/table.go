@@ -0,0 +1,156 @@
+//lint:file-ignore ST1006 allow the use of self
+package table
+
+// Get named tables in and out of the server as CSV, TSV or JSON.
+//
+// oc table list                                      list the named tables
+// oc table export -name Prices [-format csv] [-out prices.csv]
+// oc table import -name Prices -in prices.csv [-header] [-append]
+//
+// The format defaults to the extension of -in or -out, and to csv.
+// -in - reads stdin and export writes to stdout without -out.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ihdavids/orgs/cmd/oc/commands"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+type Table struct {
+	Name     string
+	Filename string
+	Format   string
+	In       string
+	Out      string
+	Append   bool
+	Header   bool
+	fset     *flag.FlagSet
+}
+
+func (self *Table) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *Table) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *Table) SetupParameters(fset *flag.FlagSet) {
+	self.fset = fset
+	fset.StringVar(&self.Name, "name", "", "the #+NAME: of the table")
+	fset.StringVar(&self.Filename, "file", "", "org file holding the table, when several files have a table with that name")
+	fset.StringVar(&self.Format, "format", "", "csv, tsv or json")
+	fset.StringVar(&self.In, "in", "-", "file to import from, - for stdin")
+	fset.StringVar(&self.Out, "out", "", "file to export to, stdout if empty")
+	fset.BoolVar(&self.Append, "append", false, "append the imported rows instead of replacing the table")
+	fset.BoolVar(&self.Header, "header", false, "the first csv or tsv record is a header")
+}
+
+func (self *Table) format(filename string) string {
+	if self.Format != "" {
+		return strings.ToLower(self.Format)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tsv", ".tab":
+		return "tsv"
+	case ".json":
+		return "json"
+	}
+	return "csv"
+}
+
+func (self *Table) list(core *commands.Core) {
+	var reply struct {
+		Ok          bool
+		NamedTables []string
+	}
+	commands.SendReceiveGet(core, "tablenames", map[string]string{}, &reply)
+	for _, n := range reply.NamedTables {
+		fmt.Println(n)
+	}
+}
+
+func (self *Table) export(core *commands.Core) {
+	qry := map[string]string{"name": self.Name, "format": self.format(self.Out)}
+	if self.Filename != "" {
+		qry["filename"] = self.Filename
+	}
+	data := core.Rest.Get("table/export", qry)
+	if self.Out == "" {
+		fmt.Print(data)
+		return
+	}
+	if err := os.WriteFile(self.Out, []byte(data), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "table: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (self *Table) importTable(core *commands.Core) {
+	var data []byte
+	var err error
+	if self.In == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(self.In)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "table: %v\n", err)
+		os.Exit(1)
+	}
+	req := common.TableImportRequest{
+		Name:     self.Name,
+		Filename: self.Filename,
+		Format:   self.format(self.In),
+		Data:     string(data),
+		Append:   self.Append,
+		Header:   self.Header,
+	}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, "table/import", &req, &reply)
+	if reply.Ok {
+		fmt.Printf("OK: %s\n", reply.Msg)
+	} else {
+		fmt.Printf("Err: %s\n", reply.Msg)
+	}
+}
+
+func (self *Table) Exec(core *commands.Core) {
+	sub := "list"
+	if self.fset != nil && self.fset.NArg() > 0 {
+		// Flags can follow the sub command as well as come before it.
+		args := self.fset.Args()
+		sub = args[0]
+		if err := self.fset.Parse(args[1:]); err != nil {
+			os.Exit(1)
+		}
+	}
+	if sub != "list" && self.Name == "" {
+		fmt.Fprintf(os.Stderr, "table %s: -name is required\n", sub)
+		os.Exit(1)
+	}
+	switch sub {
+	case "list":
+		self.list(core)
+	case "export":
+		self.export(core)
+	case "import":
+		self.importTable(core)
+	default:
+		fmt.Fprintf(os.Stderr, "table: unknown sub command %s, expected list, export or import\n", sub)
+		os.Exit(1)
+	}
+}
+
+// init function is called at boot
+func init() {
+	commands.AddCmd("table", "list, export or import named tables",
+		func() commands.Cmd {
+			return &Table{}
+		})
+}
@@ -0,0 +1,72 @@
+package undo
+
+// Undo the last change made through the server.
+//
+// oc undo         undo your last change
+// oc undo -redo   apply the last undone change again
+// oc undo -list   show what can be undone and redone
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ihdavids/orgs/cmd/oc/commands"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+type Undo struct {
+	Redo bool
+	List bool
+}
+
+func (self *Undo) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *Undo) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *Undo) SetupParameters(fset *flag.FlagSet) {
+	fset.BoolVar(&self.Redo, "redo", false, "redo the last undone change")
+	fset.BoolVar(&self.List, "list", false, "list the changes that can be undone")
+}
+
+func (self *Undo) Exec(core *commands.Core) {
+	if self.List {
+		var reply []common.JournalEntry
+		commands.SendReceiveGet(core, "journal", nil, &reply)
+		if len(reply) == 0 {
+			fmt.Println("Nothing to undo")
+			return
+		}
+		for _, e := range reply {
+			state := "undo"
+			if e.Undone {
+				state = "redo"
+			}
+			fmt.Printf("%4d %s %s %-20s %s\n", e.Id, state, e.Time.Format("2006-01-02 15:04"), e.Op, strings.Join(e.Files, ", "))
+		}
+		return
+	}
+	name := "undo"
+	if self.Redo {
+		name = "redo"
+	}
+	var req struct{}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, name, &req, &reply)
+	if reply.Ok {
+		fmt.Printf("OK: %s\n", reply.Msg)
+	} else {
+		fmt.Printf("Err: %s\n", reply.Msg)
+	}
+}
+
+// init function is called at boot
+func init() {
+	commands.AddCmd("undo", "undo or redo your last change",
+		func() commands.Cmd {
+			return &Undo{}
+		})
+}
@@ -0,0 +1,225 @@
+//lint:file-ignore ST1006 allow the use of self
+package user
+
+// User management commands: add users, reset passwords, change roles
+// and enable or disable accounts in the server keystore.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ihdavids/orgs/cmd/oc/commands"
+	"github.com/ihdavids/orgs/internal/common"
+	"golang.org/x/term"
+)
+
+func promptPassword(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	pw, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return ""
+	}
+	return string(pw)
+}
+
+func printResult(reply common.ResultMsg) {
+	if reply.Ok {
+		fmt.Printf("OK: %s\n", reply.Msg)
+	} else {
+		fmt.Printf("Err: %s\n", reply.Msg)
+	}
+}
+
+// ---------------- useradd ----------------
+
+type UserAdd struct {
+	Username string
+	Password string
+	Role     string
+}
+
+func (self *UserAdd) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *UserAdd) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *UserAdd) SetupParameters(fset *flag.FlagSet) {
+	fset.StringVar(&self.Username, "user", "", "name of the user to add")
+	fset.StringVar(&self.Password, "password", "", "initial password (prompted if omitted)")
+	fset.StringVar(&self.Role, "role", "read-write", "read-only, read-write or admin")
+}
+
+func (self *UserAdd) Exec(core *commands.Core) {
+	if self.Username == "" {
+		fmt.Fprintln(os.Stderr, "useradd: -user is required")
+		os.Exit(1)
+	}
+	if self.Password == "" {
+		self.Password = promptPassword("Password: ")
+	}
+	change := common.UserChange{Username: self.Username, Password: self.Password, Role: self.Role}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, "user/add", &change, &reply)
+	printResult(reply)
+}
+
+// ---------------- passwd ----------------
+
+type Passwd struct {
+	Username    string
+	Password    string
+	OldPassword string
+}
+
+func (self *Passwd) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *Passwd) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *Passwd) SetupParameters(fset *flag.FlagSet) {
+	fset.StringVar(&self.Username, "user", "", "user whose password should be changed")
+	fset.StringVar(&self.Password, "password", "", "new password (prompted if omitted)")
+	fset.StringVar(&self.OldPassword, "old", "", "current password, needed when changing your own as a non admin")
+}
+
+func (self *Passwd) Exec(core *commands.Core) {
+	if self.Username == "" {
+		fmt.Fprintln(os.Stderr, "passwd: -user is required")
+		os.Exit(1)
+	}
+	if self.Password == "" {
+		self.Password = promptPassword("New Password: ")
+		if again := promptPassword("Confirm Password: "); again != self.Password {
+			fmt.Fprintln(os.Stderr, "passwd: passwords do not match")
+			os.Exit(1)
+		}
+	}
+	change := common.UserChange{Username: self.Username, Password: self.Password, OldPassword: self.OldPassword}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, "user/password", &change, &reply)
+	printResult(reply)
+}
+
+// ---------------- userdisable ----------------
+
+type UserDisable struct {
+	Username string
+	Enable   bool
+}
+
+func (self *UserDisable) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *UserDisable) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *UserDisable) SetupParameters(fset *flag.FlagSet) {
+	fset.StringVar(&self.Username, "user", "", "user to disable")
+	fset.BoolVar(&self.Enable, "enable", false, "re-enable the account instead of disabling it")
+}
+
+func (self *UserDisable) Exec(core *commands.Core) {
+	if self.Username == "" {
+		fmt.Fprintln(os.Stderr, "userdisable: -user is required")
+		os.Exit(1)
+	}
+	change := common.UserChange{Username: self.Username, Disabled: !self.Enable}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, "user/disable", &change, &reply)
+	printResult(reply)
+}
+
+// ---------------- userrole ----------------
+
+type UserRole struct {
+	Username string
+	Role     string
+}
+
+func (self *UserRole) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *UserRole) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *UserRole) SetupParameters(fset *flag.FlagSet) {
+	fset.StringVar(&self.Username, "user", "", "user to change")
+	fset.StringVar(&self.Role, "role", "", "read-only, read-write or admin")
+}
+
+func (self *UserRole) Exec(core *commands.Core) {
+	if self.Username == "" || self.Role == "" {
+		fmt.Fprintln(os.Stderr, "userrole: -user and -role are required")
+		os.Exit(1)
+	}
+	change := common.UserChange{Username: self.Username, Role: self.Role}
+	var reply common.ResultMsg
+	commands.SendReceivePost(core, "user/role", &change, &reply)
+	printResult(reply)
+}
+
+// ---------------- users ----------------
+
+type Users struct {
+}
+
+func (self *Users) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *Users) StartPlugin(manager *common.PluginManager) {
+}
+
+func (self *Users) SetupParameters(fset *flag.FlagSet) {
+}
+
+func (self *Users) Exec(core *commands.Core) {
+	var qry map[string]string = map[string]string{}
+	var users []common.UserInfo
+	commands.SendReceiveGet(core, "users", qry, &users)
+	for _, u := range users {
+		flags := ""
+		if u.Disabled {
+			flags += " disabled"
+		}
+		last := "never"
+		if !u.LastLogin.IsZero() {
+			last = u.LastLogin.Local().Format(time.RFC822)
+		}
+		fmt.Printf("%-20s %-10s last login: %-22s%s\n", u.Username, u.Role, last, flags)
+	}
+}
+
+// init function is called at boot
+func init() {
+	commands.AddCmd("useradd", "add a user to the server keystore",
+		func() commands.Cmd {
+			return &UserAdd{}
+		})
+	commands.AddCmd("passwd", "change the password of a user",
+		func() commands.Cmd {
+			return &Passwd{}
+		})
+	commands.AddCmd("userdisable", "disable or re-enable a user account",
+		func() commands.Cmd {
+			return &UserDisable{}
+		})
+	commands.AddCmd("userrole", "change the role of a user",
+		func() commands.Cmd {
+			return &UserRole{}
+		})
+	commands.AddCmd("users", "list the users in the server keystore",
+		func() commands.Cmd {
+			return &Users{}
+		})
+}
@@ -6,7 +6,6 @@ toolchain go1.24.2
 
 require (
 	github.com/apognu/gocal v0.9.0
-	github.com/bzick/tokenizer v1.4.0
 	github.com/coryb/oreo v0.0.0-20180804211640-3e1b88fc08f1
 	github.com/dietsche/rfsnotify v0.0.0-20200716145600-b37be6e4177f
 	github.com/ekalinin/go-textwrap v0.0.2
@@ -29,6 +28,7 @@ require (
 	github.com/rivo/tview v0.0.0-20241103174730-c76f7879f592
 	github.com/rs/cors v1.9.0
 	github.com/tmc/keyring v0.0.0-20230418032330-0c8bdba76fa8
+	golang.org/x/crypto v0.41.0
 	golang.org/x/oauth2 v0.0.0-20221014153046-6fdb5e3db783
 	google.golang.org/api v0.99.0
 	gopkg.in/AlecAivazis/survey.v1 v1.6.1
@@ -68,7 +68,6 @@ require (
 	github.com/tadvi/systray v0.0.0-20190226123456-11a2b8fa57af // indirect
 	github.com/theckman/go-flock v0.4.0 // indirect
 	go.opencensus.io v0.23.0 // indirect
-	golang.org/x/crypto v0.41.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20221010155953-15ba04fc1c0e // indirect
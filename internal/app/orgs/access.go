@@ -0,0 +1,307 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+const (
+	RoleReadOnly  = "read-only"
+	RoleReadWrite = "read-write"
+	RoleAdmin     = "admin"
+)
+
+type AccessLevel int
+
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessWrite
+	AccessAdmin
+)
+
+func IsValidRole(role string) bool {
+	return role == RoleReadOnly || role == RoleReadWrite || role == RoleAdmin
+}
+
+func roleLevel(role string) AccessLevel {
+	switch role {
+	case RoleReadOnly:
+		return AccessRead
+	case RoleReadWrite:
+		return AccessWrite
+	case RoleAdmin:
+		return AccessAdmin
+	}
+	return AccessNone
+}
+
+// An empty username is the server itself (pollers, internal queries)
+// and with auth disabled everyone is effectively an admin.
+func userLevel(username string) AccessLevel {
+	if Conf().Server.NoAuth || username == "" {
+		return AccessAdmin
+	}
+	return roleLevel(GetKeystore().GetRole(username))
+}
+
+func ruleMatchesUser(rule *common.AccessRule, username string) bool {
+	for _, u := range rule.Users {
+		if u == "*" || u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Match a directory glob against a filename. A pattern matches a file
+// if it matches the file itself or any directory the file lives in.
+func matchPathGlob(pattern string, filename string) bool {
+	if strings.HasPrefix(pattern, "~") {
+		if home, err := os.UserHomeDir(); err == nil {
+			pattern = filepath.Join(home, pattern[1:])
+		}
+	}
+	if !filepath.IsAbs(pattern) {
+		for _, dir := range Conf().Server.OrgDirs {
+			if abs, err := filepath.Abs(dir); err == nil && matchPathGlob(filepath.Join(abs, pattern), filename) {
+				return true
+			}
+		}
+		return false
+	}
+	pattern = filepath.Clean(pattern)
+	if strings.HasSuffix(pattern, string(filepath.Separator)+"**") {
+		pattern = strings.TrimSuffix(pattern, string(filepath.Separator)+"**")
+	}
+	for p := filepath.Clean(filename); ; p = filepath.Dir(p) {
+		if ok, err := filepath.Match(pattern, p); err == nil && ok {
+			return true
+		}
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	return false
+}
+
+func ruleMatchesFile(rule *common.AccessRule, filename string, ofile *common.OrgFile) bool {
+	if len(rule.Paths) == 0 && len(rule.FileTags) == 0 {
+		return true
+	}
+	for _, p := range rule.Paths {
+		if matchPathGlob(p, filename) {
+			return true
+		}
+	}
+	if ofile != nil && ofile.Doc != nil {
+		for _, t := range rule.FileTags {
+			if HasFileTag(t, ofile.Doc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Resolve a filename from a request to a path inside the org dirs,
+// symlinks included. False when the file lives anywhere else.
+func ResolveOrgPath(filename string) (string, bool) {
+	if filename == "" {
+		return "", false
+	}
+	if ofile := GetDb().FindByFile(filename); ofile != nil {
+		filename = ofile.Filename
+	} else if !filepath.IsAbs(filename) {
+		filename = GetDb().GetFilepath(filename)
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false
+	}
+	resolved := abs
+	if p, err := filepath.EvalSymlinks(abs); err == nil {
+		resolved = p
+	} else if p, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		// The file may not exist yet, its directory has to.
+		resolved = filepath.Join(p, filepath.Base(abs))
+	}
+	for _, dir := range Conf().Server.OrgDirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if p, err := filepath.EvalSymlinks(root); err == nil {
+			root = p
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return abs, true
+		}
+	}
+	return "", false
+}
+
+// The access a user has to a file. Access rules can never raise
+// a user above the role they have in the keystore. Nothing outside
+// the org dirs can be reached, whatever the role.
+func FileAccess(username string, filename string) AccessLevel {
+	ofile := GetDb().FindByFile(filename)
+	path, ok := ResolveOrgPath(filename)
+	if !ok {
+		return AccessNone
+	}
+	lvl := userLevel(username)
+	if lvl == AccessAdmin || lvl == AccessNone || len(Conf().Server.Access) == 0 {
+		return lvl
+	}
+	filename = path
+	if ofile != nil {
+		filename = ofile.Filename
+	}
+	best := AccessNone
+	for i := range Conf().Server.Access {
+		rule := &Conf().Server.Access[i]
+		if ruleMatchesUser(rule, username) && ruleMatchesFile(rule, filename, ofile) {
+			if l := roleLevel(rule.Role); l > best {
+				best = l
+			}
+		}
+	}
+	if best > lvl {
+		best = lvl
+	}
+	return best
+}
+
+func CanReadFile(username string, filename string) bool {
+	return FileAccess(username, filename) >= AccessRead
+}
+
+func CanWriteFile(username string, filename string) bool {
+	return FileAccess(username, filename) >= AccessWrite
+}
+
+// True when the user is not restricted to a subset of the files.
+func HasFullAccess(username string, level AccessLevel) bool {
+	lvl := userLevel(username)
+	return lvl == AccessAdmin || (len(Conf().Server.Access) == 0 && lvl >= level)
+}
+
+func FilterFiles(username string, files []string) []string {
+	res := []string{}
+	for _, f := range files {
+		if CanReadFile(username, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// The tags used in the files a user can read.
+func FilterTags(username string) []string {
+	if HasFullAccess(username, AccessRead) {
+		return GetDb().GetAllTags()
+	}
+	tags := []string{}
+	var walk func(secs []*org.Section)
+	walk = func(secs []*org.Section) {
+		for _, sec := range secs {
+			for _, t := range sec.Headline.Tags {
+				if !contains(tags, t) {
+					tags = append(tags, t)
+				}
+			}
+			walk(sec.Children)
+		}
+	}
+	for _, fname := range FilterFiles(username, GetDb().GetFiles()) {
+		if f := GetDb().FindByFile(fname); f != nil && f.Doc != nil {
+			walk(f.Doc.Outline.Children)
+		}
+	}
+	return tags
+}
+
+func FilterTodos(username string, todos *common.Todos) *common.Todos {
+	if todos == nil {
+		return todos
+	}
+	res := common.Todos{}
+	for _, t := range *todos {
+		if CanReadFile(username, t.Filename) {
+			res = append(res, t)
+		}
+	}
+	return &res
+}
+
+// ---------------------------------------------------------------------------
+// REST helpers, these write a 403 and return false when access is denied.
+// ---------------------------------------------------------------------------
+
+func denyAccess(w http.ResponseWriter, what string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "access denied: " + what})
+}
+
+func requireFileAccess(w http.ResponseWriter, r *http.Request, filename string, level AccessLevel) bool {
+	if FileAccess(GetUsername(r), filename) < level {
+		denyAccess(w, filename)
+		return false
+	}
+	return true
+}
+
+// Unknown hashes are let through, the handler will report them as not found.
+func requireHashAccess(w http.ResponseWriter, r *http.Request, hash string, level AccessLevel) bool {
+	if _, f := GetDb().LookupHash(hash); f != nil {
+		return requireFileAccess(w, r, f.Filename, level)
+	}
+	return true
+}
+
+// The file a target lives in, empty when it cannot be resolved.
+func targetFilename(t *common.Target) string {
+	if t == nil {
+		return ""
+	}
+	if t.Type == "hash" {
+		if _, f := GetDb().LookupHash(t.Id); f != nil {
+			return f.Filename
+		}
+		return ""
+	}
+	if f, _ := GetDb().GetFromTarget(t, false); f != nil {
+		return f.Filename
+	}
+	return t.Filename
+}
+
+func CanReadTarget(username string, t *common.Target) bool {
+	fname := targetFilename(t)
+	return fname == "" || CanReadFile(username, fname)
+}
+
+// Unresolvable targets are let through, the handler will report them.
+func requireTargetAccess(w http.ResponseWriter, r *http.Request, t *common.Target, level AccessLevel) bool {
+	if fname := targetFilename(t); fname != "" {
+		return requireFileAccess(w, r, fname, level)
+	}
+	return true
+}
+
+func requireFullAccess(w http.ResponseWriter, r *http.Request, level AccessLevel) bool {
+	if !HasFullAccess(GetUsername(r), level) {
+		denyAccess(w, "this request spans files you do not have access to")
+		return false
+	}
+	return true
+}
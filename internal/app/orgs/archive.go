@@ -1,7 +1,45 @@
 //lint:file-ignore ST1006 allow the use of self
 package orgs
 
+/* SDOC: Editing
+* Archiving
+
+  =/api/archive= moves a subtree to the location given by the ARCHIVE
+  property of the heading (or one of its parents), the =#+ARCHIVE:= line
+  of the file or the archiveDefault setting, in that order. The location
+  is a =file::heading= pair:
+
+  | Location                        | Archives to                                        |
+  |---------------------------------+----------------------------------------------------|
+  | =%s_archive::=                  | the end of =<file>_archive=                        |
+  | =archive.org::* Old Tasks=      | under the =Old Tasks= heading of archive.org       |
+  | =%s_archive::datetree/=         | a date tree for today in =<file>_archive=          |
+  | =::datetree/* Finished=         | a date tree under =Finished= in the same file      |
+
+  Date trees use the dateTreeYearFormat, dateTreeMonthFormat and
+  dateTreeDayFormat settings.
+
+  Like org mode an entry can also be archived in place:
+  - =/api/archive/tag= toggles the =:ARCHIVE:= tag on the heading.
+    Archived headings are skipped by most queries, see IsArchived.
+  - =/api/archive/sibling= moves the heading under a sibling called
+    =Archive= (the archiveSiblingHeading setting), creating the
+    sibling with an =:ARCHIVE:= tag if it does not exist yet.
+
+  =/api/archive/bulk= archives every DONE heading that matches a query and
+  was closed more than a given number of days ago. Each subtree is
+  archived once, done children of a heading that is archived go with it.
+
+  #+BEGIN_SRC json
+  { "query": "HasAStatus() && !IsActive() && !IsArchived()", "days": 30, "mode": "archive", "dryRun": true }
+  #+END_SRC
+
+  Mode is one of =archive=, =sibling= or =tag=. With dryRun set nothing is
+  changed and the headings that would be archived are returned.
+EDOC */
+
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"slices"
@@ -13,19 +51,33 @@ import (
 
 var headlineRegexp = regexp.MustCompile(`^([*]+)\s+(.*)`)
 
+const (
+	archiveDateTree = "datetree/"
+	archiveTag      = "ARCHIVE"
+)
+
+// The ARCHIVE property is looked up on the heading and its parents,
+// the innermost one wins.
+func archiveLocation(file *common.OrgFile, sec *org.Section) string {
+	for s := sec; s != nil; s = s.Parent {
+		if s.Headline != nil && s.Headline.Properties != nil {
+			if at, ok := s.Headline.Properties.Get("ARCHIVE"); ok {
+				return at
+			}
+		}
+	}
+	// File level properties
+	if at := file.Doc.Get("ARCHIVE"); at != "" {
+		return at
+	}
+	// Default global setting
+	return Conf().ArchiveDefaultTarget
+}
+
 func FindArchiveTarget(db common.ODb, tgt *common.Target) *common.Target {
 	fromFile, fromSecs := db.GetFromTarget(tgt, false)
 	if fromFile != nil && fromSecs != nil {
-		// Default global setting
-		archiveTarget := Conf().ArchiveDefaultTarget
-		// File level properties
-		if at := fromFile.Doc.Get("ARCHIVE"); at != "" {
-			archiveTarget = at
-		}
-		// Node level properties
-		if at, ok := fromSecs.Headline.Properties.Get("ARCHIVE"); ok {
-			archiveTarget = at
-		}
+		archiveTarget := archiveLocation(fromFile, fromSecs)
 
 		// Now find the filename
 		vals := strings.Split(archiveTarget, "::")
@@ -43,6 +95,18 @@ func FindArchiveTarget(db common.ODb, tgt *common.Target) *common.Target {
 			fname = fromFile.Filename
 			isSameFile = true
 		}
+		if strings.HasPrefix(heading, archiveDateTree) {
+			// datetree/ files the entry under todays date, datetree/* Heading
+			// builds the date tree below that heading.
+			heading = strings.TrimSpace(strings.TrimPrefix(heading, archiveDateTree))
+			if heading == "" {
+				return &common.Target{Filename: fname, Type: "file+datetree"}
+			}
+			if m := headlineRegexp.FindStringSubmatch(heading); m != nil {
+				return &common.Target{Filename: fname, Type: "file+olp+datetree", Id: m[2]}
+			}
+			return nil
+		}
 		if heading == "" {
 			// This is not allowed! We HAVE to have a new heading
 			// So just abort
@@ -53,13 +117,11 @@ func FindArchiveTarget(db common.ODb, tgt *common.Target) *common.Target {
 			res := common.Target{Filename: fname, Type: "file"}
 			return &res
 		} else {
-			// We do not handle datetree yet
 			if m := headlineRegexp.FindStringSubmatch(heading); m != nil {
-				res := common.Target{Filename: fname, Type: "file+heading", Id: m[2], Lvl: len(m[1])}
+				res := common.Target{Filename: fname, Type: "file+headline", Id: m[2], Lvl: len(m[1])}
 				return &res
 			}
 		}
-		// TODO: Need datetree capabilities
 	}
 
 	return nil
@@ -156,7 +218,7 @@ func fixupArchiveHeading(ofile *common.OrgFile, sec *org.Section) *org.Section {
 	return c
 }
 
-func Archive(db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
+func Archive(ctx context.Context, db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
 	var res common.ResultMsg = common.ResultMsg{}
 	// Find the archive target
 	// Refile to the archive target
@@ -166,7 +228,7 @@ func Archive(db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
 		refile := common.Refile{FromId: *tgt, ToId: *archiveTgt}
 		// This does not quite work because we need to add a bunch of properties to the
 		// copied section
-		return Refile(db, &refile, fixupArchiveHeading, true)
+		return Refile(ctx, db, &refile, fixupArchiveHeading, true)
 	} else {
 		fmt.Printf("Could not find archive target. ABORT")
 	}
@@ -174,3 +236,186 @@ func Archive(db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
 	res.Msg = "failed to find archive target"
 	return res, fmt.Errorf("failed to find archive target")
 }
+
+// Add or remove the ARCHIVE tag on a heading.
+func setArchiveTag(sec *org.Section, on bool) bool {
+	tags := []string{}
+	had := false
+	for _, t := range sec.Headline.Tags {
+		if strings.EqualFold(t, archiveTag) {
+			had = true
+			continue
+		}
+		tags = append(tags, t)
+	}
+	if on {
+		tags = append(tags, archiveTag)
+	}
+	sec.Headline.Tags = tags
+	return had != on
+}
+
+func ToggleArchiveTag(ctx context.Context, db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
+	res := common.ResultMsg{}
+	file, sec := db.GetFromTarget(tgt, false)
+	if file == nil || sec == nil || sec.Headline == nil {
+		res.Msg = "failed to find heading to tag"
+		return res, fmt.Errorf("%s", res.Msg)
+	}
+	on := !slices.ContainsFunc(sec.Headline.Tags, func(t string) bool { return strings.EqualFold(t, archiveTag) })
+	setArchiveTag(sec, on)
+	if !WriteOutOrgFile(ctx, file, sec.Hash) {
+		res.Msg = "failed to write " + file.Filename
+		return res, fmt.Errorf("%s", res.Msg)
+	}
+	res.Ok = true
+	res.Msg = "untagged"
+	if on {
+		res.Msg = "tagged"
+	}
+	return res, nil
+}
+
+func ArchiveToSibling(ctx context.Context, db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
+	res := common.ResultMsg{}
+	file, sec := db.GetFromTarget(tgt, false)
+	if file == nil || sec == nil || sec.Headline == nil {
+		res.Msg = "failed to find heading to archive"
+		return res, fmt.Errorf("%s", res.Msg)
+	}
+	heading := Conf().ArchiveSiblingHeading
+	sibling := common.Target{Type: "file+olp", Filename: file.Filename, Id: heading}
+	if sec.Parent != nil && sec.Parent.Headline != nil {
+		if path := common.BuildOutlinePath(sec.Parent, "::"); path != "" {
+			if common.GetSectionTitle(sec.Parent) == heading {
+				res.Msg = "heading is already in the archive sibling"
+				return res, fmt.Errorf("%s", res.Msg)
+			}
+			sibling.Id = path + "::" + heading
+		}
+	}
+	from := common.Target{Type: "hash", Id: sec.Hash}
+	sfile, ssec := targetForWrite(ctx, db, &sibling, true)
+	if sfile == nil || ssec == nil {
+		res.Msg = "failed to create the archive sibling " + sibling.Id
+		return res, fmt.Errorf("%s", res.Msg)
+	}
+	if setArchiveTag(ssec, true) {
+		WriteOutOrgFile(ctx, sfile, ssec.Hash)
+		GetDb().ReloadFile(sfile.Filename)
+	}
+	return Refile(ctx, db, &common.Refile{FromId: from, ToId: sibling}, fixupArchiveHeading, false)
+}
+
+const defaultBulkArchiveQuery = "HasAStatus() && !IsActive() && !IsArchived()"
+
+// Archive every done heading matching the query that was closed more than
+// req.Days ago. Only headings in files the user can write are touched,
+// headings whose archive file the user cannot write are reported as failed.
+func ArchiveBulk(ctx context.Context, db common.ODb, req *common.ArchiveBulkRequest, username string) (common.ArchiveBulkResult, error) {
+	res := common.ArchiveBulkResult{Archived: []string{}, Failed: []string{}}
+	query := req.Query
+	if query == "" {
+		query = defaultBulkArchiveQuery
+	}
+	mode := req.Mode
+	if mode == "" {
+		mode = "archive"
+	}
+	if mode != "archive" && mode != "sibling" && mode != "tag" {
+		res.Msg = fmt.Sprintf("unknown archive mode %q, expected archive, sibling or tag", mode)
+		return res, fmt.Errorf("%s", res.Msg)
+	}
+	tds, err := db.QueryTodosExpr(query)
+	if err != nil {
+		res.Msg = fmt.Sprintf("failed to query expression, %v [%s]", err, query)
+		return res, fmt.Errorf("%s", res.Msg)
+	}
+	cutoff := Today().AddDate(0, 0, -req.Days)
+	picked := map[*org.Section]bool{}
+	secs := []*org.Section{}
+	files := map[*org.Section]*common.OrgFile{}
+	for _, td := range tds {
+		file, sec := db.GetFromTarget(&common.Target{Type: "hash", Id: td.Hash}, false)
+		if file == nil || sec == nil || !IsDone(sec, file) || !CanWriteFile(username, file.Filename) {
+			continue
+		}
+		if req.Days > 0 {
+			if closed, ok := sdcStart(sec.Headline.Closed); !ok || !closed.Before(cutoff) {
+				continue
+			}
+		}
+		picked[sec] = true
+		secs = append(secs, sec)
+		files[sec] = file
+	}
+	// Children go along with their parent.
+	tops := []*org.Section{}
+	for _, sec := range secs {
+		nested := false
+		for p := sec.Parent; p != nil; p = p.Parent {
+			if picked[p] {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			tops = append(tops, sec)
+		}
+	}
+	// Headings are archived by hash, a hash that goes stale as the file is
+	// rewritten is still found.
+	for _, sec := range tops {
+		t := common.Target{Type: "hash", Id: sec.Hash}
+		name := files[sec].Filename + "::" + common.BuildOutlinePath(sec, "::")
+		if mode == "archive" {
+			// The archive file gets written too.
+			if at := FindArchiveTarget(db, &t); at != nil && !CanWriteFile(username, at.Filename) {
+				res.Failed = append(res.Failed, name)
+				continue
+			}
+		}
+		if req.DryRun {
+			res.Archived = append(res.Archived, name)
+			continue
+		}
+		var msg common.ResultMsg
+		var err error
+		reload := []string{files[sec].Filename}
+		switch mode {
+		case "tag":
+			file, sec := db.GetFromTarget(&t, false)
+			if sec == nil {
+				err = fmt.Errorf("failed to find heading")
+			} else if setArchiveTag(sec, true) && !WriteOutOrgFile(ctx, file, sec.Hash) {
+				err = fmt.Errorf("failed to write %s", file.Filename)
+			} else {
+				msg.Ok = true
+			}
+		case "sibling":
+			msg, err = ArchiveToSibling(ctx, db, &t)
+		default:
+			if at := FindArchiveTarget(db, &t); at != nil {
+				reload = append(reload, at.Filename)
+			}
+			msg, err = Archive(ctx, db, &t)
+		}
+		for _, f := range reload {
+			GetDb().ReloadFile(f)
+		}
+		if err != nil || !msg.Ok {
+			res.Failed = append(res.Failed, name)
+			continue
+		}
+		res.Archived = append(res.Archived, name)
+	}
+	res.Ok = len(res.Failed) == 0
+	res.Msg = fmt.Sprintf("archived %d headings", len(res.Archived))
+	if req.DryRun {
+		res.Msg = fmt.Sprintf("would archive %d headings", len(res.Archived))
+	}
+	if len(res.Failed) > 0 {
+		res.Msg += fmt.Sprintf(", %d failed", len(res.Failed))
+	}
+	return res, nil
+}
@@ -2,7 +2,6 @@ package orgs
 
 import (
 	"context"
-	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -34,9 +33,6 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hsh := sha1.New()
-	hsh.Write([]byte(creds.Password))
-
 	if ok := GetKeystore().Validate(creds.Username, creds.Password); !ok {
 		fmt.Printf("Failed validate\n")
 		w.WriteHeader(http.StatusUnauthorized)
@@ -94,6 +90,11 @@ func refresh(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+	if GetKeystore().IsDisabled(claims.Username) {
+		fmt.Printf("Refresh: account is disabled: %s\n", claims.Username)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
 	token, expiresAt, err := GenerateEncryptedToken(claims.Username)
 	if err != nil {
@@ -145,6 +146,11 @@ func authenticate(next http.Handler) http.Handler {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		if GetKeystore().IsDisabled(claims.Username) {
+			fmt.Printf("Failed to authenticate, account disabled: %s\n", claims.Username)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		fmt.Printf("AUTHENTICATION OKAY\n")
 		ctx := context.WithValue(r.Context(), contextKeyUsername, claims.Username)
 		next.ServeHTTP(w, r.WithContext(ctx))
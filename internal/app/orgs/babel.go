@@ -0,0 +1,739 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Babel Languages
+
+  Orgs ships with executors for the following languages:
+
+  | Language            | Runs                         | :var binding                        |
+  |---------------------+------------------------------+-------------------------------------|
+  | sh, shell           | =sh=                         | shell assignment                    |
+  | bash, zsh           | =bash= / =zsh=               | shell assignment                    |
+  | python, python3     | =python3= (or =:python=)     | python literal, tables become lists |
+  | go                  | =go run=                     | package level =var=                 |
+  | sqlite              | =sqlite3 :db=                | =$name= is replaced in the body     |
+  | js, node            | =node=                       | environment variable                |
+  | ruby, perl          | =ruby= / =perl=              | environment variable                |
+
+  Go blocks without a =package= clause are wrapped in a =main= function.
+  Use =:imports "fmt" "os"= to control the import list of the wrapper.
+
+  Sessions are only supported by the shell and python executors. Other
+  languages ignore the =:session= header argument. A session that has not
+  been used for babelSessionTimeout minutes is shut down.
+
+  Nothing is executed unless babelEnabled is set, the language is listed
+  in babelLanguages and the request comes from an admin. See Babel
+  Execution in the settings.
+EDOC */
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+const babelDefaultTimeout = 60 * time.Second
+
+// How the result of a block should be collected, inserted and formatted.
+type BabelResults struct {
+	Collection string // output | value
+	Handling   string // replace | append | prepend | silent
+	Format     string // verbatim | raw | table
+}
+
+func ParseBabelResults(results string) BabelResults {
+	res := BabelResults{Collection: "value", Handling: "replace", Format: "verbatim"}
+	for _, w := range strings.Fields(strings.ToLower(results)) {
+		switch w {
+		case "output", "value":
+			res.Collection = w
+		case "replace", "append", "prepend", "silent", "none":
+			res.Handling = w
+		case "raw", "org", "drawer":
+			res.Format = "raw"
+		case "table", "vector", "list":
+			res.Format = "table"
+		case "verbatim", "scalar":
+			res.Format = "verbatim"
+		}
+	}
+	if res.Handling == "none" {
+		res.Handling = "silent"
+	}
+	return res
+}
+
+// Split a header argument string of the form ":key value :key value"
+// into key value pairs. Keys may repeat (:var), quotes are respected.
+func splitHeaderArgs(args string) [][2]string {
+	res := [][2]string{}
+	toks := []string{}
+	cur := strings.Builder{}
+	inQuote := false
+	for _, r := range args {
+		if r == '"' {
+			inQuote = !inQuote
+		}
+		if !inQuote && (r == ' ' || r == '\t') {
+			if cur.Len() > 0 {
+				toks = append(toks, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteRune(r)
+	}
+	if cur.Len() > 0 {
+		toks = append(toks, cur.String())
+	}
+	for _, t := range toks {
+		if strings.HasPrefix(t, ":") {
+			res = append(res, [2]string{t, ""})
+		} else if len(res) > 0 {
+			last := &res[len(res)-1]
+			if last[1] != "" {
+				last[1] += " "
+			}
+			last[1] += t
+		}
+	}
+	return res
+}
+
+func headlineProp(sec *org.Section, name string) string {
+	if sec == nil || sec.Headline.Properties == nil {
+		return ""
+	}
+	if v, ok := sec.Headline.Properties.Get(name); ok {
+		return v
+	}
+	return ""
+}
+
+// Gather header arguments from the document, the parent headings
+// and the block itself. Later sources override earlier ones, except
+// for :var which accumulates.
+func babelHeaderArgs(ofile *common.OrgFile, sec *org.Section, blk *org.Block, lang string) (map[string]string, []string) {
+	sources := []string{}
+	if ofile != nil && ofile.Doc != nil {
+		sources = append(sources, ofile.Doc.Get("header-args"), ofile.Doc.Get("header-args:"+lang))
+	}
+	secs := []*org.Section{}
+	for s := sec; s != nil; s = s.Parent {
+		secs = append([]*org.Section{s}, secs...)
+	}
+	for _, s := range secs {
+		sources = append(sources, headlineProp(s, "header-args"), headlineProp(s, "header-args:"+lang))
+	}
+	if len(blk.Parameters) > 1 {
+		sources = append(sources, strings.Join(blk.Parameters[1:], " "))
+	}
+	params := map[string]string{}
+	vars := []string{}
+	for _, src := range sources {
+		for _, kv := range splitHeaderArgs(src) {
+			if kv[0] == ":var" {
+				vars = append(vars, kv[1])
+			} else {
+				params[kv[0]] = kv[1]
+			}
+		}
+	}
+	return params, vars
+}
+
+var babelVarRe = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\s*=\s*("(?:[^"\\]|\\.)*"|[^\s,]+)`)
+
+func tableToRows(tbl *org.Table) [][]string {
+	w := org.NewOrgWriter()
+	rows := [][]string{}
+	for _, row := range tbl.Rows {
+		// Separator rows have no columns
+		if len(row.Columns) == 0 {
+			continue
+		}
+		cols := []string{}
+		for _, col := range row.Columns {
+			cols = append(cols, strings.TrimSpace(w.WriteNodesAsString(col.Children...)))
+		}
+		rows = append(rows, cols)
+	}
+	return rows
+}
+
+// Resolve :var specifications. Quoted values are strings, names of
+// tables are expanded to rows, anything else is passed through as is.
+func resolveBabelVars(ofile *common.OrgFile, specs []string) []common.BabelVar {
+	vars := []common.BabelVar{}
+	filename := ""
+	if ofile != nil {
+		filename = ofile.Filename
+	}
+	for _, spec := range specs {
+		for _, m := range babelVarRe.FindAllStringSubmatch(spec, -1) {
+			name, val := m[1], m[2]
+			if strings.HasPrefix(val, "\"") {
+				if s, err := strconv.Unquote(val); err == nil {
+					val = s
+				} else {
+					val = strings.Trim(val, "\"")
+				}
+				vars = append(vars, common.BabelVar{Name: name, Value: val})
+			} else if tbl := GetDb().GetNamedTable(val, filename); tbl != nil {
+				vars = append(vars, common.BabelVar{Name: name, Value: tableToRows(tbl.Table)})
+			} else {
+				vars = append(vars, common.BabelVar{Name: name, Value: val})
+			}
+		}
+	}
+	return vars
+}
+
+func babelBody(blk *org.Block) string {
+	var sb strings.Builder
+	for _, child := range blk.Children {
+		if t, ok := child.(org.Text); ok {
+			sb.WriteString(t.Content)
+			if !strings.HasSuffix(t.Content, "\n") {
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+func babelDir(ofile *common.OrgFile, dir string) string {
+	base := ""
+	if ofile != nil {
+		base = filepath.Dir(ofile.Filename)
+	}
+	if dir == "" {
+		return base
+	}
+	if strings.HasPrefix(dir, "~") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[1:])
+		}
+	}
+	if !filepath.IsAbs(dir) && base != "" {
+		dir = filepath.Join(base, dir)
+	}
+	return dir
+}
+
+// Build the result node for a block, merging with the existing
+// result when appending or prepending.
+func babelResultNode(old org.Node, out string, rs BabelResults, filename string) org.Node {
+	out = strings.TrimRight(out, "\n")
+	isTable := out != ""
+	for _, l := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(l), "|") {
+			isTable = false
+			break
+		}
+	}
+	if (rs.Format == "raw" || isTable) && rs.Handling == "replace" {
+		doc := org.New().Parse(strings.NewReader(out+"\n"), filename)
+		if doc.Error == nil && len(doc.Nodes) == 1 {
+			return org.Result{Node: doc.Nodes[0]}
+		}
+		return org.Result{Node: org.Text{Content: out + "\n", IsRaw: true}}
+	}
+	lines := []org.Node{}
+	if out != "" {
+		for _, l := range strings.Split(out, "\n") {
+			lines = append(lines, org.Text{Content: l, IsRaw: true})
+		}
+	}
+	if r, ok := old.(org.Result); ok && rs.Handling != "replace" {
+		if ex, ok := r.Node.(org.Example); ok {
+			if rs.Handling == "append" {
+				lines = append(ex.Children, lines...)
+			} else {
+				lines = append(lines, ex.Children...)
+			}
+		}
+	}
+	return org.Result{Node: org.Example{Children: lines}}
+}
+
+// Source blocks run arbitrary code as the server, so they have to be
+// switched on, the language allowed and the user an admin.
+func babelAllowed(username string, lang string) error {
+	if !Conf().BabelEnabled {
+		return fmt.Errorf("babel: execution of source blocks is disabled on this server")
+	}
+	if !contains(Conf().BabelLanguages, lang) {
+		return fmt.Errorf("babel: execution of [%s] blocks is not allowed on this server", lang)
+	}
+	if userLevel(username) < AccessAdmin {
+		return fmt.Errorf("babel: only admins can execute source blocks")
+	}
+	return nil
+}
+
+// MAIN - Execute a SRC block and write the results back into the file.
+func ExecBabelBlock(ctx context.Context, ofile *common.OrgFile, sec *org.Section, blk *org.Block, username string) common.ResultMsg {
+	res := common.ResultMsg{Ok: false, Msg: "Unknown babel error"}
+	if len(blk.Parameters) == 0 {
+		res.Msg = "babel: source block does not specify a language"
+		return res
+	}
+	lang := strings.ToLower(blk.Parameters[0])
+	if err := babelAllowed(username, lang); err != nil {
+		res.Msg = err.Error()
+		return res
+	}
+	method, ok := Conf().PlugManager.BabelExec[lang]
+	if !ok {
+		res.Msg = fmt.Sprintf("babel: no executor registered for language [%s]", lang)
+		return res
+	}
+	params, varSpecs := babelHeaderArgs(ofile, sec, blk, lang)
+	if e, ok := params[":eval"]; ok && (e == "no" || e == "never") {
+		res.Msg = "babel: evaluation of this block is disabled"
+		return res
+	}
+	rs := ParseBabelResults(params[":results"])
+	b := &common.BabelBlock{
+		Lang:     lang,
+		Body:     babelBody(blk),
+		Params:   params,
+		Vars:     resolveBabelVars(ofile, varSpecs),
+		Dir:      babelDir(ofile, params[":dir"]),
+		Results:  rs.Collection,
+		Timeout:  babelDefaultTimeout,
+		Filename: ofile.Filename,
+	}
+	if s, ok := params[":session"]; ok && s != "none" {
+		if s == "" {
+			s = "default"
+		}
+		b.Session = s
+	}
+	if t, ok := params[":timeout"]; ok {
+		if secs, err := strconv.Atoi(t); err == nil && secs > 0 {
+			b.Timeout = time.Duration(secs) * time.Second
+		}
+	}
+	Log().Infof("Babel: executing %s block in %s\n", lang, b.Dir)
+	out, err := method(b)
+	if err != nil {
+		res.Msg = fmt.Sprintf("babel: %s block failed: %v\n%s", lang, err, out)
+		return res
+	}
+	if rs.Handling != "silent" {
+		blk.Result = babelResultNode(blk.Result, out, rs, ofile.Filename)
+		if !WriteOutOrgFile(ctx, ofile, sectionHashes(sec)...) {
+			res.Msg = fmt.Sprintf("babel: failed to write results to %s", ofile.Filename)
+			return res
+		}
+	}
+	res.Ok = true
+	res.Msg = out
+	return res
+}
+
+// Run a process with the source on stdin, capturing stdout and stderr.
+func babelRun(b *common.BabelBlock, stdin string, env []string, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = b.Dir
+	cmd.Stdin = strings.NewReader(stdin)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(out), fmt.Errorf("timed out after %v", b.Timeout)
+	}
+	return string(out), err
+}
+
+func babelVarString(v interface{}, colSep string) string {
+	switch val := v.(type) {
+	case [][]string:
+		rows := []string{}
+		for _, r := range val {
+			rows = append(rows, strings.Join(r, colSep))
+		}
+		return strings.Join(rows, "\n")
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
+
+func babelVarEnv(b *common.BabelBlock) []string {
+	env := []string{}
+	for _, v := range b.Vars {
+		env = append(env, v.Name+"="+babelVarString(v.Value, "\t"))
+	}
+	return env
+}
+
+// ---------------- Sessions ----------------
+
+// A long running interpreter. Code is written to stdin followed by
+// a command that echoes a marker, output is read until the marker.
+type babelSession struct {
+	lock     sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	out      *bufio.Reader
+	marker   string
+	count    int
+	lastUsed time.Time // guarded by babelSessionsLock
+}
+
+var babelSessions = map[string]*babelSession{}
+var babelSessionsLock sync.Mutex
+var babelReaperOnce sync.Once
+
+func babelSessionTimeout() time.Duration {
+	if Conf().BabelSessionTimeout <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(Conf().BabelSessionTimeout) * time.Minute
+}
+
+// Shut down sessions that have sat idle for too long.
+func reapBabelSessions(now time.Time) {
+	babelSessionsLock.Lock()
+	idle := []string{}
+	for key, s := range babelSessions {
+		if now.Sub(s.lastUsed) > babelSessionTimeout() {
+			idle = append(idle, key)
+		}
+	}
+	babelSessionsLock.Unlock()
+	for _, key := range idle {
+		Log().Infof("Babel: closing idle session %s\n", key)
+		closeBabelSession(key)
+	}
+}
+
+func startBabelReaper() {
+	babelReaperOnce.Do(func() {
+		go func() {
+			for now := range time.Tick(time.Minute) {
+				reapBabelSessions(now)
+			}
+		}()
+	})
+}
+
+func getBabelSession(key string, dir string, name string, args ...string) (*babelSession, error) {
+	startBabelReaper()
+	babelSessionsLock.Lock()
+	defer babelSessionsLock.Unlock()
+	if s, ok := babelSessions[key]; ok {
+		s.lastUsed = time.Now()
+		return s, nil
+	}
+	s := &babelSession{marker: fmt.Sprintf("ORGS_BABEL_%d", time.Now().UnixNano()), lastUsed: time.Now()}
+	s.cmd = exec.Command(name, args...)
+	s.cmd.Dir = dir
+	var err error
+	if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.cmd.Stderr = s.cmd.Stdout
+	if err = s.cmd.Start(); err != nil {
+		return nil, err
+	}
+	s.out = bufio.NewReader(stdout)
+	babelSessions[key] = s
+	return s, nil
+}
+
+func closeBabelSession(key string) {
+	babelSessionsLock.Lock()
+	defer babelSessionsLock.Unlock()
+	if s, ok := babelSessions[key]; ok {
+		s.stdin.Close()
+		if s.cmd.Process != nil {
+			s.cmd.Process.Kill()
+		}
+		s.cmd.Wait()
+		delete(babelSessions, key)
+	}
+}
+
+// Send code to the session. endCmd is formatted with the marker and
+// must cause the interpreter to print it on a line of its own.
+func (self *babelSession) Run(key string, code string, endCmd string, timeout time.Duration) (string, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.count += 1
+	marker := fmt.Sprintf("%s_%d", self.marker, self.count)
+	if _, err := io.WriteString(self.stdin, code+"\n"+fmt.Sprintf(endCmd, marker)+"\n"); err != nil {
+		go closeBabelSession(key)
+		return "", err
+	}
+	type readRes struct {
+		out string
+		err error
+	}
+	done := make(chan readRes, 1)
+	go func() {
+		var sb strings.Builder
+		for {
+			line, err := self.out.ReadString('\n')
+			if strings.TrimRight(line, "\r\n") == marker {
+				done <- readRes{sb.String(), nil}
+				return
+			}
+			sb.WriteString(line)
+			if err != nil {
+				done <- readRes{sb.String(), err}
+				return
+			}
+		}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			go closeBabelSession(key)
+		}
+		return r.out, r.err
+	case <-time.After(timeout):
+		go closeBabelSession(key)
+		return "", fmt.Errorf("session %s timed out after %v", key, timeout)
+	}
+}
+
+// ---------------- Shell ----------------
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func ExecShell(b *common.BabelBlock) (string, error) {
+	shell := "sh"
+	if b.Lang == "bash" || b.Lang == "zsh" {
+		shell = b.Lang
+	}
+	var sb strings.Builder
+	for _, v := range b.Vars {
+		sb.WriteString(fmt.Sprintf("%s=%s\n", v.Name, shellQuote(babelVarString(v.Value, "\t"))))
+	}
+	sb.WriteString(b.Body)
+	if b.Session != "" {
+		key := shell + ":" + b.Session
+		s, err := getBabelSession(key, b.Dir, shell)
+		if err != nil {
+			return "", err
+		}
+		return s.Run(key, sb.String(), "echo %s", b.Timeout)
+	}
+	return babelRun(b, sb.String(), nil, shell)
+}
+
+// ---------------- Python ----------------
+
+const pythonValueWrapper = `
+def __orgs_fmt(v):
+    if v is None:
+        return ""
+    if isinstance(v, (list, tuple)):
+        rows = v if v and all(isinstance(r, (list, tuple)) for r in v) else [v]
+        return "\n".join("| " + " | ".join(str(c) for c in r) + " |" for r in rows)
+    return str(v)
+def __orgs_main():
+%s
+print(__orgs_fmt(__orgs_main()))
+`
+
+const pythonSessionDriver = `
+import sys, traceback
+__orgs_ns = {}
+while True:
+    lines = []
+    line = sys.stdin.readline()
+    while line and not line.startswith("__ORGS_END__ "):
+        lines.append(line)
+        line = sys.stdin.readline()
+    if not line:
+        break
+    try:
+        exec("".join(lines), __orgs_ns)
+    except Exception:
+        traceback.print_exc(file=sys.stdout)
+    print(line.split(" ", 1)[1].strip(), flush=True)
+`
+
+func pythonLiteral(v interface{}) string {
+	switch val := v.(type) {
+	case [][]string:
+		rows := []string{}
+		for _, r := range val {
+			cols := []string{}
+			for _, c := range r {
+				cols = append(cols, pythonLiteral(c))
+			}
+			rows = append(rows, "["+strings.Join(cols, ", ")+"]")
+		}
+		return "[" + strings.Join(rows, ", ") + "]"
+	case string:
+		if _, err := strconv.ParseFloat(val, 64); err == nil {
+			return val
+		}
+		return strconv.Quote(val)
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+func ExecPython(b *common.BabelBlock) (string, error) {
+	python := "python3"
+	if p, ok := b.Params[":python"]; ok && p != "" {
+		python = p
+	}
+	var sb strings.Builder
+	for _, v := range b.Vars {
+		sb.WriteString(fmt.Sprintf("%s = %s\n", v.Name, pythonLiteral(v.Value)))
+	}
+	if b.Results == "value" {
+		lines := strings.Split(strings.TrimRight(b.Body, "\n"), "\n")
+		for i, l := range lines {
+			lines[i] = "    " + l
+		}
+		lines = append(lines, "    pass")
+		sb.WriteString(fmt.Sprintf(pythonValueWrapper, strings.Join(lines, "\n")))
+	} else {
+		sb.WriteString(b.Body)
+	}
+	if b.Session != "" {
+		key := "python:" + b.Session
+		s, err := getBabelSession(key, b.Dir, python, "-u", "-c", pythonSessionDriver)
+		if err != nil {
+			return "", err
+		}
+		return s.Run(key, sb.String(), "__ORGS_END__ %s", b.Timeout)
+	}
+	return babelRun(b, sb.String(), nil, python, "-")
+}
+
+// ---------------- Go ----------------
+
+var goPackageRe = regexp.MustCompile(`(?m)^\s*package\s+\w+`)
+
+func goLiteral(v interface{}) string {
+	switch val := v.(type) {
+	case [][]string:
+		rows := []string{}
+		for _, r := range val {
+			cols := []string{}
+			for _, c := range r {
+				cols = append(cols, strconv.Quote(c))
+			}
+			rows = append(rows, "{"+strings.Join(cols, ", ")+"}")
+		}
+		return "[][]string{" + strings.Join(rows, ", ") + "}"
+	case string:
+		return strconv.Quote(val)
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+func ExecGo(b *common.BabelBlock) (string, error) {
+	var sb strings.Builder
+	if goPackageRe.MatchString(b.Body) {
+		sb.WriteString(b.Body)
+	} else {
+		imports := []string{}
+		for _, i := range strings.Fields(b.Params[":imports"]) {
+			imports = append(imports, strings.Trim(i, "\""))
+		}
+		if strings.Contains(b.Body, "fmt.") && !contains(imports, "fmt") {
+			imports = append(imports, "fmt")
+		}
+		sb.WriteString("package main\n\n")
+		for _, i := range imports {
+			sb.WriteString(fmt.Sprintf("import %s\n", strconv.Quote(i)))
+		}
+		sb.WriteString("\nfunc main() {\n")
+		sb.WriteString(b.Body)
+		sb.WriteString("}\n")
+	}
+	for _, v := range b.Vars {
+		sb.WriteString(fmt.Sprintf("\nvar %s = %s\n", v.Name, goLiteral(v.Value)))
+	}
+	tmp, err := os.MkdirTemp("", "orgs-babel-go")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "main.go")
+	if err := os.WriteFile(src, []byte(sb.String()), 0644); err != nil {
+		return "", err
+	}
+	return babelRun(b, "", nil, "go", "run", src)
+}
+
+// ---------------- SQLite ----------------
+
+func ExecSqlite(b *common.BabelBlock) (string, error) {
+	db, ok := b.Params[":db"]
+	if !ok || db == "" {
+		return "", fmt.Errorf("sqlite blocks require a :db header argument")
+	}
+	body := b.Body
+	for _, v := range b.Vars {
+		body = strings.ReplaceAll(body, "$"+v.Name, babelVarString(v.Value, ","))
+	}
+	args := []string{}
+	if h, ok := b.Params[":header"]; ok && h != "no" {
+		args = append(args, "-header")
+	}
+	if _, ok := b.Params[":csv"]; ok {
+		args = append(args, "-csv")
+	} else if _, ok := b.Params[":separator"]; ok {
+		args = append(args, "-separator", b.Params[":separator"])
+	}
+	args = append(args, db)
+	return babelRun(b, body, nil, "sqlite3", args...)
+}
+
+// ---------------- Generic interpreters ----------------
+
+var babelInterpreters = map[string][]string{
+	"js":   {"node", "-"},
+	"node": {"node", "-"},
+	"ruby": {"ruby", "-"},
+	"perl": {"perl", "-"},
+}
+
+func ExecInterpreter(b *common.BabelBlock) (string, error) {
+	cmd := babelInterpreters[b.Lang]
+	return babelRun(b, b.Body, babelVarEnv(b), cmd[0], cmd[1:]...)
+}
+
+func init() {
+	Conf().PlugManager.AddBabelMethod(ExecShell, "sh", "shell", "bash", "zsh")
+	Conf().PlugManager.AddBabelMethod(ExecPython, "python", "python3")
+	Conf().PlugManager.AddBabelMethod(ExecGo, "go")
+	Conf().PlugManager.AddBabelMethod(ExecSqlite, "sqlite", "sqlite3")
+	for lang := range babelInterpreters {
+		Conf().PlugManager.AddBabelMethod(ExecInterpreter, lang)
+	}
+}
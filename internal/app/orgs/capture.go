@@ -67,6 +67,7 @@ EDOC */
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -225,7 +226,7 @@ func GetTableRow(sec *org.Section, tname string) (*org.Pos, *org.Table) {
 	return nil, nil
 }
 
-func InsertEntryUsingTemplate(args *common.Capture, filename string, sec *org.Section, res *common.ResultMsg, tname string, findInsertPos FindInsertPosition) {
+func InsertEntryUsingTemplate(ctx context.Context, args *common.Capture, filename string, sec *org.Section, res *common.ResultMsg, tname string, findInsertPos FindInsertPosition) {
 	fmt.Printf("[InsertEntryUsingTemplate]: %s\n", filename)
 	if r, err := os.Open(filename); err == nil {
 		defer r.Close()
@@ -266,7 +267,7 @@ func InsertEntryUsingTemplate(args *common.Capture, filename string, sec *org.Se
 				}
 			}
 			fmt.Printf("Writing FILE: %v\n", filename)
-			os.WriteFile(filename, []byte(fileContent), 0644)
+			writeOrgFile(ctx, filename, []byte(fileContent), 0644)
 			res.Ok = true
 			res.Msg = "Capture successful"
 		}
@@ -277,7 +278,7 @@ func isEmpty(s string) bool {
 	return strings.TrimSpace(s) == ""
 }
 
-func InsertItemUsingTemplate(args *common.Capture, filename string, sec *org.Section, res *common.ResultMsg, tname string) {
+func InsertItemUsingTemplate(ctx context.Context, args *common.Capture, filename string, sec *org.Section, res *common.ResultMsg, tname string) {
 	fmt.Printf("  [InsertItemUsingTemplate]\n")
 	if r, err := os.Open(filename); err == nil {
 		defer r.Close()
@@ -386,40 +387,51 @@ func InsertItemUsingTemplate(args *common.Capture, filename string, sec *org.Sec
 				}
 			}
 			fmt.Printf("Writing FILE: %v\n", filename)
-			os.WriteFile(filename, []byte(fileContent), 0644)
+			writeOrgFile(ctx, filename, []byte(fileContent), 0644)
 			res.Ok = true
 			res.Msg = "Capture successful"
 		}
 	}
 }
 
-func Capture(db common.ODb, args *common.Capture, username string) (common.ResultMsg, error) {
+func Capture(ctx context.Context, db common.ODb, args *common.Capture, username string) (common.ResultMsg, error) {
 	var res common.ResultMsg = common.ResultMsg{}
 	temp := FindCaptureTemplate(args.Template, username)
 	res.Ok = false
 	res.Msg = "Capture: unknown failure, did not capture"
 	if temp != nil {
-		file, secs := db.GetFromTarget(&temp.CapTarget, true)
+		if fname := targetFilename(&temp.CapTarget); fname != "" && !CanWriteFile(username, fname) {
+			res.Msg = fmt.Sprintf("Capture: access denied [%s]", fname)
+			return res, nil
+		}
+		file, secs := targetForWrite(ctx, db, &temp.CapTarget, true)
 		if file == nil || secs == nil {
 			res.Msg = fmt.Sprintf("Capture: could not find target [%s]", temp.CapTarget.Type)
 			res.Ok = false
 			return res, nil
 		}
+		if !CanWriteFile(username, file.Filename) {
+			res.Msg = fmt.Sprintf("Capture: access denied [%s]", file.Filename)
+			return res, nil
+		}
 		tname := strings.ToLower(temp.Type)
 		if tname == "" || tname == "entry" {
-			InsertEntryUsingTemplate(args, file.Doc.Path, secs, &res, tname, EndRow)
+			InsertEntryUsingTemplate(ctx, args, file.Doc.Path, secs, &res, tname, EndRow)
 		} else if tname == "item" {
-			InsertItemUsingTemplate(args, file.Doc.Path, secs, &res, tname)
+			InsertItemUsingTemplate(ctx, args, file.Doc.Path, secs, &res, tname)
 		} else if tname == "checkitem" {
-			InsertItemUsingTemplate(args, file.Doc.Path, secs, &res, tname)
+			InsertItemUsingTemplate(ctx, args, file.Doc.Path, secs, &res, tname)
 		} else if tname == "table-line" {
-			InsertItemUsingTemplate(args, file.Doc.Path, secs, &res, tname)
+			InsertItemUsingTemplate(ctx, args, file.Doc.Path, secs, &res, tname)
 		} else if tname == "plain" {
-			InsertItemUsingTemplate(args, file.Doc.Path, secs, &res, tname)
+			InsertItemUsingTemplate(ctx, args, file.Doc.Path, secs, &res, tname)
 		} else {
 			fmt.Printf("Capture: invalid capture type [%s]\n", temp.Type)
 			res.Msg = fmt.Sprintf("Capture: invalid capture type  [%s]", temp.Type)
 		}
+		if res.Ok {
+			common.PublishEvent(common.Event{Type: common.EventCaptureAdded, Filename: file.Filename, Hash: secs.Hash, Name: temp.Name, Msg: args.NewNode.Headline})
+		}
 		return res, nil
 	} else {
 		fmt.Printf("Failed to find capture template [%s]\n", args.Template)
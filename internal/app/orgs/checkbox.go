@@ -0,0 +1,204 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Checkboxes
+
+  Plain list items can carry a checkbox:
+
+  #+BEGIN_SRC org
+  ** TODO Pack [1/3]
+     - [X] Tent
+     - [-] Kitchen [1/2]
+       - [X] Stove
+       - [ ] Pots
+     - [ ] Sleeping bag
+  #+END_SRC
+
+  Toggling an item with =/api/checkbox/toggle= keeps the rest of the
+  entry in sync the way org mode does:
+  - Toggling an item with checkboxes under it sets all of them to match.
+  - A parent item becomes =[X]= when all of its children are checked,
+    =[-]= when only some of them are and =[ ]= when none are.
+  - =[2/5]= and =[40%]= cookies on the parent items and on the heading are
+    refreshed (see Statistics Cookies).
+
+  In the =oc agenda= view press =x= to tick the items of the selected entry.
+EDOC */
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+func isChecked(status string) bool {
+	return status == "X" || status == "x"
+}
+
+// The text of a list item without any nested lists.
+func listItemText(itm org.ListItem) string {
+	for _, c := range itm.Children {
+		if p, ok := c.(org.Paragraph); ok {
+			txt := ""
+			for _, n := range p.Children {
+				txt += n.String()
+			}
+			return strings.TrimSpace(txt)
+		}
+	}
+	return ""
+}
+
+func collectCheckboxes(nodes []org.Node, depth int, items *[]common.CheckboxItem) {
+	for _, n := range nodes {
+		lst, ok := n.(org.List)
+		if !ok {
+			continue
+		}
+		for _, i := range lst.Items {
+			itm, ok := i.(org.ListItem)
+			if !ok {
+				continue
+			}
+			if itm.Status != "" {
+				*items = append(*items, common.CheckboxItem{
+					Row:    i.GetPos().Row,
+					Status: itm.Status,
+					Text:   listItemText(itm),
+					Depth:  depth,
+				})
+			}
+			collectCheckboxes(itm.Children, depth+1, items)
+		}
+	}
+}
+
+// All the checkbox items in the body of a heading, in file order.
+func ListCheckboxes(sec *org.Section) []common.CheckboxItem {
+	items := []common.CheckboxItem{}
+	if sec != nil && sec.Headline != nil {
+		collectCheckboxes(sec.Headline.Children, 0, &items)
+	}
+	return items
+}
+
+// Set every checkbox in the lists below an item.
+func setCheckboxes(nodes []org.Node, status string) {
+	for _, n := range nodes {
+		if lst, ok := n.(org.List); ok {
+			for j, i := range lst.Items {
+				if itm, ok := i.(org.ListItem); ok {
+					if itm.Status != "" {
+						itm.Status = status
+					}
+					setCheckboxes(itm.Children, status)
+					lst.Items[j] = itm
+				}
+			}
+		}
+	}
+}
+
+// The state a parent item should have from the children under it.
+// Returns "" if the item has no checkbox children.
+func childCheckboxState(nodes []org.Node) string {
+	done, total := countCheckboxes(nodes)
+	partial := false
+	for _, n := range nodes {
+		if lst, ok := n.(org.List); ok {
+			for _, i := range lst.Items {
+				if itm, ok := i.(org.ListItem); ok && itm.Status == "-" {
+					partial = true
+				}
+			}
+		}
+	}
+	switch {
+	case total == 0:
+		return ""
+	case done == total:
+		return "X"
+	case done > 0 || partial:
+		return "-"
+	}
+	return " "
+}
+
+// Refresh the statistics cookies in the first line of a list item.
+func updateItemCookies(itm *org.ListItem) {
+	done, total := countCheckboxes(itm.Children)
+	for _, c := range itm.Children {
+		p, ok := c.(org.Paragraph)
+		if !ok {
+			continue
+		}
+		for k, n := range p.Children {
+			if tok, ok := n.(org.StatisticToken); ok {
+				content := fmt.Sprintf("%d/%d", done, total)
+				if strings.HasSuffix(tok.Content, "%") {
+					content = fmt.Sprintf("%d%%", percentOf(done, total))
+				}
+				p.Children[k] = org.StatisticToken{Content: content}
+			}
+		}
+		return
+	}
+}
+
+// Toggle the checkbox on the item at row, fixing up its parents on the way
+// back out. Returns the new status and whether the item was found.
+func toggleCheckboxAt(nodes []org.Node, row int) (string, bool) {
+	for _, n := range nodes {
+		lst, ok := n.(org.List)
+		if !ok {
+			continue
+		}
+		for j, i := range lst.Items {
+			itm, ok := i.(org.ListItem)
+			if !ok || row < i.GetPos().Row || row > i.GetEnd().Row {
+				continue
+			}
+			var status string
+			var found bool
+			if row == i.GetPos().Row {
+				if itm.Status == "" {
+					return "", false
+				}
+				status = "X"
+				if isChecked(itm.Status) {
+					status = " "
+				}
+				itm.Status = status
+				setCheckboxes(itm.Children, status)
+				found = true
+			} else if status, found = toggleCheckboxAt(itm.Children, row); found && itm.Status != "" {
+				if st := childCheckboxState(itm.Children); st != "" {
+					itm.Status = st
+				}
+			}
+			if found {
+				updateItemCookies(&itm)
+				lst.Items[j] = itm
+				return status, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Toggle the checkbox on the given row of a heading. The cookies on the
+// heading are refreshed along with the items.
+func ToggleCheckbox(sec *org.Section, f *common.OrgFile, row int) (string, error) {
+	if sec == nil || sec.Headline == nil {
+		return "", fmt.Errorf("no heading to toggle a checkbox in")
+	}
+	status, found := toggleCheckboxAt(sec.Headline.Children, row)
+	if !found {
+		return "", fmt.Errorf("no checkbox on row %d", row)
+	}
+	updateHeadlineCookies(sec, f)
+	return status, nil
+}
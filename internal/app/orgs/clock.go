@@ -3,102 +3,531 @@ package orgs
 /* SDOC: Editing
 * Clocking
 
-  TODO: Fill in information on how to use the orgs clocking features
+  Clocking into a heading starts a timer. Clocking out writes a
+  =CLOCK:= entry into the logbook drawer of the heading (see clockIntoDrawer).
+
+  Every user has their own clock. When authentication is enabled clocking
+  in or out only touches the clock of the user that made the request, so
+  several people can track time on a shared server at once.
+
+  Clocking in with the interrupt option pauses the running clock rather
+  than just replacing it. The paused task is pushed onto an interrupt stack
+  and when you clock out of the interruption the previous task is clocked
+  back in again. Interruptions can be nested.
+
+  The most recently clocked tasks are kept in a short history so they can
+  be picked again quickly from =oc clockin -history=.
+
+** Dangling Clocks
+
+  A clock that has been running for longer than clockIdleThreshold is
+  treated as dangling, usually because it was forgotten or the server was
+  stopped while it was running. This is checked when the server starts and
+  by the autoclockout poller.
+
+  A dangling clock will not clock out on its own, as that would write a
+  huge =CLOCK:= entry. Instead it has to be resolved with =oc resolveclock=
+  or the =/clock/resolve= API using one of:
+  - keep: keep all of the time and leave the clock running.
+  - subtract: drop some idle minutes, or everything after a time, from the
+    clock and keep clocking from now.
+  - clockout: clock out at a given time.
+  - cancel: throw the clock away without logging any time.
+
+  The decision is noted in the logbook drawer of the heading.
 EDOC */
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/ihdavids/go-org/org"
 	"github.com/ihdavids/orgs/internal/common"
 )
 
+// How many recently clocked tasks to remember per user.
+const clockHistoryLength = 20
+
 type OrgsClock struct {
 	Time   *org.OrgDate
 	Target *common.Target
+	// Tasks paused by an interruption, the last entry is resumed first.
+	Stack []*common.Target `json:",omitempty"`
+	// Set once the clock has run past clockIdleThreshold, until it is resolved.
+	Dangling bool `json:",omitempty"`
+	// When the server last saw the clock running, a hint as to when it went idle.
+	LastSeen time.Time
+	// When a dangling clock was last kept, the threshold counts from here.
+	KeptAt time.Time
+	user   string
+	store  *ClockStore
+}
+
+func (self *OrgsClock) danglingMsg() common.ResultMsg {
+	return common.ResultMsg{Ok: false, Msg: fmt.Sprintf("Clock has been running since %s, resolve it first", self.Time.Start.Format("2006-01-02 15:04"))}
+}
+
+// Hold the store lock while a clock is read or changed. Every exported
+// method takes it, the unexported ones expect it to be held.
+func (self *OrgsClock) lock() func() {
+	if self.store == nil {
+		return func() {}
+	}
+	self.store.lock.Lock()
+	return self.store.lock.Unlock
+}
+
+func (self *OrgsClock) ClockIn(ctx context.Context, tgt *common.Target) (common.ResultMsg, error) {
+	defer self.lock()()
+	if self.Dangling {
+		return self.danglingMsg(), nil
+	}
+	self.stop(ctx)
+	return self.start(tgt)
 }
 
-func (self *OrgsClock) ClockIn(tgt *common.Target) (common.ResultMsg, error) {
-	self.ClockOut()
+// Clock into an interruption, the running task is resumed when
+// the interruption is clocked out.
+func (self *OrgsClock) Interrupt(ctx context.Context, tgt *common.Target) (common.ResultMsg, error) {
+	defer self.lock()()
+	if self.Dangling {
+		return self.danglingMsg(), nil
+	}
+	var paused *common.Target
+	if self.active() {
+		paused = self.Target
+		self.stop(ctx)
+	}
+	res, err := self.start(tgt)
+	if err != nil || !res.Ok {
+		if paused != nil {
+			self.start(paused)
+		}
+		return res, err
+	}
+	if paused != nil {
+		self.Stack = append(self.Stack, paused)
+		self.WriteOutClock()
+		res.Msg = "Clock is now active, previous task paused"
+	}
+	return res, nil
+}
+
+func (self *OrgsClock) start(tgt *common.Target) (common.ResultMsg, error) {
 	if err := GetDb().ConvertTargetToOlp(tgt); err != nil {
 		return common.ResultMsg{Ok: false, Msg: fmt.Sprintf("Could not clock in, could not convert target: %s", err.Error())}, err
 	}
 	self.Time = org.NewOrgDateNow()
 	self.Time.HaveTime = true
 	self.Target = tgt
+	self.Dangling = false
+	self.LastSeen = self.Time.Start
+	self.KeptAt = time.Time{}
+	if self.store != nil {
+		self.store.remember(self.user, tgt, self.Time.Start)
+	}
 	self.WriteOutClock()
+	common.PublishEvent(common.Event{Type: common.EventClockIn, Filename: targetFilename(tgt), Target: tgt})
 	return common.ResultMsg{Ok: true, Msg: "Clock is now active"}, nil
 }
 
-func (self *OrgsClock) IsClockActive() bool {
+func (self *OrgsClock) active() bool {
 	return self.Target != nil
 }
 
+func (self *OrgsClock) IsClockActive() bool {
+	defer self.lock()()
+	return self.active()
+}
+
+// A copy of the clock that can be read without holding the lock.
+func (self *OrgsClock) State() OrgsClock {
+	defer self.lock()()
+	st := *self
+	if self.Time != nil {
+		t := *self.Time
+		st.Time = &t
+	}
+	if self.Target != nil {
+		t := *self.Target
+		st.Target = &t
+	}
+	st.Stack = nil
+	for _, t := range self.Stack {
+		c := *t
+		st.Stack = append(st.Stack, &c)
+	}
+	st.store = nil
+	return st
+}
+
 func (self *OrgsClock) GetTarget() *common.Target {
-	return self.Target
+	return self.State().Target
 }
 
 func (self *OrgsClock) GetTime() *org.OrgDate {
-	return self.Time
+	return self.State().Time
 }
 
-func (self *OrgsClock) ClockOut() (common.ResultMsg, error) {
-	if self.IsClockActive() {
-		self.Time.End = time.Now()
-		clk := &org.OrgDateClock{OrgDate: *self.Time}
-		clk.RecalcDuration()
-		clock := org.Clock{Date: clk}
-		// TODO: Update target with the clock
-		if ofile, secs := GetDb().GetFromTarget(self.Target, false); secs != nil {
+// Clock out of the running task, resuming the task it interrupted if there is one.
+func (self *OrgsClock) ClockOut(ctx context.Context) (common.ResultMsg, error) {
+	defer self.lock()()
+	return self.clockOut(ctx)
+}
+
+func (self *OrgsClock) clockOut(ctx context.Context) (common.ResultMsg, error) {
+	if self.Dangling {
+		return self.danglingMsg(), nil
+	}
+	if !self.stop(ctx) {
+		return common.ResultMsg{Ok: false, Msg: "Clock was not active"}, nil
+	}
+	return self.resume("Clocked out okay")
+}
+
+// Clock back into the last task that was interrupted, if there is one.
+func (self *OrgsClock) resume(msg string) (common.ResultMsg, error) {
+	for len(self.Stack) > 0 {
+		prev := self.Stack[len(self.Stack)-1]
+		self.Stack = self.Stack[:len(self.Stack)-1]
+		// A paused task may have been deleted or refiled since.
+		if res, err := self.start(prev); err == nil && res.Ok {
+			return common.ResultMsg{Ok: true, Msg: msg + ", resumed " + prev.Id}, nil
+		}
+	}
+	self.WriteOutClock()
+	return common.ResultMsg{Ok: true, Msg: msg}, nil
+}
+
+// Clock out and forget any interrupted tasks.
+func (self *OrgsClock) ClockOutAll(ctx context.Context) (common.ResultMsg, error) {
+	defer self.lock()()
+	return self.clockOutAll(ctx)
+}
+
+func (self *OrgsClock) clockOutAll(ctx context.Context) (common.ResultMsg, error) {
+	self.Stack = nil
+	return self.clockOut(ctx)
+}
+
+// Write the running clock into the logbook. Returns false if nothing was running.
+func (self *OrgsClock) stop(ctx context.Context) bool {
+	return self.stopAt(ctx, time.Now(), true, "")
+}
+
+// Stop the clock at the given time. If record is false no CLOCK entry is written.
+// A note, if given, is added to the logbook of the heading.
+func (self *OrgsClock) stopAt(ctx context.Context, end time.Time, record bool, note string) bool {
+	if !self.active() {
+		return false
+	}
+	self.Time.End = end
+	clk := &org.OrgDateClock{OrgDate: *self.Time}
+	clk.RecalcDuration()
+	clock := org.Clock{Date: clk}
+	if ofile, secs := GetDb().GetFromTarget(self.Target, false); secs != nil {
+		if record {
 			drawer := secs.Headline.FindDrawer(Conf().ClockIntoDrawer)
 			if drawer != nil {
 				drawer.Append(secs.Headline, clock)
-				/*
-					fmt.Printf("HAVE DRAWER\n")
-					for _, c := range drawer.Children {
-						fmt.Printf("[%s] %s ENTRY: %s\n", c.GetTypeName(), Conf().ClockIntoDrawer, c.String())
-					}
-				*/
 			} else {
 				drawer := &org.Drawer{Name: Conf().ClockIntoDrawer, Children: []org.Node{clock}}
 				secs.Headline.AddDrawer(drawer)
 			}
-			WriteOutOrgFile(ofile)
 		}
-		// Clean out the clock
-		self.Time = nil
-		self.Target = nil
+		if note != "" {
+			AddLogNote(secs.Headline, note)
+		}
+		WriteOutOrgFile(ctx, ofile, secs.Hash)
+	}
+	if self.store != nil && record {
+		self.store.finished(self.user, self.Target, float64(clk.DurationMins))
+	}
+	common.PublishEvent(common.Event{Type: common.EventClockOut, Filename: targetFilename(self.Target), Target: self.Target})
+	// Clean out the clock
+	self.Time = nil
+	self.Target = nil
+	self.Dangling = false
+	self.WriteOutClock()
+	return true
+}
+
+// Has the clock run past clockIdleThreshold. Zero turns the check off.
+func (self *OrgsClock) overThreshold(now time.Time) bool {
+	if !self.active() || Conf().ClockIdleThreshold <= 0 {
+		return false
+	}
+	from := self.Time.Start
+	if self.KeptAt.After(from) {
+		from = self.KeptAt
+	}
+	return now.Sub(from) > time.Duration(Conf().ClockIdleThreshold)*time.Minute
+}
+
+// Resolve a running clock the way org-resolve-clocks does.
+func (self *OrgsClock) Resolve(ctx context.Context, req *common.ClockResolveRequest, now time.Time) (common.ResultMsg, error) {
+	defer self.lock()()
+	if !self.active() {
+		return common.ResultMsg{Ok: false, Msg: "Clock was not active"}, nil
+	}
+	start := self.Time.Start
+	started := start.Format(logTimestampLayout)
+	// Where the idle time began, from either the idle minutes or a time.
+	var end time.Time
+	if req.At != "" {
+		t, _, err := ParseQueryDate(req.At, now)
+		if err != nil {
+			return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+		}
+		end = t
+	} else if req.IdleMins > 0 {
+		end = now.Add(-time.Duration(req.IdleMins) * time.Minute)
+	}
+	needEnd := func() (common.ResultMsg, error) {
+		if end.IsZero() {
+			err := fmt.Errorf("%s needs an idle time or a time to clock out at", req.Action)
+			return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+		}
+		if !end.After(start) || end.After(now) {
+			err := fmt.Errorf("%s must be between %s and now", end.Format("2006-01-02 15:04"), start.Format("2006-01-02 15:04"))
+			return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+		}
+		return common.ResultMsg{Ok: true}, nil
+	}
+	stamp := now.Format(logTimestampLayout)
+	switch req.Action {
+	case "keep":
+		self.Dangling = false
+		self.KeptAt = now
+		self.LastSeen = now
+		if ofile, secs := GetDb().GetFromTarget(self.Target, false); secs != nil {
+			AddLogNote(secs.Headline, fmt.Sprintf("- Kept clock started %s running %s", started, stamp))
+			WriteOutOrgFile(ctx, ofile, secs.Hash)
+		}
 		self.WriteOutClock()
-		return common.ResultMsg{Ok: true, Msg: "Clocked out okay"}, nil
+		return common.ResultMsg{Ok: true, Msg: "Clock kept"}, nil
+	case "subtract":
+		if res, err := needEnd(); err != nil {
+			return res, err
+		}
+		tgt := self.Target
+		idle := int(now.Sub(end).Minutes())
+		self.stopAt(ctx, end, true, fmt.Sprintf("- Subtracted %d idle minutes from clock started %s %s", idle, started, stamp))
+		return self.start(tgt)
+	case "clockout":
+		if res, err := needEnd(); err != nil {
+			return res, err
+		}
+		self.stopAt(ctx, end, true, fmt.Sprintf("- Clocked out at %s from clock started %s %s", end.Format(logTimestampLayout), started, stamp))
+		return self.resume("Clocked out okay")
+	case "cancel":
+		self.stopAt(ctx, now, false, fmt.Sprintf("- Cancelled clock started %s %s", started, stamp))
+		return self.resume("Clock cancelled")
 	}
-	return common.ResultMsg{Ok: false, Msg: "Clock was not active"}, nil
+	err := fmt.Errorf("unknown clock resolution %q, expected keep, subtract, clockout or cancel", req.Action)
+	return common.ResultMsg{Ok: false, Msg: err.Error()}, err
 }
 
 func GetClockPath() string {
 	return path.Join(Conf().PlugManager.HomeDir, "clock_data.json")
 }
 
+// The caller holds the store lock.
 func (self *OrgsClock) WriteOutClock() {
+	if self.store != nil {
+		self.store.writeOutClocks()
+	}
+}
+
+// ClockStore holds the clock for every user along with
+// the tasks they have clocked recently.
+type ClockStore struct {
+	Clocks  map[string]*OrgsClock
+	History map[string][]common.ClockHistoryEntry
+	mu      sync.Mutex // guards the maps
+	lock    sync.Mutex // guards the state of every clock, taken before mu
+}
+
+// The clock for a user, the empty user is used when authentication is off.
+func (self *ClockStore) User(username string) *OrgsClock {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	clk, ok := self.Clocks[username]
+	if !ok {
+		clk = &OrgsClock{}
+		self.Clocks[username] = clk
+	}
+	clk.user = username
+	clk.store = self
+	return clk
+}
+
+func (self *ClockStore) GetHistory(username string) []common.ClockHistoryEntry {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return append([]common.ClockHistoryEntry{}, self.History[username]...)
+}
+
+// Move a task to the front of the users history.
+func (self *ClockStore) remember(username string, tgt *common.Target, start time.Time) {
+	entry := common.ClockHistoryEntry{Target: *tgt, Filename: tgt.Filename, LastClocked: start}
+	if _, sec := GetDb().GetFromTarget(tgt, false); sec != nil {
+		entry.Headline = common.GetSectionTitle(sec)
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	hist := []common.ClockHistoryEntry{entry}
+	for _, h := range self.History[username] {
+		if h.Target.Filename == tgt.Filename && h.Target.Id == tgt.Id {
+			continue
+		}
+		hist = append(hist, h)
+	}
+	if len(hist) > clockHistoryLength {
+		hist = hist[:clockHistoryLength]
+	}
+	self.History[username] = hist
+}
+
+func (self *ClockStore) finished(username string, tgt *common.Target, mins float64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for i, h := range self.History[username] {
+		if h.Target.Filename == tgt.Filename && h.Target.Id == tgt.Id {
+			self.History[username][i].Mins = mins
+			return
+		}
+	}
+}
+
+// True if anyone has a running clock.
+func (self *ClockStore) IsClockActive() bool {
+	clocks := self.all()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, clk := range clocks {
+		if clk.active() {
+			return true
+		}
+	}
+	return false
+}
+
+// Clock everyone out, used by the autoclockout plugin.
+// Dangling clocks are left for their owner to resolve.
+func (self *ClockStore) ClockOut() (common.ResultMsg, error) {
+	res := common.ResultMsg{Ok: false, Msg: "Clock was not active"}
+	clocks := self.all()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, clk := range clocks {
+		if clk.active() && !clk.Dangling {
+			// Not done for anyone's request, so not journaled.
+			clk.clockOutAll(context.Background())
+			res = common.ResultMsg{Ok: true, Msg: "Clocked out okay"}
+		}
+	}
+	return res, nil
+}
+
+// Look for clocks that have run past clockIdleThreshold and mark them
+// as dangling. Returns how many clocks are dangling.
+func (self *ClockStore) CheckIdle(now time.Time) int {
+	count := 0
+	changed := false
+	clocks := self.all()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, clk := range clocks {
+		if !clk.active() {
+			continue
+		}
+		if !clk.Dangling && clk.overThreshold(now) {
+			clk.Dangling = true
+			changed = true
+			log.Printf("clock for user [%s] on %s has been running since %s, it needs to be resolved\n", clk.user, clk.Target.Id, clk.Time.Start.Format("2006-01-02 15:04"))
+		}
+		if clk.Dangling {
+			count++
+		} else {
+			clk.LastSeen = now
+			changed = true
+		}
+	}
+	if changed {
+		self.writeOutClocks()
+	}
+	return count
+}
+
+func (self *ClockStore) all() []*OrgsClock {
+	self.mu.Lock()
+	names := []string{}
+	for name := range self.Clocks {
+		names = append(names, name)
+	}
+	self.mu.Unlock()
+	clocks := []*OrgsClock{}
+	for _, name := range names {
+		clocks = append(clocks, self.User(name))
+	}
+	return clocks
+}
+
+func (self *ClockStore) WriteOutClocks() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.writeOutClocks()
+}
+
+// The caller holds lock, so no clock changes while it is marshalled.
+func (self *ClockStore) writeOutClocks() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	file, _ := json.MarshalIndent(self, "", " ")
 	_ = os.WriteFile(GetClockPath(), file, 0644)
 }
 
-func (self *OrgsClock) ReadInClock() {
-	if data, err := os.ReadFile(GetClockPath()); err == nil {
-		json.Unmarshal(data, self)
+func (self *ClockStore) ReadInClocks() {
+	data, err := os.ReadFile(GetClockPath())
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, self)
+	if self.Clocks == nil {
+		self.Clocks = map[string]*OrgsClock{}
+	}
+	if self.History == nil {
+		self.History = map[string][]common.ClockHistoryEntry{}
+	}
+	// Older servers kept a single clock shared by everyone.
+	var single OrgsClock
+	if json.Unmarshal(data, &single) == nil && single.Target != nil {
+		if _, ok := self.Clocks[""]; !ok {
+			self.Clocks[""] = &single
+		}
 	}
 }
 
-var orgClock *OrgsClock = nil
+var orgClocks *ClockStore = nil
+var orgClocksOnce sync.Once
 
+func Clocks() *ClockStore {
+	orgClocksOnce.Do(func() {
+		orgClocks = &ClockStore{Clocks: map[string]*OrgsClock{}, History: map[string][]common.ClockHistoryEntry{}}
+		orgClocks.ReadInClocks()
+		orgClocks.CheckIdle(time.Now())
+	})
+	return orgClocks
+}
+
+// The clock used when there is no user, see Clocks().User for a users clock.
 func Clock() *OrgsClock {
-	if orgClock == nil {
-		orgClock = &OrgsClock{}
-		orgClock.ReadInClock()
-	}
-	return orgClock
+	return Clocks().User("")
 }
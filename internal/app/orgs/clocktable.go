@@ -2,12 +2,51 @@ package orgs
 
 /* SDOC: Editing
 * Clock Table
-  
-  TODO: Fill in information on how to use the orgs clocktable output
+
+  A clock table is a dynamic block that sums up the time clocked under headings:
+
+  #+BEGIN_SRC org
+  #+BEGIN: clocktable :scope file :maxlevel 2 :block thisweek
+  #+END:
+  #+END_SRC
+
+  Running the block replaces its contents with a table of the local and total
+  time clocked against each heading. These parameters are understood:
+
+  | Parameter     | Description                                                             |
+  |---------------+-------------------------------------------------------------------------|
+  | =:scope=      | =subtree= (default), =file=, =agenda= for every file orgs knows about, |
+  |               | or a list of files like =("a.org" "b.org")=                             |
+  | =:maxlevel=   | Deepest heading level to list                                           |
+  | =:skip0=      | Leave out headings without clocked time                                 |
+  | =:fileskip0=  | Leave out files without clocked time                                    |
+  | =:match=      | Only count headings matching a tags/property match (see Match Expressions) |
+  | =:block=      | Only count time in this block of time (see below)                       |
+  | =:tstart=     | Only count time after this date, like ="<-1w>"= or ="<2024-01-01>"=    |
+  | =:tend=       | Only count time before this date                                        |
+  | =:step=       | =day=, =week= or =month=, produce one table for each step of the range  |
+  | =:stepskip0=  | Leave out steps without clocked time                                    |
+  | =:properties= | Property columns to add, like =("Effort" "CLIENT")=                    |
+  | =:formula=    | =%= adds a column with the percentage of the total time                 |
+
+  Blocks can be:
+  - =2007-12-31=, =2007-12=, =2007-W50=, =2007-Q2= or =2007=
+  - =today=, =yesterday= or =today-N=
+  - =thisweek=, =lastweek= or =thisweek-N= (weeks start on Monday)
+  - =thismonth=, =lastmonth= or =thismonth-N=
+  - =thisyear=, =lastyear= or =thisyear-N=
+  - =untilnow= for all clocked time
+
+  Clocks that run across the edge of the range only count the part inside it.
+  The table is rendered by the =defaultclocktable.tpl= template. Files the
+  user updating the table cannot read are left out of =agenda= and refused
+  in a list of files.
 EDOC */
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -17,103 +56,196 @@ import (
 )
 
 type ClockTableState struct {
-	Scope     string
-	Durations map[*org.Section]*ClockTableDuration
-	MaxLevel  int
-	SkipEmpty bool
-	Match     *MatchExpr
-	Block     *BlockTest
+	Scope      string
+	Files      []string
+	Durations  map[*org.Section]*ClockTableDuration
+	MaxLevel   int
+	SkipEmpty  bool
+	FileSkip0  bool
+	StepSkip0  bool
+	Match      *MatchExpr
+	Block      *BlockTest
+	Step       string
+	Properties []string
+	Percent    bool
+	// The user the table is generated for, only their files are included.
+	Username string
 }
 
-/*
-‘2007-12-31’	New year eve 2007
-‘2007-12’	December 2007
-‘2007-W50’	ISO-week 50 in 2007
-‘2007-Q2’	2nd quarter in 2007
-‘2007’	the year 2007
-‘today’, ‘yesterday’, ‘today-N’	a relative day
-‘thisweek’, ‘lastweek’, ‘thisweek-N’	a relative week
-‘thismonth’, ‘lastmonth’, ‘thismonth-N’	a relative month
-‘thisyear’, ‘lastyear’, ‘thisyear-N’	a relative year
-‘untilnow’77	all clocked time ever
-*/
+// A range of time to count clocks in, Date.End is inclusive.
 type BlockTest struct {
 	Date *org.OrgDate
 }
 
+// A block covering [start, end)
+func NewBlockTest(start, end time.Time) *BlockTest {
+	return &BlockTest{Date: &org.OrgDate{Start: start, End: end.Add(-time.Second), HaveTime: true}}
+}
+
+var blockRelRe = regexp.MustCompile(`^(today|thisweek|thismonth|thisyear)-([0-9]+)$`)
+var blockWeekRe = regexp.MustCompile(`^([0-9]{4})-W([0-9]{1,2})$`)
+var blockQuarterRe = regexp.MustCompile(`^([0-9]{4})-Q([1-4])$`)
+
 func ParseBlock(blk string) *BlockTest {
-	if blk == "today" {
-		b := &BlockTest{}
-		b.Date = &org.OrgDate{Start: time.Now(), End: time.Now(), HaveTime: true}
-		t := b.Date.Start
-		b.Date.Start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-		t = b.Date.End
-		b.Date.End = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return b
-	} else if blk == "yesterday" {
-		b := &BlockTest{}
-		b.Date = &org.OrgDate{Start: time.Now().AddDate(0, 0, -1), End: time.Now().AddDate(0, 0, -1), HaveTime: true}
-		t := b.Date.Start
-		b.Date.Start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-		t = b.Date.End
-		b.Date.End = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return b
-	} else if blk == "thisweek" {
-		b := &BlockTest{}
-		now := time.Now()
-		w := now.Weekday()
-		fmt.Printf("Weekday: %d\n", int(w))
-		b.Date = &org.OrgDate{Start: time.Now().AddDate(0, 0, -int(w)), End: time.Now(), HaveTime: true}
-		t := b.Date.Start
-		b.Date.Start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-		t = b.Date.End
-		b.Date.End = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return b
-	} else if blk == "lastweek" {
-		b := &BlockTest{}
-		now := time.Now()
-		w := now.Weekday()
-		b.Date = &org.OrgDate{Start: time.Now().AddDate(0, 0, -7+-int(w)), End: time.Now().AddDate(0, 0, -int(w)), HaveTime: true}
-		t := b.Date.Start
-		b.Date.Start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-		t = b.Date.End
-		b.Date.End = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return b
-	} else if blk == "thismonth" {
-		b := &BlockTest{}
-		b.Date = &org.OrgDate{Start: time.Now(), End: time.Now(), HaveTime: true}
-		t := b.Date.Start
-		b.Date.Start = time.Date(t.Year(), t.Month(), 0, 0, 0, 0, 0, t.Location())
-		t = b.Date.Start
-		b.Date.Start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-		t = b.Date.End
-		b.Date.End = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return b
-	} else if blk == "lastmonth" {
-		b := &BlockTest{}
-		b.Date = &org.OrgDate{Start: time.Now(), End: time.Now(), HaveTime: true}
-		t := b.Date.Start
-		month := t.Month()
-		year := t.Year()
-
-		if month > 1 {
-			month = month - 1
-		} else {
-			month = 12
-			year = year - 1
+	now := time.Now()
+	blk = strings.ToLower(strings.TrimSpace(blk))
+	switch blk {
+	case "today":
+		return NewBlockTest(GetBeginOfDay(now), GetEndOfDay(now))
+	case "yesterday":
+		return NewBlockTest(GetBeginOfDay(now.AddDate(0, 0, -1)), GetBeginOfDay(now))
+	case "untilnow":
+		return NewBlockTest(time.Time{}, now)
+	case "lastweek":
+		blk = "thisweek-1"
+	case "lastmonth":
+		blk = "thismonth-1"
+	case "lastyear":
+		blk = "thisyear-1"
+	case "thisweek", "thismonth", "thisyear":
+		blk = blk + "-0"
+	}
+	if m := blockRelRe.FindStringSubmatch(blk); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		switch m[1] {
+		case "today":
+			start := GetBeginOfDay(now.AddDate(0, 0, -n))
+			return NewBlockTest(start, start.AddDate(0, 0, 1))
+		case "thisweek":
+			start := startOfWeek(now).AddDate(0, 0, -7*n)
+			return NewBlockTest(start, start.AddDate(0, 0, 7))
+		case "thismonth":
+			start := startOfMonth(now).AddDate(0, -n, 0)
+			return NewBlockTest(start, start.AddDate(0, 1, 0))
+		case "thisyear":
+			start := startOfYear(now).AddDate(-n, 0, 0)
+			return NewBlockTest(start, start.AddDate(1, 0, 0))
 		}
-		b.Date.Start = time.Date(year, month, 0, 0, 0, 0, 0, t.Location())
-		b.Date.End = b.Date.Start.AddDate(0, 1, 0)
-		t = b.Date.End
-		b.Date.End = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location())
-		return b
+	}
+	if m := blockWeekRe.FindStringSubmatch(blk); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		week, _ := strconv.Atoi(m[2])
+		// ISO week 1 is the week with January 4th in it.
+		start := startOfWeek(time.Date(year, 1, 4, 0, 0, 0, 0, now.Location())).AddDate(0, 0, 7*(week-1))
+		return NewBlockTest(start, start.AddDate(0, 0, 7))
+	}
+	if m := blockQuarterRe.FindStringSubmatch(blk); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		q, _ := strconv.Atoi(m[2])
+		start := time.Date(year, time.Month(3*(q-1)+1), 1, 0, 0, 0, 0, now.Location())
+		return NewBlockTest(start, start.AddDate(0, 3, 0))
+	}
+	if t, err := time.ParseInLocation("2006-01-02", blk, now.Location()); err == nil {
+		return NewBlockTest(t, t.AddDate(0, 0, 1))
+	}
+	if t, err := time.ParseInLocation("2006-01", blk, now.Location()); err == nil {
+		return NewBlockTest(t, t.AddDate(0, 1, 0))
+	}
+	if t, err := time.ParseInLocation("2006", blk, now.Location()); err == nil {
+		return NewBlockTest(t, t.AddDate(1, 0, 0))
 	}
 	return nil
 }
 
+// Minutes of a clock that fall inside the block.
+func (self *BlockTest) Overlap(clk *org.OrgDateClock) float64 {
+	if clk.End.IsZero() {
+		return 0
+	}
+	start, end := common.ClipClock(clk, self.Date.Start, self.Date.End.Add(time.Second))
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start).Minutes()
+}
+
+// Parse a :tstart or :tend value like "<-1w>", "<today>" or "<2024-01-01 Mon>"
+func parseClockTime(str string) (time.Time, error) {
+	str = strings.Trim(strings.TrimSpace(str), "\"")
+	inner := strings.TrimSpace(strings.Trim(str, "<>[]"))
+	if t, _, err := ParseQueryDate(inner, time.Now()); err == nil {
+		return t, nil
+	}
+	if date, _, _ := org.ParseTimestamp(str); date != nil {
+		return date.Start, nil
+	}
+	return time.Time{}, fmt.Errorf("clocktable - could not parse time %s", str)
+}
+
+// Split a list parameter like ("a.org" "b.org")
+func parseParamList(str string) []string {
+	str = strings.TrimSpace(str)
+	str = strings.TrimSuffix(strings.TrimPrefix(str, "("), ")")
+	var res []string
+	for _, v := range splitParams(str) {
+		if v = strings.Trim(v, "\""); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// Split on spaces, keeping quoted strings and parenthesised lists together.
+func splitParams(str string) []string {
+	var res []string
+	var cur strings.Builder
+	depth := 0
+	quoted := false
+	for _, c := range str {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+		case (c == ' ' || c == '\t') && !quoted && depth == 0:
+			if cur.Len() > 0 {
+				res = append(res, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteRune(c)
+	}
+	if cur.Len() > 0 {
+		res = append(res, cur.String())
+	}
+	return res
+}
+
+// The block parameters as :name value pairs. Values keep their lists
+// together, quotes around plain values are removed.
+func ClockTableParams(blk *org.Block) map[string]string {
+	params := map[string]string{}
+	toks := splitParams(strings.Join(blk.Parameters, " "))
+	for i := 0; i < len(toks); i++ {
+		key := toks[i]
+		if !strings.HasPrefix(key, ":") {
+			continue
+		}
+		val := ""
+		if i+1 < len(toks) && !strings.HasPrefix(toks[i+1], ":") {
+			i++
+			val = toks[i]
+			if !strings.HasPrefix(val, "(") {
+				val = strings.Trim(val, "\"")
+			}
+		}
+		params[key] = val
+	}
+	return params
+}
+
 func (s *ClockTableState) Validate() error {
-	if s.Scope != "subtree" && s.Scope != "file" {
-		return fmt.Errorf("clocktable - Only subtree or file mode is supported at this time!")
+	if s.Scope != "subtree" && s.Scope != "file" && s.Scope != "agenda" && s.Files == nil {
+		return fmt.Errorf("clocktable - unknown scope %s, use subtree, file, agenda or a list of files", s.Scope)
+	}
+	if s.Step != "" && s.Step != "day" && s.Step != "week" && s.Step != "month" {
+		return fmt.Errorf("clocktable - unknown step %s, use day, week or month", s.Step)
+	}
+	if s.Step != "" && (s.Block == nil || s.Block.Date.Start.IsZero()) {
+		return fmt.Errorf("clocktable - :step needs a :block or :tstart to step through")
 	}
 	return nil
 }
@@ -125,6 +257,9 @@ func (s *ClockTableState) ParseParams(params map[string]string) error {
 	if s.Scope, ok = params[":scope"]; !ok {
 		s.Scope = "subtree"
 	}
+	if strings.HasPrefix(s.Scope, "(") {
+		s.Files = parseParamList(s.Scope)
+	}
 	if temp, ok = params[":maxlevel"]; ok {
 		if s.MaxLevel, err = strconv.Atoi(temp); err != nil {
 			s.MaxLevel = 0
@@ -136,29 +271,62 @@ func (s *ClockTableState) ParseParams(params map[string]string) error {
 	if temp, ok = params[":skip0"]; ok {
 		s.SkipEmpty = temp == "t" || temp == ""
 	}
+	if temp, ok = params[":fileskip0"]; ok {
+		s.FileSkip0 = temp == "t" || temp == ""
+	}
+	if temp, ok = params[":stepskip0"]; ok {
+		s.StepSkip0 = temp == "t" || temp == ""
+	}
 	if temp, ok = params[":match"]; ok {
-		s.Match = NewMatchExpr(temp)
+		if s.Match, err = ParseMatchExpr(temp); err != nil {
+			return err
+		}
 	}
 	if temp, ok = params[":block"]; ok {
-		s.Block = ParseBlock(temp)
+		if s.Block = ParseBlock(temp); s.Block == nil {
+			return fmt.Errorf("clocktable - unknown block %s", temp)
+		}
+	}
+	tstart, hasStart := params[":tstart"]
+	tend, hasEnd := params[":tend"]
+	if hasStart || hasEnd {
+		start, end := time.Time{}, time.Now()
+		if hasStart {
+			if start, err = parseClockTime(tstart); err != nil {
+				return err
+			}
+		}
+		if hasEnd {
+			if end, err = parseClockTime(tend); err != nil {
+				return err
+			}
+		}
+		s.Block = NewBlockTest(start, end)
+	}
+	if temp, ok = params[":step"]; ok {
+		s.Step = temp
+	}
+	if temp, ok = params[":properties"]; ok {
+		s.Properties = parseParamList(temp)
+	}
+	if temp, ok = params[":formula"]; ok {
+		s.Percent = temp == "%"
 	}
 	return nil
 }
 
 func (s *ClockTableState) CalcClockDuration(sec *org.Section) *common.OrgDuration {
 	local := &common.OrgDuration{Mins: 0}
-	drawer := sec.Headline.FindDrawer(Conf().ClockIntoDrawer)
-	if drawer != nil && drawer.Children != nil {
-		for _, c := range drawer.Children {
-			if c.GetType() == org.ClockNode {
-				clk := c.(org.Clock)
-				if s.Block == nil || s.Block.Date.DateTimeInRange(clk.Date.Start) || s.Block.Date.DateTimeInRange(clk.Date.End) {
-					// TODO: Add validation tests in computing this value
-					dur := common.NewDuration(float64(clk.Date.DurationMins))
-					local = local.Add(&dur)
-				}
-			}
+	if sec.Headline == nil {
+		return local
+	}
+	for _, clk := range common.HeadlineClocks(sec.Headline, Conf().ClockIntoDrawer) {
+		mins := float64(clk.Date.DurationMins)
+		if s.Block != nil {
+			mins = s.Block.Overlap(clk.Date)
 		}
+		dur := common.NewDuration(mins)
+		local = local.Add(&dur)
 	}
 	return local
 }
@@ -183,19 +351,24 @@ func (s *ClockTableState) GenerateForSubtree(ofile *common.OrgFile, sec *org.Sec
 
 	if s.Match == nil || s.Match.EvalSection(ofile, sec) {
 		s.Durations[sec] = ct
-		if s.Match != nil {
-			s.Match.Reset()
-		}
 		return ct.Total
 	}
 	return &common.OrgDuration{}
 }
 
+func headingLevel(sec *org.Section) int {
+	if sec.Headline == nil {
+		return 0
+	}
+	return sec.Headline.Lvl
+}
+
 func (s *ClockTableState) flattenData(ofile *common.OrgFile, data *[]map[string]interface{}, sec *org.Section, blvl int) {
 	if s.MaxLevel <= 0 || sec.Headline.Lvl <= s.MaxLevel {
 		if sd, ok := s.Durations[sec]; ok {
 			d := map[string]interface{}{}
 			d["total"] = sd.Total.ToString()
+			d["mins"] = sd.Total.Mins
 			if sec.Headline.Lvl == s.MaxLevel {
 				d["local"] = sd.Total.ToString()
 			} else {
@@ -214,6 +387,11 @@ func (s *ClockTableState) flattenData(ofile *common.OrgFile, data *[]map[string]
 			}
 			d["title"] = hindent + common.GetSectionTitle(sec)
 			d["lvl"] = sec.Headline.Lvl
+			props := []string{}
+			for _, p := range s.Properties {
+				props = append(props, GetProp(sec, p))
+			}
+			d["props"] = props
 			if !s.SkipEmpty || sd.Local.Mins != 0 || ((s.MaxLevel <= 0 || sec.Headline.Lvl < s.MaxLevel) && len(sec.Children) > 0) {
 				*data = append(*data, d)
 			}
@@ -226,30 +404,169 @@ func (s *ClockTableState) flattenData(ofile *common.OrgFile, data *[]map[string]
 	}
 }
 
-func (s *ClockTableState) GenerateTableForSubtree(ofile *common.OrgFile, sec *org.Section) string {
-	res := ""
+// Part of the outline a clock table reports on.
+type clockTableScope struct {
+	File *common.OrgFile
+	Root *org.Section
+}
 
+func (s *ClockTableState) scopes(ofile *common.OrgFile, parent *org.Section) ([]clockTableScope, error) {
+	var files []*common.OrgFile
+	switch {
+	case s.Files != nil:
+		for _, name := range s.Files {
+			f := GetDb().GetFile(name)
+			if f == nil && !filepath.IsAbs(name) {
+				f = GetDb().GetFile(filepath.Join(filepath.Dir(ofile.Filename), name))
+			}
+			if f == nil || f.Doc == nil || !CanReadFile(s.Username, f.Filename) {
+				return nil, fmt.Errorf("clocktable - could not find file %s", name)
+			}
+			files = append(files, f)
+		}
+	case s.Scope == "agenda":
+		for _, name := range FilterFiles(s.Username, GetDb().GetFiles()) {
+			if f := GetDb().GetFile(name); f != nil && f.Doc != nil {
+				files = append(files, f)
+			}
+		}
+	case s.Scope == "file":
+		files = append(files, ofile)
+	default:
+		return []clockTableScope{{File: ofile, Root: parent}}, nil
+	}
+	var res []clockTableScope
+	for _, f := range files {
+		res = append(res, clockTableScope{File: f, Root: f.Doc.Outline.Section})
+	}
+	return res, nil
+}
+
+// Build the rows for one range of time, returns the rows and the total minutes.
+func (s *ClockTableState) tableData(scopes []clockTableScope) ([]map[string]interface{}, float64) {
+	s.Durations = map[*org.Section]*ClockTableDuration{}
 	data := []map[string]interface{}{}
-	for _, c := range sec.Children {
-		s.flattenData(ofile, &data, c, sec.Headline.Lvl)
+	total := 0.0
+	multi := len(scopes) > 1 || s.Files != nil || s.Scope == "agenda"
+	for _, sc := range scopes {
+		fileTotal := &common.OrgDuration{}
+		for _, c := range sc.Root.Children {
+			fileTotal = fileTotal.Add(s.GenerateForSubtree(sc.File, c))
+		}
+		if s.FileSkip0 && fileTotal.Mins == 0 {
+			continue
+		}
+		if multi {
+			data = append(data, map[string]interface{}{
+				"title": "*" + filepath.Base(sc.File.Filename) + "*",
+				"local": "",
+				"total": "*" + fileTotal.ToString() + "*",
+				"mins":  fileTotal.Mins,
+				"lvl":   0,
+				"file":  true,
+				"props": make([]string, len(s.Properties)),
+			})
+		}
+		for _, c := range sc.Root.Children {
+			s.flattenData(sc.File, &data, c, headingLevel(sc.Root))
+		}
+		total += fileTotal.Mins
+	}
+	for _, d := range data {
+		if total > 0 {
+			d["percent"] = fmt.Sprintf("%.1f", d["mins"].(float64)*100.0/total)
+		} else {
+			d["percent"] = ""
+		}
 	}
+	return data, total
+}
 
-	template := "defaultclocktable.tpl"
+func (s *ClockTableState) renderTable(scopes []clockTableScope, title string) (string, float64) {
+	data, total := s.tableData(scopes)
+	totalDur := common.NewDuration(total)
 	ctx := make(map[string]interface{})
 	ctx["data"] = data
-	res = Conf().PlugManager.Tempo.RenderTemplate(template, ctx)
-	//fmt.Printf("TABLE\n%s\n", res)
+	ctx["title"] = title
+	ctx["properties"] = s.Properties
+	ctx["percent"] = s.Percent
+	ctx["total"] = totalDur.ToString()
+	return Conf().PlugManager.Tempo.RenderTemplate("defaultclocktable.tpl", ctx), total
+}
+
+func (s *ClockTableState) GenerateTableForSubtree(ofile *common.OrgFile, sec *org.Section) string {
+	res, _ := s.renderTable([]clockTableScope{{File: ofile, Root: sec}}, "")
 	return res
 }
 
+func nextStep(t time.Time, step string) time.Time {
+	switch step {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func stepTitle(t time.Time, step string) string {
+	switch step {
+	case "week":
+		return "Weekly report starting on: " + t.Format(orgDateLayout)
+	case "month":
+		return "Monthly report starting on: " + t.Format(orgDateLayout)
+	}
+	return "Daily report: " + t.Format(orgDateLayout)
+}
+
+const orgDateLayout = "[2006-01-02 Mon]"
+
+// Render the table, once per step when stepping through the range.
+func (s *ClockTableState) Generate(ofile *common.OrgFile, parent *org.Section) (string, error) {
+	scopes, err := s.scopes(ofile, parent)
+	if err != nil {
+		return "", err
+	}
+	if s.Step == "" {
+		res, _ := s.renderTable(scopes, "")
+		return res, nil
+	}
+	full := s.Block
+	defer func() { s.Block = full }()
+	end := full.Date.End.Add(time.Second)
+	start := GetBeginOfDay(full.Date.Start)
+	if s.Step == "week" {
+		start = startOfWeek(start)
+	} else if s.Step == "month" {
+		start = startOfMonth(start)
+	}
+	var tables []string
+	for t := start; t.Before(end); t = nextStep(t, s.Step) {
+		stepStart, stepEnd := t, nextStep(t, s.Step)
+		if stepStart.Before(full.Date.Start) {
+			stepStart = full.Date.Start
+		}
+		if stepEnd.After(end) {
+			stepEnd = end
+		}
+		s.Block = NewBlockTest(stepStart, stepEnd)
+		res, total := s.renderTable(scopes, stepTitle(t, s.Step))
+		if s.StepSkip0 && total == 0 {
+			continue
+		}
+		tables = append(tables, res)
+	}
+	return strings.Join(tables, "\n"), nil
+}
+
 // MAIN - Clock table entry
-func GenerateClockTable(ofile *common.OrgFile, parent *org.Section, blk *org.Block) *common.ResultMsg {
+func GenerateClockTable(ofile *common.OrgFile, parent *org.Section, blk *org.Block, username string) *common.ResultMsg {
 	var res common.ResultMsg
 	res.Ok = false
 	res.Msg = "Unknown error"
-	ct := ClockTableState{}
+	ct := ClockTableState{Username: username}
 	ct.Durations = map[*org.Section]*ClockTableDuration{}
-	if err := ct.ParseParams(blk.ParameterMap()); err != nil {
+	if err := ct.ParseParams(ClockTableParams(blk)); err != nil {
 		res.Msg = err.Error()
 		return &res
 	}
@@ -258,13 +575,11 @@ func GenerateClockTable(ofile *common.OrgFile, parent *org.Section, blk *org.Blo
 		return &res
 	}
 
-	// Use file level scope
-	if ct.Scope == "file" {
-		parent = ofile.Doc.Outline.Section
+	resStr, err := ct.Generate(ofile, parent)
+	if err != nil {
+		res.Msg = err.Error()
+		return &res
 	}
-	// Now we have clock table data
-	ct.GenerateForSubtree(ofile, parent)
-	resStr := ct.GenerateTableForSubtree(ofile, parent)
 
 	if resStr != "" {
 		res.Ok = true
@@ -274,26 +589,22 @@ func GenerateClockTable(ofile *common.OrgFile, parent *org.Section, blk *org.Blo
 }
 
 func collectClockEntries(sec *org.Section, block *BlockTest, filename string, entries *[]common.ClockEntry) {
-	drawer := sec.Headline.FindDrawer(Conf().ClockIntoDrawer)
-	if drawer != nil && drawer.Children != nil {
-		var totalMins float64
-		for _, c := range drawer.Children {
-			if c.GetType() == org.ClockNode {
-				clk := c.(org.Clock)
-				if block == nil || block.Date.DateTimeInRange(clk.Date.Start) || block.Date.DateTimeInRange(clk.Date.End) {
-					totalMins += float64(clk.Date.DurationMins)
-				}
-			}
-		}
-		if totalMins > 0 {
-			*entries = append(*entries, common.ClockEntry{
-				Headline: common.GetSectionTitle(sec),
-				Filename: filename,
-				Level:    sec.Headline.Lvl,
-				Mins:     totalMins,
-			})
+	var totalMins float64
+	for _, clk := range common.HeadlineClocks(sec.Headline, Conf().ClockIntoDrawer) {
+		if block == nil {
+			totalMins += float64(clk.Date.DurationMins)
+		} else {
+			totalMins += block.Overlap(clk.Date)
 		}
 	}
+	if totalMins > 0 {
+		*entries = append(*entries, common.ClockEntry{
+			Headline: common.GetSectionTitle(sec),
+			Filename: filename,
+			Level:    sec.Headline.Lvl,
+			Mins:     totalMins,
+		})
+	}
 	for _, c := range sec.Children {
 		collectClockEntries(c, block, filename, entries)
 	}
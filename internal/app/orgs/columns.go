@@ -0,0 +1,467 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Querying
+* Column View
+
+  Column view works out a table of properties for a heading and all of
+  the headings under it, rolling values up the tree as it goes, like
+  org-columns. It is available from =/api/columns/{hash}=.
+
+  The columns come from the =COLUMNS= property of the heading or one of its
+  parents, then the =#+COLUMNS:= keyword of the file and finally the
+  default of =%25ITEM %TODO %3PRIORITY %TAGS=.
+
+  #+BEGIN_SRC org
+  #+COLUMNS: %40ITEM %TODO %Effort(Estimate){:} %CLOCKSUM(Clocked){:} %DONE(Done){X%}
+  #+END_SRC
+
+  Each column is =%[width]PROPERTY[(title)][{summary}]=. A few properties
+  are special:
+  - ITEM, the heading text.
+  - TODO, PRIORITY and TAGS from the heading.
+  - CLOCKSUM, the time clocked on the heading. It is always summed up the tree.
+
+  A summary fills in the value of a parent heading from its children,
+  replacing any value the parent has itself:
+  - ={+}= sums numbers, ={$}= sums money to two places.
+  - ={min}=, ={max}= and ={mean}= of numbers.
+  - ={:}= sums durations like =1:30= or =2d=, ={:min}=, ={:max}= and ={:mean}= also work.
+  - ={X}= is =[X]= when every child is =[X]=, =[-]= when some are and =[ ]= otherwise.
+  - ={X/}= and ={X%}= count the checked children as =[2/5]= or =[40%]=.
+  - ={est+}= sums =low-high= estimates, like =2-4=, into a single range.
+
+  Every row also carries the Effort and clocked minutes rolled up the
+  tree and how far the clocked time has got through the Effort.
+
+** Statistics Cookies
+
+  The =cookies= updater refreshes =[2/5]= and =[40%]= cookies in the
+  headings of a subtree. A cookie counts the TODO children of its heading,
+  or the checkboxes in its lists when it has no TODO children. The
+  =COOKIE_DATA= property can force =todo= or =checkbox= counting and
+  =recursive= counts every TODO heading under it rather than just the
+  children.
+
+  #+BEGIN_SRC yaml
+  updaters:
+    - name: "cookies"
+  #+END_SRC
+EDOC */
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+const defaultColumns = "%25ITEM %TODO %3PRIORITY %TAGS"
+
+var columnRe = regexp.MustCompile(`^%([0-9]*)([^\s({]+)(?:\(([^)]*)\))?(?:\{([^}]*)\})?$`)
+
+var columnSummaries = map[string]bool{
+	"+": true, "$": true, "min": true, "max": true, "mean": true,
+	":": true, ":min": true, ":max": true, ":mean": true,
+	"X": true, "X/": true, "X%": true, "est+": true,
+}
+
+// Parse a COLUMNS definition like "%25ITEM %Effort(Estimate){:}"
+func ParseColumns(str string) ([]common.ColumnDef, error) {
+	cols := []common.ColumnDef{}
+	for _, f := range strings.Fields(str) {
+		m := columnRe.FindStringSubmatch(f)
+		if m == nil {
+			return nil, fmt.Errorf("bad column definition %q", f)
+		}
+		col := common.ColumnDef{Property: m[2], Title: m[3], Summary: m[4]}
+		if m[1] != "" {
+			col.Width, _ = strconv.Atoi(m[1])
+		}
+		if col.Title == "" {
+			col.Title = col.Property
+		}
+		if col.Summary != "" && !columnSummaries[col.Summary] {
+			return nil, fmt.Errorf("unknown column summary {%s} on %s", col.Summary, col.Property)
+		}
+		// The clock sum only makes sense summed.
+		if strings.EqualFold(col.Property, "CLOCKSUM") && col.Summary == "" {
+			col.Summary = ":"
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// The COLUMNS definition that applies to a heading.
+func ColumnsFor(sec *org.Section, f *common.OrgFile) string {
+	for s := sec; s != nil; s = s.Parent {
+		if v := GetProp(s, "COLUMNS"); v != "" {
+			return v
+		}
+	}
+	if f != nil && f.Doc != nil {
+		if v := f.Doc.Get("COLUMNS"); v != "" {
+			return v
+		}
+	}
+	return defaultColumns
+}
+
+// Properties are not case sensitive in org.
+func columnProp(sec *org.Section, name string) string {
+	if sec.Headline == nil || sec.Headline.Properties == nil {
+		return ""
+	}
+	for _, p := range sec.Headline.Properties.Properties {
+		if strings.EqualFold(p[0], name) {
+			return p[1]
+		}
+	}
+	return ""
+}
+
+func clockedMins(sec *org.Section) float64 {
+	s := ClockTableState{}
+	return s.CalcClockDuration(sec).Mins
+}
+
+func formatColumnDuration(mins float64) string {
+	m := int(math.Round(mins))
+	return fmt.Sprintf("%d:%02d", m/60, m%60)
+}
+
+func parseColumnDuration(str string) (float64, bool) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseFloat(str, 64); err == nil {
+		// A bare number is a number of minutes.
+		return n, true
+	}
+	if d := common.ParseDuration(str); d != nil {
+		return d.Mins, true
+	}
+	return 0, false
+}
+
+func columnValue(sec *org.Section, col common.ColumnDef) string {
+	h := sec.Headline
+	switch strings.ToUpper(col.Property) {
+	case "ITEM":
+		return common.GetHeadlineTitle(h)
+	case "TODO":
+		return h.Status
+	case "PRIORITY":
+		return h.Priority
+	case "TAGS":
+		if len(h.Tags) == 0 {
+			return ""
+		}
+		return ":" + strings.Join(h.Tags, ":") + ":"
+	case "CLOCKSUM":
+		if m := clockedMins(sec); m > 0 {
+			return formatColumnDuration(m)
+		}
+		return ""
+	}
+	return columnProp(sec, col.Property)
+}
+
+// Fold the values of the children into one with the summary operator.
+func summarize(op string, vals []string) string {
+	nums := []float64{}
+	for _, v := range vals {
+		if strings.HasPrefix(op, ":") {
+			if d, ok := parseColumnDuration(v); ok {
+				nums = append(nums, d)
+			}
+		} else if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			nums = append(nums, n)
+		}
+	}
+	fold := func(start float64, f func(a, b float64) float64) float64 {
+		r := start
+		for _, n := range nums {
+			r = f(r, n)
+		}
+		return r
+	}
+	sum := fold(0, func(a, b float64) float64 { return a + b })
+	switch op {
+	case "X", "X/", "X%":
+		done := 0
+		for _, v := range vals {
+			if v == "[X]" || v == "[x]" {
+				done++
+			} else if m := cookieRe.FindStringSubmatch(v); m != nil && isCookieComplete(m[1]) {
+				done++
+			}
+		}
+		switch op {
+		case "X/":
+			return fmt.Sprintf("[%d/%d]", done, len(vals))
+		case "X%":
+			return fmt.Sprintf("[%d%%]", percentOf(done, len(vals)))
+		}
+		if done == len(vals) {
+			return "[X]"
+		} else if done > 0 {
+			return "[-]"
+		}
+		return "[ ]"
+	case "est+":
+		low, high := 0.0, 0.0
+		for _, v := range vals {
+			l, h, ok := strings.Cut(strings.TrimSpace(v), "-")
+			if !ok {
+				h = l
+			}
+			lf, err1 := strconv.ParseFloat(l, 64)
+			hf, err2 := strconv.ParseFloat(h, 64)
+			if err1 == nil && err2 == nil {
+				low += lf
+				high += hf
+			}
+		}
+		return strconv.FormatFloat(low, 'f', -1, 64) + "-" + strconv.FormatFloat(high, 'f', -1, 64)
+	}
+	if len(nums) == 0 {
+		return ""
+	}
+	var r float64
+	switch op {
+	case "min", ":min":
+		r = fold(math.Inf(1), math.Min)
+	case "max", ":max":
+		r = fold(math.Inf(-1), math.Max)
+	case "mean", ":mean":
+		r = sum / float64(len(nums))
+	default:
+		r = sum
+	}
+	if strings.HasPrefix(op, ":") {
+		return formatColumnDuration(r)
+	}
+	if op == "$" {
+		return strconv.FormatFloat(r, 'f', 2, 64)
+	}
+	return strconv.FormatFloat(r, 'f', -1, 64)
+}
+
+type columnView struct {
+	cols []common.ColumnDef
+	rows []common.ColumnRow
+}
+
+type columnTotals struct {
+	values  []string
+	effort  float64
+	clocked float64
+}
+
+// Add a row for the heading and its children, returning the rolled up values.
+func (self *columnView) walk(sec *org.Section) columnTotals {
+	idx := len(self.rows)
+	self.rows = append(self.rows, common.ColumnRow{
+		Hash:     sec.Hash,
+		Headline: common.GetHeadlineTitle(sec.Headline),
+		Level:    sec.Headline.Lvl,
+	})
+	kids := []columnTotals{}
+	for _, c := range sec.Children {
+		if c.Headline != nil {
+			kids = append(kids, self.walk(c))
+		}
+	}
+	res := columnTotals{values: make([]string, len(self.cols))}
+	for i, col := range self.cols {
+		own := columnValue(sec, col)
+		vals := []string{}
+		for _, k := range kids {
+			if k.values[i] != "" {
+				vals = append(vals, k.values[i])
+			}
+		}
+		if col.Summary == "" || len(vals) == 0 {
+			res.values[i] = own
+			continue
+		}
+		if strings.EqualFold(col.Property, "CLOCKSUM") && own != "" {
+			vals = append(vals, own)
+		}
+		res.values[i] = summarize(col.Summary, vals)
+	}
+	res.clocked = clockedMins(sec)
+	childEffort := 0.0
+	for _, k := range kids {
+		res.clocked += k.clocked
+		childEffort += k.effort
+	}
+	if childEffort > 0 {
+		res.effort = childEffort
+	} else if e, ok := parseColumnDuration(columnProp(sec, "Effort")); ok {
+		res.effort = e
+	}
+	row := &self.rows[idx]
+	row.Values = res.values
+	row.EffortMins = res.effort
+	row.ClockedMins = res.clocked
+	if res.effort > 0 {
+		row.Progress = math.Round(res.clocked / res.effort * 100)
+	}
+	return res
+}
+
+// Build the column view for a heading and everything under it.
+// If columns is empty the COLUMNS definition for the heading is used.
+func GetColumnView(sec *org.Section, f *common.OrgFile, columns string) (*common.ColumnView, error) {
+	if columns == "" {
+		columns = ColumnsFor(sec, f)
+	}
+	cols, err := ParseColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	v := columnView{cols: cols}
+	if sec.Headline != nil {
+		v.walk(sec)
+	} else {
+		for _, c := range sec.Children {
+			if c.Headline != nil {
+				v.walk(c)
+			}
+		}
+	}
+	return &common.ColumnView{Columns: cols, Rows: v.rows}, nil
+}
+
+var cookieRe = regexp.MustCompile(`^\[([0-9]*%|[0-9]*/[0-9]*)\]$`)
+
+func isCookieComplete(c string) bool {
+	if p, ok := strings.CutSuffix(c, "%"); ok {
+		return p == "100"
+	}
+	n, m, _ := strings.Cut(c, "/")
+	return n != "" && n == m
+}
+
+func percentOf(n, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return n * 100 / total
+}
+
+func countTodos(sec *org.Section, f *common.OrgFile, recursive bool) (done int, total int) {
+	for _, c := range sec.Children {
+		if c.Headline != nil && c.Headline.Status != "" {
+			total++
+			if isDoneState(f, c.Headline.Status) {
+				done++
+			}
+		}
+		if recursive {
+			d, t := countTodos(c, f, recursive)
+			done += d
+			total += t
+		}
+	}
+	return
+}
+
+func countCheckboxes(nodes []org.Node) (done int, total int) {
+	for _, n := range nodes {
+		lst, ok := n.(org.List)
+		if !ok {
+			continue
+		}
+		for _, i := range lst.Items {
+			if itm, ok := i.(org.ListItem); ok && itm.Status != "" {
+				total++
+				if itm.Status == "X" || itm.Status == "x" {
+					done++
+				}
+			}
+		}
+	}
+	return
+}
+
+// Refresh the statistics cookies in a heading and everything under it.
+// Returns how many cookies changed.
+func UpdateCookies(sec *org.Section, f *common.OrgFile) int {
+	changed := 0
+	for _, c := range sec.Children {
+		changed += UpdateCookies(c, f)
+	}
+	return changed + updateHeadlineCookies(sec, f)
+}
+
+// Refresh the statistics cookies in the title of one heading.
+func updateHeadlineCookies(sec *org.Section, f *common.OrgFile) int {
+	changed := 0
+	h := sec.Headline
+	if h == nil {
+		return changed
+	}
+	for i, n := range h.Title {
+		tok, ok := n.(org.StatisticToken)
+		if !ok {
+			continue
+		}
+		data := strings.ToLower(GetProp(sec, "COOKIE_DATA", "cookie_data"))
+		var done, total int
+		if strings.Contains(data, "checkbox") {
+			done, total = countCheckboxes(h.Children)
+		} else {
+			done, total = countTodos(sec, f, strings.Contains(data, "recursive"))
+			if total == 0 && !strings.Contains(data, "todo") {
+				done, total = countCheckboxes(h.Children)
+			}
+		}
+		content := fmt.Sprintf("%d/%d", done, total)
+		if strings.HasSuffix(tok.Content, "%") {
+			content = fmt.Sprintf("%d%%", percentOf(done, total))
+		}
+		if content != tok.Content {
+			h.Title[i] = org.StatisticToken{Content: content}
+			changed++
+		}
+	}
+	return changed
+}
+
+// The cookies updater refreshes the statistics cookies under a target.
+type CookieUpdater struct {
+}
+
+func (self *CookieUpdater) UpdateTarget(db common.ODb, target *common.Target, manager *common.PluginManager) (common.ResultMsg, error) {
+	ofile, sec := db.GetFromTarget(target, false)
+	if sec == nil {
+		return common.ResultMsg{Ok: false, Msg: "Could not find target"}, fmt.Errorf("could not find target %s", target.Id)
+	}
+	n := UpdateCookies(sec, ofile)
+	if n > 0 {
+		WriteOutOrgFile(dbContext(db), ofile, sec.Hash)
+	}
+	return common.ResultMsg{Ok: true, Msg: fmt.Sprintf("Updated %d cookies", n)}, nil
+}
+
+func (self *CookieUpdater) Startup(freq int, manager *common.PluginManager, opts *common.PluginOpts) {
+}
+
+func (self *CookieUpdater) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func init() {
+	common.AddUpdater("cookies", func() common.Updater {
+		return &CookieUpdater{}
+	})
+}
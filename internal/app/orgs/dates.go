@@ -0,0 +1,276 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Querying
+** Dates In Queries
+
+  The date methods take dates and ranges as strings.
+
+  A date can be:
+  - An ISO date =2024-01-15= or date and time =2024-01-15 13:30=
+  - An org timestamp =<2024-01-15 Mon>=
+  - =today=, =tomorrow=, =yesterday= or =now=
+  - An offset from today like =+2w=, =-1m= or =3d= (units h, d, w, m and y)
+
+  A range can be:
+  - Any date, meaning that whole day
+  - =this-week=, =last-week=, =next-week= (weeks start on Monday)
+  - =this-month=, =last-month=, =next-month=, =this-year=, =last-year=, =next-year=
+  - =2024-01= for a month or =2024= for a year
+  - An offset like =-7d=, from that day up to the end of today, or =+7d=,
+    from today to the end of that day
+  - Two dates separated by =..= like =2024-01-01..2024-03-31= or =-1w..+1w=
+
+   #+BEGIN_SRC cpp
+   IsTodo() && DeadlineWithin('3d')
+   IsTodo() && ScheduledBefore('tomorrow')
+   !IsTodo() && ClosedIn('last-week')
+   #+END_SRC
+EDOC */
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+var relativeDateRe = regexp.MustCompile(`^([+-]?)([0-9]+)([hdwmy])$`)
+
+var queryDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// Move now by n units of h, d, w, m or y. Anything coarser than
+// an hour lands on the start of the day.
+func OffsetDate(now time.Time, n int, unit byte) time.Time {
+	switch unit {
+	case 'h':
+		return now.Add(time.Duration(n) * time.Hour)
+	case 'w':
+		return GetBeginOfDay(now.AddDate(0, 0, 7*n))
+	case 'm':
+		return GetBeginOfDay(now.AddDate(0, n, 0))
+	case 'y':
+		return GetBeginOfDay(now.AddDate(n, 0, 0))
+	}
+	return GetBeginOfDay(now.AddDate(0, 0, n))
+}
+
+func parseOffset(str string) (int, byte, bool) {
+	m := relativeDateRe.FindStringSubmatch(str)
+	if m == nil {
+		return 0, 0, false
+	}
+	n, _ := strconv.Atoi(m[2])
+	if m[1] == "-" {
+		n = -n
+	}
+	return n, m[3][0], true
+}
+
+// Parse a date from a query. haveTime is false for dates that name a whole day.
+func ParseQueryDate(str string, now time.Time) (t time.Time, haveTime bool, err error) {
+	str = strings.TrimSpace(str)
+	switch strings.ToLower(str) {
+	case "today":
+		return GetBeginOfDay(now), false, nil
+	case "tomorrow":
+		return GetBeginOfDay(now.AddDate(0, 0, 1)), false, nil
+	case "yesterday":
+		return GetBeginOfDay(now.AddDate(0, 0, -1)), false, nil
+	case "now":
+		return now, true, nil
+	}
+	if n, unit, ok := parseOffset(str); ok {
+		return OffsetDate(now, n, unit), unit == 'h', nil
+	}
+	for _, layout := range queryDateLayouts {
+		if t, err := time.ParseInLocation(layout, str, now.Location()); err == nil {
+			return t, layout != "2006-01-02", nil
+		}
+	}
+	if strings.HasPrefix(str, "<") || strings.HasPrefix(str, "[") {
+		if date, _, _ := org.ParseTimestamp(str); date != nil {
+			return date.Start, date.HaveTime, nil
+		}
+	}
+	// Older queries used year, day, month
+	if t, err := time.ParseInLocation("2006 02 01", str, now.Location()); err == nil {
+		return t, false, nil
+	}
+	return now, false, fmt.Errorf("unknown date %q", str)
+}
+
+func startOfWeek(t time.Time) time.Time {
+	// Weekday is 0 on Sunday, org weeks start on Monday.
+	return GetBeginOfDay(t.AddDate(0, 0, -(int(t.Weekday())+6)%7))
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func startOfYear(t time.Time) time.Time {
+	return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+}
+
+// Parse a date range from a query. The range runs from start up to but not including end.
+func ParseDateRange(str string, now time.Time) (start time.Time, end time.Time, err error) {
+	str = strings.TrimSpace(str)
+	if from, to, ok := strings.Cut(str, ".."); ok {
+		var haveTime bool
+		if start, _, err = ParseQueryDate(from, now); err != nil {
+			return
+		}
+		if end, haveTime, err = ParseQueryDate(to, now); err != nil {
+			return
+		}
+		if !haveTime {
+			end = end.AddDate(0, 0, 1)
+		}
+		if end.Before(start) {
+			err = fmt.Errorf("date range %q ends before it starts", str)
+		}
+		return
+	}
+	switch strings.ToLower(str) {
+	case "this-week", "thisweek":
+		start = startOfWeek(now)
+		return start, start.AddDate(0, 0, 7), nil
+	case "last-week", "lastweek":
+		start = startOfWeek(now).AddDate(0, 0, -7)
+		return start, start.AddDate(0, 0, 7), nil
+	case "next-week", "nextweek":
+		start = startOfWeek(now).AddDate(0, 0, 7)
+		return start, start.AddDate(0, 0, 7), nil
+	case "this-month", "thismonth":
+		start = startOfMonth(now)
+		return start, start.AddDate(0, 1, 0), nil
+	case "last-month", "lastmonth":
+		start = startOfMonth(now).AddDate(0, -1, 0)
+		return start, start.AddDate(0, 1, 0), nil
+	case "next-month", "nextmonth":
+		start = startOfMonth(now).AddDate(0, 1, 0)
+		return start, start.AddDate(0, 1, 0), nil
+	case "this-year", "thisyear":
+		start = startOfYear(now)
+		return start, start.AddDate(1, 0, 0), nil
+	case "last-year", "lastyear":
+		start = startOfYear(now).AddDate(-1, 0, 0)
+		return start, start.AddDate(1, 0, 0), nil
+	case "next-year", "nextyear":
+		start = startOfYear(now).AddDate(1, 0, 0)
+		return start, start.AddDate(1, 0, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006-01", str, now.Location()); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006", str, now.Location()); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	if n, unit, ok := parseOffset(str); ok {
+		t := OffsetDate(now, n, unit)
+		if n < 0 {
+			return t, EndOfToday(), nil
+		}
+		return GetBeginOfDay(now), GetEndOfDay(t), nil
+	}
+	var haveTime bool
+	if start, haveTime, err = ParseQueryDate(str, now); err != nil {
+		return
+	}
+	if haveTime {
+		return start, start.Add(time.Minute), nil
+	}
+	return start, start.AddDate(0, 0, 1), nil
+}
+
+func sdcStart(sdc *org.SDC) (time.Time, bool) {
+	if sdc != nil && sdc.Date != nil {
+		return sdc.Date.Start, true
+	}
+	return time.Time{}, false
+}
+
+// Is the date before the given query date. Whole day dates
+// compare against the start of that day.
+func DateBefore(sdc *org.SDC, str string) (bool, error) {
+	t, _, err := ParseQueryDate(str, time.Now())
+	if err != nil {
+		return false, err
+	}
+	d, ok := sdcStart(sdc)
+	return ok && d.Before(t), nil
+}
+
+// Is the date after the given query date. Whole day dates
+// compare against the end of that day.
+func DateAfter(sdc *org.SDC, str string) (bool, error) {
+	t, haveTime, err := ParseQueryDate(str, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if !haveTime {
+		t = GetEndOfDay(t)
+	}
+	d, ok := sdcStart(sdc)
+	return ok && !d.Before(t), nil
+}
+
+func DateIn(sdc *org.SDC, str string) (bool, error) {
+	start, end, err := ParseDateRange(str, time.Now())
+	if err != nil {
+		return false, err
+	}
+	d, ok := sdcStart(sdc)
+	return ok && !d.Before(start) && d.Before(end), nil
+}
+
+// Is the deadline inside the given distance from today, overdue deadlines count.
+func DeadlineWithin(p *org.Section, str string) (bool, error) {
+	str = strings.TrimSpace(str)
+	if !strings.HasPrefix(str, "+") && !strings.HasPrefix(str, "-") {
+		str = "+" + str
+	}
+	n, unit, ok := parseOffset(str)
+	if !ok {
+		return false, fmt.Errorf("unknown distance %q", str)
+	}
+	d, ok := sdcStart(p.Headline.Deadline)
+	return ok && d.Before(GetEndOfDay(OffsetDate(time.Now(), n, unit))), nil
+}
+
+func IsDone(p *org.Section, f *common.OrgFile) bool {
+	return p != nil && p.Headline != nil && isDoneState(f, p.Headline.Status)
+}
+
+// A heading that is not done with a deadline before today.
+func IsOverdue(p *org.Section, f *common.OrgFile) bool {
+	if p == nil || p.Headline == nil || IsDone(p, f) {
+		return false
+	}
+	d, ok := sdcStart(p.Headline.Deadline)
+	return ok && d.Before(Today())
+}
+
+func HasRepeater(p *org.Section) bool {
+	if p == nil || p.Headline == nil {
+		return false
+	}
+	h := p.Headline
+	for _, sdc := range []*org.SDC{h.Scheduled, h.Deadline} {
+		if sdc != nil && sdc.Date != nil && sdc.Date.RepeatDWMY != "" {
+			return true
+		}
+	}
+	return h.Timestamp != nil && h.Timestamp.Time != nil && h.Timestamp.Time.RepeatDWMY != ""
+}
@@ -36,9 +36,9 @@ package orgs
 EDOC */
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -105,7 +105,7 @@ func ParentIn(nodes []*org.Section, me *org.Section) bool {
 	return false
 }
 
-func CreateDayPage() (common.FileList, error) {
+func CreateDayPage(ctx context.Context) (common.FileList, error) {
 
 	template := Conf().Server.DayPageTemplate
 	dt := time.Now()
@@ -128,7 +128,7 @@ func CreateDayPage() (common.FileList, error) {
 
 				// Now go archive the old page since we have a new page to work with.
 				if AddFileTag("ARCHIVE", ofile.Doc) {
-					WriteOutOrgFile(ofile)
+					WriteOutOrgFile(ctx, ofile)
 				}
 			}
 		}
@@ -152,7 +152,7 @@ func CreateDayPage() (common.FileList, error) {
 			todayData = w.String()
 		}
 		fmt.Printf("WRITING TEMPLATE %s\n", filename)
-		ioutil.WriteFile(filename, []byte(todayData), fs.ModePerm)
+		writeOrgFile(ctx, filename, []byte(todayData), fs.ModePerm)
 	}
 	return []string{filename}, nil
 }
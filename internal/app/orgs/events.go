@@ -0,0 +1,200 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// How often an idle stream is pinged to keep proxies from closing it.
+const eventKeepAlive = 30 * time.Second
+
+var eventUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: common.MaxMessageSize,
+	CheckOrigin:     checkEventOrigin,
+}
+
+// Browsers send the cookie with a WebSocket from any page, so only pages
+// served from this host or listed in allowedOrigins may open one. Clients
+// that are not browsers do not send an Origin at all.
+func checkEventOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range Conf().AllowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	Log().Warningf("events: refusing websocket from origin %s", origin)
+	return false
+}
+
+type eventFilter struct {
+	username string
+	types    map[string]bool
+}
+
+func newEventFilter(r *http.Request) *eventFilter {
+	f := &eventFilter{username: GetUsername(r), types: map[string]bool{}}
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.types[t] = true
+		}
+	}
+	return f
+}
+
+// Users only hear about files they are allowed to read.
+func (self *eventFilter) Allow(e *common.Event) bool {
+	if len(self.types) > 0 && !self.types[e.Type] {
+		return false
+	}
+	if e.Filename != "" && !CanReadFile(self.username, e.Filename) {
+		return false
+	}
+	return true
+}
+
+/* SDOC: API
+* GET /events — Stream Change Notifications
+	Opens a long lived stream of change notifications so clients do not have to poll.
+	A plain request is answered as Server-Sent Events (=text/event-stream=); a request
+	carrying a WebSocket upgrade is answered as a WebSocket that sends one JSON event
+	per text message. Both forms use the normal authentication (bearer token or the
+	=orgstoken= cookie) and only report on files the user is allowed to read.
+	A WebSocket opened from a browser page is refused unless the page comes from
+	this server or from one of the allowedOrigins in the config.
+
+	*Method:* =GET=
+
+	*Query Parameters:*
+	| Parameter | Type   | Required | Description                                                   |
+	|-----------+--------+----------+---------------------------------------------------------------|
+	| =types=   | string | no       | Comma separated list of event types to receive. Default: all. |
+
+	*Event Types:*
+	| Type              | Sent When                                                         |
+	|-------------------+-------------------------------------------------------------------|
+	| =file.reloaded=   | A file was (re)parsed, usually because it changed on disk.        |
+	| =file.written=    | The server wrote a file without touching a specific heading.      |
+	| =heading.changed= | A heading was edited through the API. =hash= names the heading.   |
+	| =clock.in=        | The clock was started, =target= holds the heading.                |
+	| =clock.out=       | The clock was stopped.                                            |
+	| =capture.added=   | A capture was filed, =name= is the template used.                 |
+	| =plugin.finished= | A poller or updater plugin finished a run, =name= is the plugin.  |
+
+	*Response:* A stream of =Event= objects:
+	#+BEGIN_SRC json
+	{"type": "heading.changed", "time": "2024-01-15T09:00:00Z", "filename": "/home/user/org/todo.org", "hash": "..."}
+	#+END_SRC
+	With SSE each event is sent with its type as the SSE =event:= name.
+	EDOC */
+func RequestEvents(w http.ResponseWriter, r *http.Request) {
+	filter := newEventFilter(r)
+	if websocket.IsWebSocketUpgrade(r) {
+		serveEventsWs(w, r, filter)
+	} else {
+		serveEventsSse(w, r, filter)
+	}
+}
+
+func serveEventsSse(w http.ResponseWriter, r *http.Request, filter *eventFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "streaming is not supported by this connection"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := common.Events().Subscribe()
+	defer common.Events().Unsubscribe(events)
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.Allow(&e) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func serveEventsWs(w http.ResponseWriter, r *http.Request, filter *eventFilter) {
+	ws, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Log().Errorf("events: websocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	// We never expect anything from the client but have to read
+	// to notice when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	events := common.Events().Subscribe()
+	defer common.Events().Unsubscribe(events)
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.Allow(&e) {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := ws.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
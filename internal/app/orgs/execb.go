@@ -3,37 +3,60 @@ package orgs
 /* SDOC: Editing
 * Bable Block Execution
 
-  TODO: Fill in information on babel and literate programming
+  Source blocks can be executed in place, much like org babel. The block
+  is run by the executor registered for its language and the output is
+  written back into the file as a =#+RESULTS:= block beneath it.
+
+  #+BEGIN_SRC org
+  ,#+BEGIN_SRC python :results output :var n=5
+  print(n * 2)
+  ,#+END_SRC
+
+  ,#+RESULTS:
+  : 10
+  #+END_SRC
+
+  Header arguments are gathered from =#+PROPERTY: header-args=, the
+  =header-args= property of the enclosing headings and the block itself.
+
+  | Argument    | Values                                                                    |
+  |-------------+---------------------------------------------------------------------------|
+  | =:results=  | =output= or =value=; =replace=, =append=, =prepend= or =silent=; =raw= |
+  | =:var=      | =name=value= bindings, values may be quoted strings or named tables      |
+  | =:dir=      | Working directory, relative to the org file                               |
+  | =:session=  | Keep an interpreter alive between executions under this name             |
+  | =:eval=     | =no= or =never= disables execution of the block                           |
+  | =:timeout=  | Seconds before the execution is abandoned (default 60)                    |
+
+  Output that looks like an org table is inserted as a table.
 EDOC */
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ihdavids/go-org/org"
 	"github.com/ihdavids/orgs/internal/common"
 )
 
-func ExecBlock(db common.ODb, t *common.PreciseTarget) (common.ResultMsg, error) {
+func ExecBlock(ctx context.Context, db common.ODb, t *common.PreciseTarget, username string) (common.ResultMsg, error) {
 	res := common.ResultMsg{Ok: false, Msg: "Unknown block exec error"}
 	ofile, sec, block := db.GetFromPreciseTarget(t, org.BlockNode)
 	if block != nil {
 		blk := block.(*org.Block)
 		if blk.Name == "SRC" {
 			Log().Infof("Babel Block Execution\n")
-			// TODO Babel by name
-			if lang, ok := blk.ParameterMap()[":lang"]; ok {
-				fmt.Printf("Running language: %s\n", lang)
-			}
+			res = ExecBabelBlock(ctx, ofile, sec, blk, username)
 		} else if blk.Name == "DYN" {
 			Log().Infof("Dynamic Block Execution\n")
 			if lang, ok := blk.ParameterMap()[":lang"]; ok {
 				fmt.Printf("Function name: %s\n", lang)
 				if blockExec, ok := Conf().PlugManager.BlockExec[lang]; ok {
 					fmt.Printf("Have function\n")
-					res = *blockExec(ofile, sec, blk)
+					res = *blockExec(ofile, sec, blk, username)
 					if res.Ok {
 						blk.Children = []org.Node{org.Text{Content: res.Msg}}
-						WriteOutOrgFile(ofile)
+						WriteOutOrgFile(ctx, ofile, sectionHashes(sec)...)
 					}
 				}
 			}
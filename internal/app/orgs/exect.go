@@ -361,6 +361,9 @@ type CalcState struct {
 	AllFieldsNumbers    bool
 	ConsiderEmptyFields bool
 	MoneyMode           bool
+	Currency            string
+	PercentMode         bool
+	Thousands           bool
 	SkipHeader          bool
 }
 
@@ -376,8 +379,13 @@ type CalcState struct {
 ‘L’                    Literal, for Lisp formulas only. See the next section.
 // NON ORG MODE STUFF:
 '$'                    Money Mode - Treat numerical cells as monetary values and read write with $
+'€', '£', '¥'          Money Mode with that currency symbol instead of $
+'%'                    Percent Mode - 45% reads as 0.45 and results are written times 100 with a %
+','                    Thousands - Write results with thousands separators, read cells without them
 'H'                    Skip Header Mode - If table has a header with separator it is skipped in column commands
 */
+const currencySymbols = "$€£¥"
+
 var fspec = regexp.MustCompile(`%[+-]?\d*\.?\d* ?[oxdvTbcqUefgs]`)
 
 func (s *CalcState) ProcessCalcSpecifiers(format string) string {
@@ -391,7 +399,14 @@ func (s *CalcState) ProcessCalcSpecifiers(format string) string {
 		if format != "" {
 			s.AllFieldsNumbers = strings.Contains(format, "N")
 			s.ConsiderEmptyFields = strings.Contains(format, "E")
-			s.MoneyMode = strings.Contains(format, "$")
+			for _, c := range currencySymbols {
+				if strings.ContainsRune(format, c) {
+					s.MoneyMode = true
+					s.Currency = string(c)
+				}
+			}
+			s.PercentMode = strings.Contains(format, "%")
+			s.Thousands = strings.Contains(format, ",")
 			s.SkipHeader = !strings.Contains(format, "H")
 		}
 	}
@@ -409,7 +424,19 @@ func (s *CalcState) ProcessCellVal(val string) interface{} {
 
 	// In money mode we strip off $ so we can handle money on our numeric data
 	if s.MoneyMode {
-		val = strings.Replace(val, "$", "", 1)
+		val = strings.Replace(val, s.Currency, "", 1)
+	}
+	if s.MoneyMode || s.Thousands {
+		if nv, err := strconv.ParseFloat(strings.ReplaceAll(val, ",", ""), 64); err == nil {
+			return nv
+		}
+	}
+
+	// Percentages are read as a fraction
+	if s.PercentMode && strings.HasSuffix(val, "%") {
+		if nv, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(val, "%")), 64); err == nil {
+			return nv / 100.0
+		}
 	}
 
 	// Then fall back on potential number
@@ -467,18 +494,77 @@ func (s *CalcState) SetCell(tbl *org.Table, tgt *org.RowColRef, val interface{})
 	if s.Format != "" && s.Format[0] == '%' {
 		format = s.Format
 	}
-	if s.MoneyMode {
-		format = "$" + format
-	}
 	if d, ok := val.(time.Time); ok {
 		val = fmt.Sprintf("<%s>", d.Format("2006-01-02 Mon 15:04"))
 	}
 	if d, ok := val.(common.OrgDuration); ok {
 		val = d.ToString()
 	}
+	if n, ok := val.(int); ok && (s.PercentMode || s.MoneyMode) {
+		val = float64(n)
+	}
+	if n, ok := val.(float64); ok {
+		tbl.SetValRef(tgt, s.formatNumber(format, n))
+		return
+	}
+	if s.MoneyMode {
+		format = s.Currency + format
+	}
 	tbl.SetValRef(tgt, fmt.Sprintf(format, val))
 }
 
+// Numbers are written with the percent, currency and thousands modes applied.
+func (s *CalcState) formatNumber(format string, n float64) string {
+	if s.PercentMode {
+		n = n * 100.0
+		if s.Format == "" {
+			format = "%.1f"
+		}
+	} else if s.MoneyMode && s.Format == "" {
+		format = "%.2f"
+	}
+	sign := ""
+	if (s.MoneyMode || s.Thousands) && n < 0 {
+		sign = "-"
+		n = -n
+	}
+	out := fmt.Sprintf(format, n)
+	if s.Thousands {
+		out = addThousands(out)
+	}
+	if s.PercentMode {
+		out += "%"
+	}
+	if s.MoneyMode {
+		out = s.Currency + out
+	}
+	return sign + out
+}
+
+// Put commas between the thousands of the leading digits of a number.
+func addThousands(num string) string {
+	start := 0
+	for start < len(num) && (num[start] == '-' || num[start] == '+' || num[start] == ' ') {
+		start++
+	}
+	end := start
+	for end < len(num) && num[end] >= '0' && num[end] <= '9' {
+		end++
+	}
+	digits := num[start:end]
+	if len(digits) <= 3 {
+		return num
+	}
+	var sb strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(d)
+	}
+	return num[:start] + sb.String() + num[end:]
+}
+
 func BuildParameters(ofile *common.OrgFile, sec *org.Section, tbl *org.Table) *map[string]interface{} {
 	parameters := make(map[string]interface{}, 8)
 	parameters["section"] = sec
@@ -724,6 +810,7 @@ func ExecuteFormula(db common.ODb, sec *org.Section, ofile *common.OrgFile, tbl
 			if rexpr, err := ReplaceRemoteRanges(frml.Expr); err == nil {
 				frml.Expr = rexpr
 			} else {
+				frml.Expr = oldexpr
 				return err
 			}
 			// Replace other ranges with RangeIters
@@ -753,7 +840,9 @@ func ExecuteFormula(db common.ODb, sec *org.Section, ofile *common.OrgFile, tbl
 			//       For now this gets us operational
 			expr, err := ParseTableFormula(tbl, frml, sec, ofile)
 			if err != nil {
-				return fmt.Errorf("Failed parsing formula [%s][%d](%s)", frml.Keyword.Value, idx, frml.Expr)
+				err = fmt.Errorf("Failed parsing formula [%s][%d](%s)", frml.Keyword.Value, idx, frml.Expr)
+				frml.Expr = oldexpr
+				return err
 			}
 
 			//fmt.Printf("GOT SLOT: %d %d\n", tgt.Row, tgt.Col)
@@ -779,6 +868,8 @@ func ExecuteFormula(db common.ODb, sec *org.Section, ofile *common.OrgFile, tbl
 				//fmt.Printf("---> Setting value\n")
 			} else {
 				fmt.Printf("HAD ERR: %v\n", err)
+				// Put the formula back so the remote() references are resolved again next time.
+				frml.Expr = oldexpr
 				return fmt.Errorf("formula execution error: [%s]", err.Error())
 			}
 			frml.Expr = oldexpr
@@ -78,6 +78,7 @@ func PluginUpdateTarget(db common.ODb, args *common.Target, name string) (common
 				msg = err.Error()
 			}
 			log.Printf("UPDATE: %s\n", exp.Name)
+			common.PublishEvent(common.Event{Type: common.EventPluginRun, Name: exp.Name, Msg: msg, Target: args})
 			break
 		}
 	}
@@ -0,0 +1,295 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Node Identity
+
+  Headings are addressed by a hash that the parser assigns while the server runs.
+  That hash changes whenever a file is reloaded or the server restarts, so orgs
+  keeps a small fingerprint index alongside it. Every hash the server hands out is
+  remembered along with:
+
+  - The =:ID:= property of the heading, if it has one.
+  - A fingerprint of the file and outline path of the heading.
+  - A fingerprint of the body of the heading.
+
+  When a client asks for a hash that no longer exists the index is used to find the
+  heading it used to refer to, trying the =:ID:= first, then the outline path and
+  finally the body. This means renamed headings, and headings that were refiled to
+  another file, can still be found as long as one of those stays put. A path or
+  body shared by more than one heading is ambiguous and is not used.
+
+  The index is saved to =node_identity.json= in the orgs home directory so hashes
+  survive a restart. Entries that have not been seen for 30 days are dropped.
+EDOC */
+
+import (
+	"crypto/sha1"
+	b64 "encoding/base64"
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// How long a hash we have not seen is kept around for.
+const identityExpiry = 30 * 24 * time.Hour
+
+type NodeIdentity struct {
+	Id       string    `json:"id,omitempty"`
+	Path     string    `json:"path"`
+	Content  string    `json:"content,omitempty"`
+	LastSeen time.Time `json:"seen"`
+}
+
+type IdentityIndex struct {
+	Hashes map[string]*NodeIdentity
+
+	lock   sync.Mutex
+	loaded bool
+	dirty  bool
+}
+
+func NewIdentityIndex() *IdentityIndex {
+	return &IdentityIndex{Hashes: map[string]*NodeIdentity{}}
+}
+
+func GetIdentityPath() string {
+	return path.Join(Conf().PlugManager.HomeDir, "node_identity.json")
+}
+
+func fingerprint(parts ...string) string {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return b64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// The body of a heading without its child headings. Empty bodies are
+// too common to tell headings apart so they yield no fingerprint.
+func sectionBody(sec *org.Section) string {
+	if sec == nil || sec.Headline == nil {
+		return ""
+	}
+	nodes := sec.Headline.Children
+	for i, n := range sec.Headline.Children {
+		if _, ok := n.(org.Headline); ok {
+			nodes = sec.Headline.Children[0:i]
+			break
+		}
+	}
+	w := org.NewOrgWriter()
+	org.WriteNodes(w, nodes...)
+	return w.String()
+}
+
+func SectionIdentity(sec *org.Section, f *common.OrgFile) *NodeIdentity {
+	id := &NodeIdentity{LastSeen: time.Now()}
+	id.Id = GetProp(sec, "ID", "Id", "id")
+	id.Path = fingerprint(f.Filename, common.BuildOutlinePath(sec, "::"))
+	if body := sectionBody(sec); body != "" {
+		id.Content = fingerprint(body)
+	}
+	return id
+}
+
+func (self *IdentityIndex) load() {
+	if self.loaded {
+		return
+	}
+	self.loaded = true
+	if data, err := os.ReadFile(GetIdentityPath()); err == nil {
+		hashes := map[string]*NodeIdentity{}
+		if err := json.Unmarshal(data, &hashes); err == nil {
+			for k, v := range hashes {
+				if _, ok := self.Hashes[k]; !ok {
+					self.Hashes[k] = v
+				}
+			}
+		} else {
+			Log().Errorf("identity: failed to parse %s: %v", GetIdentityPath(), err)
+		}
+	}
+}
+
+func (self *IdentityIndex) Remember(hash string, id *NodeIdentity) {
+	if hash == "" {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.load()
+	self.Hashes[hash] = id
+	self.dirty = true
+}
+
+func (self *IdentityIndex) Get(hash string) *NodeIdentity {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.load()
+	return self.Hashes[hash]
+}
+
+func (self *IdentityIndex) Save() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.dirty {
+		return
+	}
+	self.load()
+	cutoff := time.Now().Add(-identityExpiry)
+	for k, v := range self.Hashes {
+		if v.LastSeen.Before(cutoff) {
+			delete(self.Hashes, k)
+		}
+	}
+	if data, err := json.Marshal(self.Hashes); err == nil {
+		if err := os.WriteFile(GetIdentityPath(), data, 0644); err != nil {
+			Log().Errorf("identity: failed to save %s: %v", GetIdentityPath(), err)
+			return
+		}
+		self.dirty = false
+	}
+}
+
+// The keys a section was registered under.
+type sectionKeys struct {
+	Hash    string
+	Path    string
+	Content string
+}
+
+func addCandidate(m map[string][]*org.Section, key string, v *org.Section) {
+	for _, c := range m[key] {
+		if c == v {
+			return
+		}
+	}
+	m[key] = append(m[key], v)
+}
+
+func removeCandidate(m map[string][]*org.Section, key string, v *org.Section) {
+	list := m[key]
+	for i, c := range list {
+		if c == v {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = list
+	}
+}
+
+// The one section registered under a key, nil when there is none or
+// more than one and the key is ambiguous.
+func uniqueCandidate(m map[string][]*org.Section, key string) *org.Section {
+	if list := m[key]; len(list) == 1 {
+		return list[0]
+	}
+	return nil
+}
+
+// Register a freshly parsed section. The caller holds the db lock.
+func (self *OrgDb) registerIdentity(v *org.Section, f *common.OrgFile) {
+	self.ByHash[v.Hash] = v
+	self.ByHashToFile[v.Hash] = f
+	if id := GetProp(v, "ID", "Id", "id"); id != "" {
+		self.ById[id] = v
+	}
+	if cid := GetProp(v, "CUSTOM_ID", "custom_id", "Custom_Id"); cid != "" {
+		self.ByCustomId[cid] = v
+	}
+	ident := SectionIdentity(v, f)
+	addCandidate(self.ByPath, ident.Path, v)
+	if ident.Content != "" {
+		addCandidate(self.ByContent, ident.Content, v)
+	}
+	self.fileSections[f.Filename] = append(self.fileSections[f.Filename], sectionKeys{Hash: v.Hash, Path: ident.Path, Content: ident.Content})
+	self.Identities.Remember(v.Hash, ident)
+}
+
+// Drop everything we know about the sections of a file that is about to
+// be replaced. Their identities stay in the index so the old hashes can
+// be redirected to the new sections once the file is parsed again.
+// The caller holds the db lock.
+func (self *OrgDb) forgetFileSections(filename string) {
+	for _, keys := range self.fileSections[filename] {
+		sec := self.ByHash[keys.Hash]
+		if f := self.ByHashToFile[keys.Hash]; f != nil && f.Filename != filename {
+			// The hash has since been registered by another file.
+			continue
+		}
+		delete(self.ByHash, keys.Hash)
+		delete(self.ByHashToFile, keys.Hash)
+		if sec == nil {
+			continue
+		}
+		if keys.Path != "" {
+			removeCandidate(self.ByPath, keys.Path, sec)
+		}
+		if keys.Content != "" {
+			removeCandidate(self.ByContent, keys.Content, sec)
+		}
+		if id := GetProp(sec, "ID", "Id", "id"); id != "" && self.ById[id] == sec {
+			delete(self.ById, id)
+		}
+		if cid := GetProp(sec, "CUSTOM_ID", "custom_id", "Custom_Id"); cid != "" && self.ByCustomId[cid] == sec {
+			delete(self.ByCustomId, cid)
+		}
+	}
+	delete(self.fileSections, filename)
+}
+
+// Map a hash that may have gone stale onto the section it refers to now.
+// The caller holds the db read lock.
+func (self *OrgDb) redirectHash(hash string) *org.Section {
+	ident := self.Identities.Get(hash)
+	if ident == nil {
+		return nil
+	}
+	if ident.Id != "" {
+		if v, ok := self.ById[ident.Id]; ok {
+			return v
+		}
+	}
+	if v := uniqueCandidate(self.ByPath, ident.Path); v != nil {
+		return v
+	}
+	if ident.Content != "" {
+		if v := uniqueCandidate(self.ByContent, ident.Content); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// Find a section and its file by hash, following redirects for hashes
+// from an earlier reload or an earlier run of the server.
+func (self *OrgDb) LookupHash(hash string) (*org.Section, *common.OrgFile) {
+	self.dblock.RLock()
+	defer self.dblock.RUnlock()
+	if v, ok := self.ByHash[hash]; ok {
+		return v, self.ByHashToFile[hash]
+	}
+	if v := self.redirectHash(hash); v != nil {
+		return v, self.ByHashToFile[v.Hash]
+	}
+	return nil, nil
+}
+
+// The hash a possibly stale hash refers to now, empty if it is gone.
+func (self *OrgDb) ResolveHash(hash string) string {
+	if v, _ := self.LookupHash(hash); v != nil {
+		return v.Hash
+	}
+	return ""
+}
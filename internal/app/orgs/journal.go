@@ -0,0 +1,488 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Undo and Redo
+
+  Every request that changes an org file is recorded in a journal with a
+  copy of each file as it was before and after the change. The journal is
+  kept per user in the =journal= directory next to the server settings, one
+  file per change, so it survives a restart.
+
+  =oc undo= (or =/api/undo=) puts the files touched by your last change back
+  the way they were, =oc undo -redo= (or =/api/redo=) applies it again and
+  =oc undo -list= shows what can be undone. Any new change clears the redo
+  list.
+
+  Undo only ever works on your own changes, and only on files you can still
+  write. If a file was changed again since, by you, someone else or an
+  external editor, the undo is refused rather than throwing that change away.
+  Changes the server makes on its own, like clocking you out when idle or
+  recalculating a table after the file changed on disk, are not recorded.
+
+  The number of changes kept per user is set with journalSize.
+EDOC */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// A file as it was before and after an operation.
+type journalFile struct {
+	Filename string `json:"filename"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	Existed  bool   `json:"existed"` // false when the operation created the file
+	Exists   bool   `json:"exists"`  // false when the operation removed the file
+	// Someone else wrote the file while the operation was running,
+	// putting Before back would throw their change away.
+	Interleaved bool `json:"interleaved,omitempty"`
+}
+
+type journalOp struct {
+	Id    int            `json:"id"`
+	Op    string         `json:"op"`
+	Time  time.Time      `json:"time"`
+	Files []*journalFile `json:"files"`
+}
+
+func (self *journalOp) summary(undone bool) common.JournalEntry {
+	e := common.JournalEntry{Id: self.Id, Op: self.Op, Time: self.Time, Undone: undone}
+	for _, f := range self.Files {
+		e.Files = append(e.Files, f.Filename)
+	}
+	return e
+}
+
+func (self *journalOp) file(key string) *journalFile {
+	for _, f := range self.Files {
+		if f.Filename == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// What is kept in the index, the operations themselves each have their own file.
+type journalIndex struct {
+	NextId int   `json:"nextId"`
+	Undo   []int `json:"undo"`
+	Redo   []int `json:"redo"`
+}
+
+type userJournal struct {
+	NextId int
+	Undo   []*journalOp
+	Redo   []*journalOp
+	step   sync.Mutex // held for the whole of an undo or redo
+}
+
+// An operation being recorded and who it belongs to. It travels in the
+// context of the request, writes made without it are not recorded.
+type activeOp struct {
+	op       *journalOp
+	username string
+	// The revision each file was checked against by If-Match, by journalKey.
+	expect map[string]string
+	mu     sync.Mutex // guards op and expect, a request may write from more than one goroutine
+}
+
+const contextKeyJournal contextKey = "journal"
+
+// The operation a context is recording into, nil for writes the server
+// makes on its own.
+func journalOpFrom(ctx context.Context) *activeOp {
+	if ctx == nil {
+		return nil
+	}
+	a, _ := ctx.Value(contextKeyJournal).(*activeOp)
+	return a
+}
+
+// Remember the revision a request checked a file against. Its first write
+// to the file is refused if the file no longer has that revision.
+func expectRevision(ctx context.Context, filename string, rev string) {
+	a := journalOpFrom(ctx)
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.expect == nil {
+		a.expect = map[string]string{}
+	}
+	a.expect[journalKey(filename)] = rev
+}
+
+// Journal records the changes made to org files so they can be undone.
+type Journal struct {
+	users map[string]*userJournal
+	files map[string]*sync.Mutex
+	mu    sync.Mutex // guards users and files
+}
+
+func GetJournalPath() string {
+	return path.Join(Conf().PlugManager.HomeDir, "journal")
+}
+
+func journalUserPath(username string) string {
+	if username == "" {
+		username = "_"
+	}
+	return path.Join(GetJournalPath(), url.PathEscape(username))
+}
+
+func journalIndexFilename(username string) string {
+	return path.Join(journalUserPath(username), "index.json")
+}
+
+func journalOpFilename(username string, id int) string {
+	return path.Join(journalUserPath(username), strconv.Itoa(id)+".json")
+}
+
+func journalKey(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filepath.Clean(filename)
+}
+
+// The content of a file and whether it exists.
+func readSnapshot(filename string) (string, bool) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// The lock for a file, writes to a file and undoing them are serialized,
+// different files can be changed at the same time.
+func (self *Journal) fileLock(key string) *sync.Mutex {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	l, ok := self.files[key]
+	if !ok {
+		l = &sync.Mutex{}
+		self.files[key] = l
+	}
+	return l
+}
+
+func loadJournalOp(username string, id int) *journalOp {
+	data, err := os.ReadFile(journalOpFilename(username, id))
+	if err != nil {
+		Log().Errorf("journal: failed to read %s: %v", journalOpFilename(username, id), err)
+		return nil
+	}
+	op := &journalOp{}
+	if err := json.Unmarshal(data, op); err != nil {
+		Log().Errorf("journal: failed to read %s: %v", journalOpFilename(username, id), err)
+		return nil
+	}
+	return op
+}
+
+// The journal for a user, loaded from disk the first time. Caller holds mu.
+func (self *Journal) user(username string) *userJournal {
+	if uj, ok := self.users[username]; ok {
+		return uj
+	}
+	uj := &userJournal{}
+	if data, err := os.ReadFile(journalIndexFilename(username)); err == nil {
+		idx := journalIndex{}
+		if err := json.Unmarshal(data, &idx); err != nil {
+			Log().Errorf("journal: failed to read %s: %v", journalIndexFilename(username), err)
+		}
+		uj.NextId = idx.NextId
+		for _, id := range idx.Undo {
+			if op := loadJournalOp(username, id); op != nil {
+				uj.Undo = append(uj.Undo, op)
+			}
+		}
+		for _, id := range idx.Redo {
+			if op := loadJournalOp(username, id); op != nil {
+				uj.Redo = append(uj.Redo, op)
+			}
+		}
+	}
+	self.users[username] = uj
+	return uj
+}
+
+func saveJournalFile(filename string, v interface{}) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		Log().Errorf("journal: failed to create %s: %v", filepath.Dir(filename), err)
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = atomicWriteFile(filename, data, 0600)
+	}
+	if err != nil {
+		Log().Errorf("journal: failed to save %s: %v", filename, err)
+	}
+}
+
+// Only the index is rewritten, operations are written once when they are
+// recorded. Caller holds mu.
+func (self *Journal) saveIndex(username string) {
+	uj := self.user(username)
+	idx := journalIndex{NextId: uj.NextId, Undo: []int{}, Redo: []int{}}
+	for _, op := range uj.Undo {
+		idx.Undo = append(idx.Undo, op.Id)
+	}
+	for _, op := range uj.Redo {
+		idx.Redo = append(idx.Redo, op.Id)
+	}
+	saveJournalFile(journalIndexFilename(username), idx)
+}
+
+func removeJournalOps(username string, ops []*journalOp) {
+	for _, op := range ops {
+		if err := os.Remove(journalOpFilename(username, op.Id)); err != nil && !os.IsNotExist(err) {
+			Log().Errorf("journal: failed to remove %s: %v", journalOpFilename(username, op.Id), err)
+		}
+	}
+}
+
+// Start recording an operation, writes passed the returned context are
+// recorded into it.
+func (self *Journal) Begin(ctx context.Context, username string, op string) context.Context {
+	return context.WithValue(ctx, contextKeyJournal, &activeOp{op: &journalOp{Op: op, Time: time.Now()}, username: username})
+}
+
+// Finish recording, the operation is kept if it changed any files.
+func (self *Journal) End(ctx context.Context) {
+	a := journalOpFrom(ctx)
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	op := a.op
+	a.op = nil
+	a.mu.Unlock()
+	if op == nil {
+		return
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	files := []*journalFile{}
+	for _, f := range op.Files {
+		if f.Exists != f.Existed || f.After != f.Before {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+	op.Files = files
+	uj := self.user(a.username)
+	uj.NextId++
+	op.Id = uj.NextId
+	saveJournalFile(journalOpFilename(a.username, op.Id), op)
+	uj.Undo = append(uj.Undo, op)
+	if max := Conf().JournalSize; max > 0 && len(uj.Undo) > max {
+		removeJournalOps(a.username, uj.Undo[:len(uj.Undo)-max])
+		uj.Undo = uj.Undo[len(uj.Undo)-max:]
+	}
+	removeJournalOps(a.username, uj.Redo)
+	uj.Redo = nil
+	self.saveIndex(a.username)
+}
+
+// Write a file, keeping a copy of it as it was before the operation in
+// ctx first touched it and as the operation left it.
+func (self *Journal) write(ctx context.Context, filename string, data []byte, perm fs.FileMode) error {
+	key := journalKey(filename)
+	l := self.fileLock(key)
+	l.Lock()
+	defer l.Unlock()
+	a := journalOpFrom(ctx)
+	if a == nil {
+		return atomicWriteFile(filename, data, perm)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	op := a.op
+	if op == nil {
+		// The request has already finished.
+		return atomicWriteFile(filename, data, perm)
+	}
+	f := op.file(key)
+	if f == nil {
+		before, existed := readSnapshot(key)
+		// Someone may have written the file since the request checked it.
+		if rev, ok := a.expect[key]; ok && (!existed || contentRevision([]byte(before)) != rev) {
+			return fmt.Errorf("%s has changed, reload it and try again", filename)
+		}
+		f = &journalFile{Filename: key, Before: before, Existed: existed}
+		f.After, f.Exists = f.Before, f.Existed
+		op.Files = append(op.Files, f)
+	} else if cur, ok := readSnapshot(key); ok != f.Exists || cur != f.After {
+		f.Interleaved = true
+	}
+	if err := atomicWriteFile(filename, data, perm); err != nil {
+		return err
+	}
+	f.After, f.Exists = string(data), true
+	return nil
+}
+
+// Put the files back to one side of an operation. Nothing is written unless
+// the user can still write every file and every file still matches the other side.
+func (self *Journal) applyJournalOp(username string, op *journalOp, undo bool) error {
+	keys := []string{}
+	for _, f := range op.Files {
+		keys = append(keys, f.Filename)
+	}
+	// Always taken in the same order so two undos cannot deadlock.
+	sort.Strings(keys)
+	for _, k := range keys {
+		l := self.fileLock(k)
+		l.Lock()
+		defer l.Unlock()
+	}
+	for _, f := range op.Files {
+		if !CanWriteFile(username, f.Filename) {
+			return fmt.Errorf("you can no longer write %s", f.Filename)
+		}
+		if undo && f.Interleaved {
+			return fmt.Errorf("%s was also changed by someone else at the time, not touching it", f.Filename)
+		}
+		want, exists := f.After, f.Exists
+		if !undo {
+			want, exists = f.Before, f.Existed
+		}
+		cur, ok := readSnapshot(f.Filename)
+		if ok != exists || cur != want {
+			return fmt.Errorf("%s has changed since, not touching it", f.Filename)
+		}
+	}
+	for _, f := range op.Files {
+		data, exists := f.Before, f.Existed
+		if !undo {
+			data, exists = f.After, f.Exists
+		}
+		if !exists {
+			if err := os.Remove(f.Filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := atomicWriteFile(f.Filename, []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Journal) step(username string, undo bool) (common.ResultMsg, error) {
+	// The user's step lock keeps two undos from taking the same operation,
+	// mu is let go while the files are written and reloaded.
+	self.mu.Lock()
+	uj := self.user(username)
+	self.mu.Unlock()
+	uj.step.Lock()
+	defer uj.step.Unlock()
+	self.mu.Lock()
+	from, what := &uj.Undo, "undo"
+	to := &uj.Redo
+	if !undo {
+		from, what = &uj.Redo, "redo"
+		to = &uj.Undo
+	}
+	if len(*from) == 0 {
+		self.mu.Unlock()
+		err := fmt.Errorf("nothing to %s", what)
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	op := (*from)[len(*from)-1]
+	self.mu.Unlock()
+	if err := self.applyJournalOp(username, op, undo); err != nil {
+		err = fmt.Errorf("cannot %s %s: %v", what, op.Op, err)
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	for _, f := range op.Files {
+		GetDb().ReloadFile(f.Filename)
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	// A new operation may have cleared the list while the files were written.
+	if i := len(*from) - 1; i >= 0 && (*from)[i] == op {
+		*from = (*from)[:i]
+	} else {
+		saveJournalFile(journalOpFilename(username, op.Id), op)
+	}
+	*to = append(*to, op)
+	self.saveIndex(username)
+	return common.ResultMsg{Ok: true, Msg: fmt.Sprintf("%s %s", what, op.Op)}, nil
+}
+
+func (self *Journal) Undo(username string) (common.ResultMsg, error) {
+	return self.step(username, true)
+}
+
+func (self *Journal) Redo(username string) (common.ResultMsg, error) {
+	return self.step(username, false)
+}
+
+// The operations a user can undo, newest first, followed by the ones they can redo.
+func (self *Journal) List(username string) []common.JournalEntry {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	uj := self.user(username)
+	res := []common.JournalEntry{}
+	for i := len(uj.Undo) - 1; i >= 0; i-- {
+		res = append(res, uj.Undo[i].summary(false))
+	}
+	for i := len(uj.Redo) - 1; i >= 0; i-- {
+		res = append(res, uj.Redo[i].summary(true))
+	}
+	return res
+}
+
+var orgJournal *Journal = nil
+var orgJournalOnce sync.Once
+
+func GetJournal() *Journal {
+	orgJournalOnce.Do(func() {
+		orgJournal = &Journal{users: map[string]*userJournal{}, files: map[string]*sync.Mutex{}}
+	})
+	return orgJournal
+}
+
+// All writes to org files go through here so they can be undone. Only
+// writes made with the context of a request are recorded.
+func writeOrgFile(ctx context.Context, filename string, data []byte, perm fs.FileMode) error {
+	return GetJournal().write(ctx, filename, data, perm)
+}
+
+// Record every request that can change a file in the journal.
+func journalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		case r.URL.Path == "/undo" || r.URL.Path == "/redo":
+		default:
+			ctx := GetJournal().Begin(r.Context(), GetUsername(r), r.Method+" "+r.URL.Path)
+			defer GetJournal().End(ctx)
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
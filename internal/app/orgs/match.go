@@ -1,269 +1,610 @@
-// https://github.com/bzick/tokenizer.git
 // https://orgmode.org/manual/Matching-tags-and-properties.html
+//
+//lint:file-ignore ST1006 allow the use of self
 package orgs
 
+/* SDOC: Editing
+* Match Expressions
+
+  Blocks that take a =:match= parameter (like the clock table) accept the
+  org mode tags and property match syntax.
+
+  - =work= has the work tag, inherited and file tags count.
+  - =+work-boss= has work but not boss.
+  - =work&boss= or =work boss= has both.
+  - =work|laptop= has either, =(work|home)+urgent= groups terms.
+  - ={^proj}= has a tag matching the regular expression.
+  - =PRIORITY="A"= compares a property to a string.
+  - =TODO={NEXT}= matches a property against a regular expression.
+  - =LEVEL>1= is a numeric comparison, =Effort<2:00= compares durations.
+  - =SCHEDULED<="<today>"= compares dates.
+  - =work/NEXT|WAIT= limits a match to some TODO states, =work/!-WAIT= to
+    active TODO states other than WAIT.
+
+  The comparison operators are = (or ==), <> (or !=), <, <=, > and >=.
+
+  Dates are written in quotes and angle brackets. Besides plain org timestamps
+  =<today>=, =<tomorrow>=, =<yesterday>=, =<now>= and offsets from now like
+  =<-3d>= or =<+2w>= (units h, d, w, m and y) are understood. Dates without a
+  time are compared by day.
+
+  Besides the headings own properties these special properties can be used:
+  TODO, LEVEL, ITEM, CATEGORY, PRIORITY, SCHEDULED, DEADLINE, CLOSED,
+  TIMESTAMP, TAGS, ALLTAGS and FILE.
+
+  A heading that does not have a property only matches <> and != comparisons
+  against it, whatever the kind of value, so =PRIORITY<"B"= skips headings
+  without a priority and =TODO<>"DONE"= includes headings without a state.
+EDOC */
+
 import (
+	"fmt"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/bzick/tokenizer"
 	"github.com/ihdavids/go-org/org"
 	"github.com/ihdavids/orgs/internal/common"
 )
 
-// define custom tokens keys
-const (
-	TEquality = 1
-	TInclude  = 2
-	TExclude  = 3
-	TString   = 4
-	TAnd      = 5
-	TOr       = 6
-	TAssign   = 7
-	TRegex    = 8
-)
-
 type MatchExpr struct {
-	Stream *tokenizer.Stream
-	Parser *tokenizer.Tokenizer
-	Expr   string
+	Expr string
+	// Set when the expression could not be parsed, a broken
+	// expression never matches anything.
+	Err  error
+	root matchNode
 }
 
-func (s *MatchExpr) Valid() bool {
-	return s.Stream.IsValid()
+type matchNode interface {
+	eval(ofile *common.OrgFile, sec *org.Section) bool
 }
 
-func (s *MatchExpr) CurIs(key tokenizer.TokenKey) bool {
-	return s.Stream.CurrentToken().Is(key)
+type matchAnd []matchNode
+type matchOr []matchNode
+
+type matchNot struct {
+	node matchNode
 }
 
-func (s *MatchExpr) NextIs(key tokenizer.TokenKey) bool {
-	return s.Stream.NextToken().Is(key)
+type matchTag struct {
+	name string
+	re   *regexp.Regexp
 }
 
-func (s *MatchExpr) Next() {
-	s.Stream.GoNext()
+type matchProp struct {
+	name  string
+	op    string
+	value *matchValue
 }
 
-func (s *MatchExpr) Cur() *tokenizer.Token {
-	return s.Stream.CurrentToken()
+// Limits a match to headings in a TODO state, the todo part
+// of an expression after the slash.
+type matchTodo struct {
+	node       matchNode
+	activeOnly bool
 }
 
-func (s *MatchExpr) PeekNext() *tokenizer.Token {
-	return s.Stream.NextToken()
+const (
+	matchKindString = iota
+	matchKindRegex
+	matchKindNumber
+	matchKindDuration
+	matchKindDate
+)
+
+type matchValue struct {
+	kind int
+	str  string
+	re   *regexp.Regexp
+	num  float64
+	date *matchDate
 }
 
-func opAnd(ok bool, res bool) bool {
-	return ok && res
+// A date from the right hand side of a comparison. Relative
+// dates are resolved when the expression is evaluated.
+type matchDate struct {
+	date     *org.OrgDate
+	relative string
+	offset   int
+	unit     byte
+	haveTime bool
 }
 
-func opOr(ok bool, res bool) bool {
-	return ok || res
+var matchOps = []string{"<>", "!=", "==", "<=", ">=", "=", "<", ">"}
+
+func isMatchWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '@' || c == '#' || c == '%'
 }
 
-func opInclude(v bool) bool {
-	return v
+type matchParser struct {
+	expr string
+	pos  int
+	todo bool
 }
 
-func opExclude(v bool) bool {
-	return !v
+func (self *matchParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("match: %s at column %d of %q", fmt.Sprintf(format, args...), self.pos+1, self.expr)
 }
 
-type NegateFunc func(bool) bool
+func (self *matchParser) skipSpace() {
+	for self.pos < len(self.expr) && (self.expr[self.pos] == ' ' || self.expr[self.pos] == '\t') {
+		self.pos++
+	}
+}
 
-type ResStack struct {
-	s     []bool
-	ok    bool
-	op    int
-	opInc NegateFunc
+func (self *matchParser) peek() byte {
+	self.skipSpace()
+	if self.pos < len(self.expr) {
+		return self.expr[self.pos]
+	}
+	return 0
 }
 
-// IsEmpty: check if stack is empty
-func (s *ResStack) IsEmpty() bool {
-	return len(s.s) == 0
+func (self *matchParser) word() string {
+	start := self.pos
+	for self.pos < len(self.expr) && isMatchWordChar(self.expr[self.pos]) {
+		self.pos++
+	}
+	return self.expr[start:self.pos]
 }
 
-// Push a new value onto the stack
-func (s *ResStack) Push(v bool) {
-	s.s = append(s.s, v) // Simply append the new value to the end of the stack
+// Reads a delimited value, honouring backslash escapes of the closing delimiter.
+func (self *matchParser) delimited(open, close byte) (string, error) {
+	start := self.pos
+	self.pos++
+	var sb strings.Builder
+	for self.pos < len(self.expr) {
+		c := self.expr[self.pos]
+		if c == '\\' && self.pos+1 < len(self.expr) && self.expr[self.pos+1] == close {
+			sb.WriteByte(close)
+			self.pos += 2
+			continue
+		}
+		if c == close {
+			self.pos++
+			return sb.String(), nil
+		}
+		sb.WriteByte(c)
+		self.pos++
+	}
+	self.pos = start
+	return "", self.errorf("unterminated %c", open)
 }
 
-// Remove and return top element of stack. Return false if stack is empty.
-func (s *ResStack) Pop() bool {
-	if s.IsEmpty() {
-		return false
-	} else {
-		index := len(s.s) - 1   // Get the index of the top most element.
-		element := (s.s)[index] // Index into the slice and obtain the element.
-		s.s = (s.s)[:index]     // Remove it from the stack by slicing it off.
-		return element
+// or := and { '|' and }
+func (self *matchParser) parseOr() (matchNode, error) {
+	var terms matchOr
+	for {
+		n, err := self.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+		if self.peek() != '|' {
+			break
+		}
+		self.pos++
 	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
 }
 
-func (s *ResStack) Op(val bool) {
-	if s.op == TOr {
-		s.Push(s.ok)
-		s.ok = val
-	} else {
-		s.ok = s.ok && val
+// and := [+-] atom { ['&'] [+-] atom }
+func (self *matchParser) parseAnd() (matchNode, error) {
+	var terms matchAnd
+	for {
+		c := self.peek()
+		if c == 0 || c == '|' || c == ')' || (c == '/' && !self.todo) {
+			break
+		}
+		if c == '&' {
+			self.pos++
+			continue
+		}
+		negate := false
+		if c == '+' || c == '-' {
+			negate = c == '-'
+			self.pos++
+		}
+		n, err := self.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			n = &matchNot{n}
+		}
+		terms = append(terms, n)
+	}
+	if len(terms) == 0 {
+		return nil, self.errorf("expected a tag or property")
 	}
-	s.op = TOr
-	s.opInc = opInclude
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
 }
 
-func (s *ResStack) SetOp(val int) {
-	s.op = val
+// atom := '(' or ')' | '{' regex '}' | word [ op value ]
+func (self *matchParser) parseAtom() (matchNode, error) {
+	c := self.peek()
+	switch {
+	case c == '(':
+		self.pos++
+		n, err := self.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if self.peek() != ')' {
+			return nil, self.errorf("expected )")
+		}
+		self.pos++
+		return n, nil
+	case c == '{':
+		str, err := self.delimited('{', '}')
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(str)
+		if err != nil {
+			return nil, self.errorf("bad regular expression {%s}: %v", str, err)
+		}
+		if self.todo {
+			return &matchProp{name: "TODO", op: "=", value: &matchValue{kind: matchKindRegex, str: str, re: re}}, nil
+		}
+		return &matchTag{name: str, re: re}, nil
+	case isMatchWordChar(c):
+		name := self.word()
+		if self.todo {
+			return &matchProp{name: "TODO", op: "=", value: &matchValue{kind: matchKindString, str: name}}, nil
+		}
+		for _, op := range matchOps {
+			if strings.HasPrefix(self.expr[self.pos:], op) {
+				self.pos += len(op)
+				value, err := self.parseValue(op)
+				if err != nil {
+					return nil, err
+				}
+				return &matchProp{name: strings.ToUpper(name), op: op, value: value}, nil
+			}
+		}
+		return &matchTag{name: name}, nil
+	case c == 0:
+		return nil, self.errorf("unexpected end of expression")
+	}
+	return nil, self.errorf("unexpected %q", c)
 }
 
-func (s *ResStack) SetNegate(f NegateFunc) {
-	s.opInc = f
+func (self *matchParser) parseValue(op string) (*matchValue, error) {
+	c := self.peek()
+	switch {
+	case c == '"':
+		str, err := self.delimited('"', '"')
+		if err != nil {
+			return nil, err
+		}
+		if len(str) > 1 && ((str[0] == '<' && str[len(str)-1] == '>') || (str[0] == '[' && str[len(str)-1] == ']')) {
+			date, err := parseMatchDate(str)
+			if err != nil {
+				return nil, self.errorf("%v", err)
+			}
+			return &matchValue{kind: matchKindDate, str: str, date: date}, nil
+		}
+		return &matchValue{kind: matchKindString, str: str}, nil
+	case c == '{':
+		if op != "=" && op != "==" && op != "<>" && op != "!=" {
+			return nil, self.errorf("regular expressions only work with = and <>")
+		}
+		str, err := self.delimited('{', '}')
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(str)
+		if err != nil {
+			return nil, self.errorf("bad regular expression {%s}: %v", str, err)
+		}
+		return &matchValue{kind: matchKindRegex, str: str, re: re}, nil
+	}
+	start := self.pos
+	for self.pos < len(self.expr) && strings.IndexByte("0123456789.:-+", self.expr[self.pos]) >= 0 {
+		self.pos++
+	}
+	str := self.expr[start:self.pos]
+	if str == "" {
+		return nil, self.errorf("expected a value after %s", op)
+	}
+	if strings.Contains(str, ":") {
+		if d := common.ParseDuration(str); d != nil {
+			return &matchValue{kind: matchKindDuration, str: str, num: d.Mins}, nil
+		}
+	} else if n, err := strconv.ParseFloat(str, 64); err == nil {
+		return &matchValue{kind: matchKindNumber, str: str, num: n}, nil
+	}
+	self.pos = start
+	return nil, self.errorf("bad value %q", str)
+}
+
+func parseMatchDate(str string) (*matchDate, error) {
+	inner := strings.TrimSpace(str[1 : len(str)-1])
+	switch inner {
+	case "today", "tomorrow", "yesterday":
+		return &matchDate{relative: inner}, nil
+	case "now":
+		return &matchDate{relative: inner, haveTime: true}, nil
+	}
+	if n, unit, ok := parseOffset(inner); ok {
+		return &matchDate{relative: "offset", offset: n, unit: unit, haveTime: unit == 'h'}, nil
+	}
+	if date, _, _ := org.ParseTimestamp(str); date != nil {
+		return &matchDate{date: date, haveTime: date.HaveTime}, nil
+	}
+	return nil, fmt.Errorf("bad date %s", str)
 }
 
-func (s *ResStack) Result() bool {
-	for !s.IsEmpty() {
-		s.ok = s.Pop() || s.ok
+func (self *matchDate) Resolve(now time.Time) time.Time {
+	switch self.relative {
+	case "today":
+		return GetBeginOfDay(now)
+	case "tomorrow":
+		return GetBeginOfDay(now.AddDate(0, 0, 1))
+	case "yesterday":
+		return GetBeginOfDay(now.AddDate(0, 0, -1))
+	case "now":
+		return now
+	case "offset":
+		return OffsetDate(now, self.offset, self.unit)
 	}
-	return s.ok
+	return self.date.Start
 }
 
-func TestEquality(op string, a int, b int) bool {
+func compareOrdered[T ~int | ~int64 | ~float64 | ~string](op string, a, b T) bool {
 	switch op {
-	case "==":
+	case "=", "==":
 		return a == b
-	case "!=":
+	case "<>", "!=":
 		return a != b
-	case "<=":
-		return a <= b
-	case ">=":
-		return a >= b
 	case "<":
 		return a < b
+	case "<=":
+		return a <= b
 	case ">":
 		return a > b
-	default:
-		return false
+	case ">=":
+		return a >= b
 	}
+	return false
 }
 
-func CheckString(test string, tok *tokenizer.Token) bool {
-	if tok.Key() == TString || tok.Key() == tokenizer.TokenString {
-		tvalue := tok.ValueString()
-		return tvalue == test
+func (self *matchNot) eval(ofile *common.OrgFile, sec *org.Section) bool {
+	return !self.node.eval(ofile, sec)
+}
+
+func (self matchAnd) eval(ofile *common.OrgFile, sec *org.Section) bool {
+	for _, n := range self {
+		if !n.eval(ofile, sec) {
+			return false
+		}
 	}
-	if tok.Key() == TRegex {
-		tvalue := tok.ValueString()
-		if ok, err := regexp.MatchString(test, tvalue); err == nil && ok {
+	return true
+}
+
+func (self matchOr) eval(ofile *common.OrgFile, sec *org.Section) bool {
+	for _, n := range self {
+		if n.eval(ofile, sec) {
 			return true
-		} else {
-			// TODO: log an error
 		}
 	}
-	// TODO: Log an error!
 	return false
 }
 
-func (s *MatchExpr) EvalSection(ofile *common.OrgFile, sec *org.Section) bool {
-	res := ResStack{ok: true, op: TAnd}
+func (self *matchTag) eval(ofile *common.OrgFile, sec *org.Section) bool {
+	if self.re != nil {
+		return HasTagRegex(self.name, sec, ofile.Doc)
+	}
+	return HasTag(self.name, sec, ofile.Doc)
+}
 
-	// iterate over each token
-	for s.Valid() {
-		tok := s.Cur()
-		//fmt.Printf("ID: %d\n", tok.ID())
-		switch tok.Key() {
-		case TInclude:
-			res.SetNegate(opInclude)
-			break
-		case TExclude:
-			res.SetNegate(opExclude)
-			break
-		case TAnd:
-			res.SetOp(TAnd)
-			res.SetNegate(opInclude)
-			break
-		case TOr:
-			res.SetOp(TOr)
-			res.SetNegate(opInclude)
-			break
-		case TRegex:
-			// This is a tag check
-			tstr := tok.ValueString()
-			v := HasTagRegex(tstr, sec, ofile.Doc)
-			res.Op(v)
-		case tokenizer.TokenKeyword:
-			tstr := tok.ValueString()
-			if tstr == "SCHEDULED" {
-				// TODO: Implement this!
-
-			} else if tstr == "TODO" && s.PeekNext().Key() == TAssign {
-				s.Next() // Skip equals
-				s.Next() // Skip value check
-				r := CheckString(sec.Headline.Status, s.Cur())
-				res.Op(r)
-			} else if tstr == "LEVEL" {
-				s.Next()
-				op := s.Cur()
-				if op.Key() == TEquality {
-					s.Next()
-					v := s.Cur()
-					if v.IsNumber() {
-						val := int(v.ValueInt64())
-						r := TestEquality(op.ValueString(), sec.Headline.Lvl, val)
-						res.Op(r)
-					} else {
-						// TODO: Error about bad number
-						return false
-					}
-
-				} else {
-					// TODO: Error about bad comparison
-					return false
-				}
-			} else if s.PeekNext().Key() == TAssign { // Property check
-				if sec.Headline.Properties != nil {
-					if v, o := sec.Headline.Properties.Get(tstr); o {
-						s.Next() // Skip equals
-						s.Next() // Skip value check
-						tvalue := s.Cur().ValueString()
-						res.Op(v == tvalue)
-					}
+func (self *matchTodo) eval(ofile *common.OrgFile, sec *org.Section) bool {
+	if sec.Headline == nil || sec.Headline.Status == "" {
+		return false
+	}
+	if self.activeOnly && !IsActive(sec, ofile) {
+		return false
+	}
+	return self.node == nil || self.node.eval(ofile, sec)
+}
+
+func matchCategory(ofile *common.OrgFile, sec *org.Section) string {
+	if cat := GetProp(sec, "CATEGORY"); cat != "" {
+		return cat
+	}
+	if cat := ofile.Doc.Get("CATEGORY"); cat != "" {
+		return cat
+	}
+	return strings.TrimSuffix(filepath.Base(ofile.Filename), filepath.Ext(ofile.Filename))
+}
+
+func sdcDate(sdc *org.SDC) *org.OrgDate {
+	if sdc != nil {
+		return sdc.Date
+	}
+	return nil
+}
+
+// The value of a property as a string, or the date for the
+// special date properties. ok is false if the heading does not have it.
+func matchPropValue(name string, ofile *common.OrgFile, sec *org.Section) (str string, date *org.OrgDate, ok bool) {
+	h := sec.Headline
+	switch name {
+	case "TODO":
+		return h.Status, nil, h.Status != ""
+	case "LEVEL":
+		return strconv.Itoa(h.Lvl), nil, true
+	case "ITEM":
+		return common.GetHeadlineTitle(h), nil, true
+	case "CATEGORY":
+		return matchCategory(ofile, sec), nil, true
+	case "PRIORITY":
+		return h.Priority, nil, h.Priority != ""
+	case "FILE":
+		return ofile.Filename, nil, true
+	case "TAGS":
+		if len(h.Tags) == 0 {
+			return "", nil, false
+		}
+		return ":" + strings.Join(h.Tags, ":") + ":", nil, true
+	case "ALLTAGS":
+		tags := getParentTags(sec, nil)
+		if len(tags) == 0 {
+			return "", nil, false
+		}
+		return ":" + strings.Join(tags, ":") + ":", nil, true
+	case "SCHEDULED":
+		date = sdcDate(h.Scheduled)
+	case "DEADLINE":
+		date = sdcDate(h.Deadline)
+	case "CLOSED":
+		date = sdcDate(h.Closed)
+	case "TIMESTAMP":
+		if h.Timestamp != nil {
+			date = h.Timestamp.Time
+		}
+	default:
+		if h.Properties != nil {
+			for _, p := range h.Properties.Properties {
+				if strings.EqualFold(p[0], name) {
+					return p[1], nil, true
 				}
-			} else { // Must be a tag
-				v := HasTag(tstr, sec, ofile.Doc)
-				res.Op(v)
 			}
-			break
-		default:
-			// TODO ERROR LOG
-			return false
 		}
-		s.Next()
+		return "", nil, false
 	}
-	return res.Result()
+	return "", date, date != nil
 }
 
-func (s *MatchExpr) Reset() {
-	s.Stream = s.Parser.ParseString(s.Expr)
+func (self *matchProp) eval(ofile *common.OrgFile, sec *org.Section) bool {
+	if sec.Headline == nil {
+		return false
+	}
+	str, date, ok := matchPropValue(self.name, ofile, sec)
+	negative := self.op == "<>" || self.op == "!="
+	if !ok {
+		return negative
+	}
+	v := self.value
+	switch v.kind {
+	case matchKindString:
+		return compareOrdered(self.op, str, v.str)
+	case matchKindRegex:
+		return v.re.MatchString(str) != negative
+	case matchKindNumber:
+		n, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return negative
+		}
+		return compareOrdered(self.op, n, v.num)
+	case matchKindDuration:
+		d := common.ParseDuration(str)
+		if d == nil {
+			return negative
+		}
+		return compareOrdered(self.op, d.Mins, v.num)
+	case matchKindDate:
+		if date == nil {
+			if date, _, _ = org.ParseTimestamp(strings.TrimSpace(str)); date == nil {
+				return negative
+			}
+		}
+		a := date.Start
+		b := v.date.Resolve(time.Now())
+		if !date.HaveTime || !v.date.haveTime {
+			a, b = GetBeginOfDay(a), GetBeginOfDay(b)
+		}
+		return compareOrdered(self.op, a.Unix(), b.Unix())
+	}
+	return false
 }
 
-func (s *MatchExpr) Close() {
-	s.Stream.Close()
+// Does the section match the expression. Broken expressions never match,
+// check Err (or use ParseMatchExpr) to find out why.
+func (self *MatchExpr) EvalSection(ofile *common.OrgFile, sec *org.Section) bool {
+	if self.Err != nil || self.root == nil || sec == nil {
+		return false
+	}
+	return self.root.eval(ofile, sec)
 }
 
-func NewMatchExpr(expr string) *MatchExpr {
-	exp := &MatchExpr{}
-	// configure tokenizer
-	parser := tokenizer.New()
-	parser.DefineTokens(TEquality, []string{"<", "<=", "==", ">=", ">", "!="})
-	parser.DefineTokens(TAssign, []string{"="})
-	parser.DefineTokens(TInclude, []string{"+"})
-	parser.DefineTokens(TExclude, []string{"-"})
-	parser.DefineTokens(TAnd, []string{"&"})
-	parser.DefineTokens(TOr, []string{"|"})
-	//parser.DefineTokens(TMath, []string{"+", "-", "/", "*", "%"})
-	parser.DefineStringToken(TString, "{", "}").SetEscapeSymbol(tokenizer.BackSlash)
-	parser.DefineStringToken(TRegex, "\"", "\"").SetEscapeSymbol(tokenizer.BackSlash)
-	exp.Parser = parser
-	exp.Expr = expr
-	// create tokens stream
-	exp.Stream = parser.ParseString(expr)
-	return exp
+// Split off the TODO part of an expression, everything after the
+// first slash that is not inside a string or regular expression.
+func splitMatchExpr(expr string) (string, string, bool) {
+	var close byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case close != 0:
+			if c == '\\' {
+				i++
+			} else if c == close {
+				close = 0
+			}
+		case c == '"':
+			close = '"'
+		case c == '{':
+			close = '}'
+		case c == '/':
+			return expr[:i], strings.TrimLeft(expr[i+1:], "/"), true
+		}
+	}
+	return expr, "", false
+}
+
+func ParseMatchExpr(expr string) (*MatchExpr, error) {
+	exp := &MatchExpr{Expr: expr}
+	tags, todo, hasTodo := splitMatchExpr(expr)
+	var root matchNode
+	if strings.TrimSpace(tags) != "" {
+		p := &matchParser{expr: tags}
+		n, err := p.parseOr()
+		if err == nil && p.peek() != 0 {
+			err = p.errorf("unexpected %q", p.peek())
+		}
+		if err != nil {
+			exp.Err = err
+			return exp, err
+		}
+		root = n
+	}
+	if hasTodo {
+		mt := &matchTodo{}
+		if strings.HasPrefix(todo, "!") {
+			mt.activeOnly = true
+			todo = todo[1:]
+		}
+		if strings.TrimSpace(todo) != "" {
+			p := &matchParser{expr: todo, todo: true}
+			n, err := p.parseOr()
+			if err == nil && p.peek() != 0 {
+				err = p.errorf("unexpected %q", p.peek())
+			}
+			if err != nil {
+				exp.Err = err
+				return exp, err
+			}
+			mt.node = n
+		}
+		if root != nil {
+			root = matchAnd{root, mt}
+		} else {
+			root = mt
+		}
+	}
+	if root == nil {
+		exp.Err = fmt.Errorf("match: empty expression")
+		return exp, exp.Err
+	}
+	exp.root = root
+	return exp, nil
 }
@@ -2,6 +2,8 @@
 package orgs
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
 	b64 "encoding/base64"
 	"fmt"
@@ -34,6 +36,11 @@ type OrgDb struct {
 	ByCustomId   map[string]*org.Section
 	ByHashToFile map[string]*common.OrgFile
 	NamedTables  map[string][]*TableFile
+	TableDeps    *TableGraph
+	ByPath       map[string][]*org.Section
+	ByContent    map[string][]*org.Section
+	Identities   *IdentityIndex
+	Search       *SearchIndex
 	Tags         []string
 	Filenames    []string
 	ReloadIndex  uint64
@@ -41,6 +48,17 @@ type OrgDb struct {
 	dblock      sync.RWMutex
 	watcher     *rfsnotify.RWatcher
 	watcherdone chan bool
+	// Only one table recalculation runs at a time, files written while it
+	// runs are queued in recalcQueue and recalculated after it.
+	recalcMu    sync.Mutex // guards recalcBusy and recalcQueue
+	recalcIdle  *sync.Cond
+	recalcBusy  bool
+	recalcQueue []pendingRecalc
+	// What each file registered, so a rescan only touches its own entries.
+	fileSections map[string][]sectionKeys
+	// The revision each file was last parsed from. Writing a file out
+	// changes its revision but not what was parsed.
+	parsedRevs map[string]string
 }
 
 func NewOrgDb() *OrgDb {
@@ -51,6 +69,14 @@ func NewOrgDb() *OrgDb {
 	db.ById = make(map[string]*org.Section)
 	db.ByCustomId = make(map[string]*org.Section)
 	db.NamedTables = make(map[string][]*TableFile)
+	db.TableDeps = NewTableGraph()
+	db.ByPath = make(map[string][]*org.Section)
+	db.ByContent = make(map[string][]*org.Section)
+	db.fileSections = make(map[string][]sectionKeys)
+	db.parsedRevs = make(map[string]string)
+	db.recalcIdle = sync.NewCond(&db.recalcMu)
+	db.Identities = NewIdentityIndex()
+	db.Search = NewSearchIndex()
 	db.ReloadIndex = 0
 	return db
 }
@@ -94,6 +120,7 @@ func (self *OrgDb) RegisterSection(hash string, v *org.Section, d *common.OrgFil
 	defer self.dblock.Unlock()
 	self.ByHash[hash] = v
 	self.ByHashToFile[hash] = d
+	self.fileSections[d.Filename] = append(self.fileSections[d.Filename], sectionKeys{Hash: hash})
 	id := GetProp(v, "ID", "Id", "id")
 	if id != "" {
 		self.ById[id] = v
@@ -105,11 +132,11 @@ func (self *OrgDb) RegisterSection(hash string, v *org.Section, d *common.OrgFil
 }
 
 func (self *OrgDb) FindByAnyId(hash string) *org.Section {
-	self.dblock.RLock()
-	defer self.dblock.RUnlock()
-	if v, ok := self.ByHash[hash]; ok {
+	if v, _ := self.LookupHash(hash); v != nil {
 		return v
 	}
+	self.dblock.RLock()
+	defer self.dblock.RUnlock()
 	if v, ok := self.ById[hash]; ok {
 		return v
 	}
@@ -120,12 +147,8 @@ func (self *OrgDb) FindByAnyId(hash string) *org.Section {
 }
 
 func (self *OrgDb) FindByHash(hash string) *org.Section {
-	self.dblock.RLock()
-	defer self.dblock.RUnlock()
-	if v, ok := self.ByHash[hash]; ok {
-		return v
-	}
-	return nil
+	v, _ := self.LookupHash(hash)
+	return v
 }
 
 // Returns the next sibling after this node
@@ -249,7 +272,7 @@ func (self *OrgDb) ListFilesInDir(dirname string) []string {
 }
 
 func (self *OrgDb) ScanNode(v *org.Section, f *common.OrgFile) {
-	//self.RegisterSection(v.Hash, v, f)
+	self.registerIdentity(v, f)
 	for _, t := range v.Headline.Tags {
 		if !contains(self.Tags, t) {
 			self.Tags = append(self.Tags, t)
@@ -285,6 +308,7 @@ func (self *OrgDb) CleanupTableRefsForFile(filename string) {
 func (self *OrgDb) ScanFile(f *common.OrgFile) {
 	// Remove old named entries from the list
 	self.CleanupTableRefsForFile(f.Filename)
+	self.forgetFileSections(f.Filename)
 	for _, v := range f.Doc.Outline.Children {
 		self.ScanNode(v, f)
 	}
@@ -298,6 +322,7 @@ func (self *OrgDb) ScanFile(f *common.OrgFile) {
 			}
 		}
 	}
+	self.TableDeps.ScanFile(f)
 }
 
 func (self *OrgDb) GetNamedTable(name string, filename string) *TableFile {
@@ -353,14 +378,16 @@ func (self *OrgDb) LoadFile(filename string, allowOutsideFiles ...bool) {
 		return
 	}
 	Conf().Out.Infof("LOAD FILE: %s\n", filename)
-	if r, err := os.Open(filename); err == nil {
-		d := GetConfig().Parse(r, filename)
-		r.Close()
+	if data, err := os.ReadFile(filename); err == nil {
+		d := GetConfig().Parse(bytes.NewReader(data), filename)
 		ofile := new(common.OrgFile)
 		ofile.Filename = filename
 		ofile.Doc = d
+		ofile.Revision = revisions.remember(filename, data)
 		self.dblock.Lock()
+		_, reloaded := self.ByFile[filename]
 		self.ByFile[filename] = ofile
+		self.parsedRevs[filename] = ofile.Revision
 		// Unique append to our filenames list.
 		// NOTE: Reload, it's important to try to maintain the ordering of this list
 		//       as it can impact the ordering of todos on requery
@@ -375,9 +402,14 @@ func (self *OrgDb) LoadFile(filename string, allowOutsideFiles ...bool) {
 			self.Filenames = append(self.Filenames, filename)
 		}
 		self.ScanFile(ofile)
+		self.Search.IndexFile(ofile)
 		// We increment this with each reload to tell if the DB is dirty or not.
 		self.ReloadIndex += 1
 		self.dblock.Unlock()
+		common.PublishEvent(common.Event{Type: common.EventFileReloaded, Filename: filename})
+		if reloaded {
+			self.autoRecalc(context.Background(), filename)
+		}
 	} else {
 		fmt.Println("****** Failed to parse file {}", filename)
 	}
@@ -385,6 +417,21 @@ func (self *OrgDb) LoadFile(filename string, allowOutsideFiles ...bool) {
 
 func (self *OrgDb) Close() {
 	self.watcher.Close()
+	self.Identities.Save()
+}
+
+// True when the database was parsed from what is on disk for a file.
+// A file the server wrote out itself is not, its positions, hashes,
+// named tables and search entries still come from the old text.
+func (self *OrgDb) isParsed(filename string) bool {
+	self.dblock.RLock()
+	rev, ok := self.parsedRevs[filename]
+	self.dblock.RUnlock()
+	if !ok || rev == "" {
+		return false
+	}
+	data, err := os.ReadFile(filename)
+	return err == nil && contentRevision(data) == rev
 }
 
 func (self *OrgDb) Watch() {
@@ -405,7 +452,12 @@ func (self *OrgDb) Watch() {
 					return
 				}
 				//log.Printf("EVENT %s %s\n", event.Name, event.Op)
+				if self.isParsed(event.Name) {
+					// Already reloaded, nothing to do.
+					continue
+				}
 				self.LoadFile(event.Name)
+				self.Identities.Save()
 			case err, ok := <-self.watcher.Errors:
 				if !ok {
 					return
@@ -439,6 +491,7 @@ func (self *OrgDb) RebuildDb() {
 			self.LoadFile(file)
 		}
 	}
+	self.Identities.Save()
 }
 
 func (self *OrgDb) GetFiles() []string {
@@ -556,11 +609,11 @@ func (self *OrgDb) ReloadFile(fname string) *common.OrgFile {
 	return file
 }
 
-func (self *OrgDb) CreateOrgFile(fname string, title string) *common.OrgFile {
-	return self.CreateOrgFileFromTemplate(fname, title, "")
+func (self *OrgDb) CreateOrgFile(ctx context.Context, fname string, title string) *common.OrgFile {
+	return self.CreateOrgFileFromTemplate(ctx, fname, title, "")
 }
 
-func (self *OrgDb) CreateOrgFileFromTemplate(fname string, title string, templateName string) *common.OrgFile {
+func (self *OrgDb) CreateOrgFileFromTemplate(ctx context.Context, fname string, title string, templateName string) *common.OrgFile {
 	if _, err := os.Stat(fname); err != nil {
 		template := templateName
 		if template == "" {
@@ -580,7 +633,7 @@ func (self *OrgDb) CreateOrgFileFromTemplate(fname string, title string, templat
 		context["author"] = Conf().Author
 		data := Conf().PlugManager.Tempo.RenderTemplate(template, context)
 		fmt.Printf("WRITING ORG FILE %s\n", fname)
-		ioutil.WriteFile(fname, []byte(data), fs.ModePerm)
+		writeOrgFile(ctx, fname, []byte(data), fs.ModePerm)
 		return self.ReloadFile(fname)
 	} else {
 		fmt.Printf("RELOAD ATTEMPT: %s\n", fname)
@@ -601,7 +654,7 @@ func (self *OrgDb) FindNodeByHeadline(file *common.OrgFile, target *common.Targe
 	return node
 }
 
-func (self *OrgDb) AppendNodeToFile(file *common.OrgFile, target *common.Target) (*common.OrgFile, *org.Section) {
+func (self *OrgDb) AppendNodeToFile(ctx context.Context, file *common.OrgFile, target *common.Target) (*common.OrgFile, *org.Section) {
 	sec := new(org.Section)
 	sec.Children = []*org.Section{}
 	row := 0
@@ -619,18 +672,18 @@ func (self *OrgDb) AppendNodeToFile(file *common.OrgFile, target *common.Target)
 	tHash.Write([]byte(title))
 	sec.Hash = b64.StdEncoding.EncodeToString(tHash.Sum(nil))
 	res := common.ResultMsg{}
-	InsertSection(file, sec, file.Doc.Outline.Section, &res)
+	InsertSection(ctx, file, sec, file.Doc.Outline.Section, &res)
 	file = self.ReloadFile(file.Filename)
 	node := self.FindNodeByHeadline(file, target)
 	return file, node
 }
 
 // Find an olp path, if allowed to create recursively add nodes and reload file to make full olp path
-func (self *OrgDb) FindByOlp(target *common.Target, allowCreate bool) (*common.OrgFile, *org.Section) {
+func (self *OrgDb) FindByOlp(ctx context.Context, target *common.Target, allowCreate bool) (*common.OrgFile, *org.Section) {
 	file := self.FindByFile(target.Filename)
 	if file == nil && allowCreate {
 		fname := self.GetFilepath(target.Filename)
-		file = self.CreateOrgFile(fname, "")
+		file = self.CreateOrgFile(ctx, fname, "")
 	}
 	if file == nil {
 		return nil, nil
@@ -646,9 +699,9 @@ func (self *OrgDb) FindByOlp(target *common.Target, allowCreate bool) (*common.O
 		sec = FindChildByName(cur, name)
 		if sec == nil {
 			if allowCreate {
-				self.AppendNodeAtNode(file, target, parent, name)
+				self.AppendNodeAtNode(ctx, file, target, parent, name)
 				// Start over, the file got rewritten!
-				return self.FindByOlp(target, allowCreate)
+				return self.FindByOlp(ctx, target, allowCreate)
 			} else {
 				return nil, nil
 			}
@@ -660,14 +713,15 @@ func (self *OrgDb) FindByOlp(target *common.Target, allowCreate bool) (*common.O
 	return file, sec
 }
 
-func (self *OrgDb) AppendNodeAtNode(file *common.OrgFile, target *common.Target, parent *org.Section, name string) bool {
+func (self *OrgDb) AppendNodeAtNode(ctx context.Context, file *common.OrgFile, target *common.Target, parent *org.Section, name string) bool {
 	sec := new(org.Section)
 	sec.Children = []*org.Section{}
 	row := 0
-	lvl := 1
-	if parent != nil {
-		lvl = parent.Headline.Lvl + 1
+	if parent == nil {
+		// Top level headings go in the root section of the file.
+		_, parent = self.GetFromTarget(&common.Target{Type: "file", Filename: file.Filename}, false)
 	}
+	lvl := parent.Headline.Lvl + 1
 	title := name
 	p := org.Pos{Row: row, Col: 0}
 	ep := org.Pos{Row: row, Col: p.Col + len(title)}
@@ -681,17 +735,17 @@ func (self *OrgDb) AppendNodeAtNode(file *common.OrgFile, target *common.Target,
 	tHash.Write([]byte(title))
 	sec.Hash = b64.StdEncoding.EncodeToString(tHash.Sum(nil))
 	res := common.ResultMsg{}
-	InsertSection(file, sec, parent, &res)
+	InsertSection(ctx, file, sec, parent, &res)
 	self.ReloadFile(file.Filename)
 
 	return res.Ok
 }
 
-func (self *OrgDb) FindDateTree(target *common.Target, tree []string, parent *org.Section, allowCreate bool) (*common.OrgFile, *org.Section) {
+func (self *OrgDb) FindDateTree(ctx context.Context, target *common.Target, tree []string, parent *org.Section, allowCreate bool) (*common.OrgFile, *org.Section) {
 	file := self.FindByFile(target.Filename)
 	if file == nil && allowCreate {
 		fname := self.GetFilepath(target.Filename)
-		file = self.CreateOrgFile(fname, "")
+		file = self.CreateOrgFile(ctx, fname, "")
 	}
 	cur := parent.Children
 	sec := parent
@@ -701,9 +755,9 @@ func (self *OrgDb) FindDateTree(target *common.Target, tree []string, parent *or
 		sec = FindChildByName(cur, name)
 		if sec == nil {
 			if allowCreate {
-				self.AppendNodeAtNode(file, target, prv, name)
+				self.AppendNodeAtNode(ctx, file, target, prv, name)
 				// Start over, the file got rewritten!
-				return self.GetFromTarget(target, allowCreate)
+				return self.GetFromTargetIn(ctx, target, allowCreate)
 			}
 			return nil, nil
 		} else {
@@ -713,11 +767,11 @@ func (self *OrgDb) FindDateTree(target *common.Target, tree []string, parent *or
 	return file, sec
 }
 
-func (self *OrgDb) GetOrCreateFile(target *common.Target, allowCreate bool) *common.OrgFile {
+func (self *OrgDb) GetOrCreateFile(ctx context.Context, target *common.Target, allowCreate bool) *common.OrgFile {
 	file := self.FindByFile(target.Filename)
 	if file == nil && allowCreate {
 		fname := self.GetFilepath(target.Filename)
-		file = self.CreateOrgFile(fname, "")
+		file = self.CreateOrgFile(ctx, fname, "")
 	}
 	return file
 }
@@ -784,25 +838,37 @@ func (self *OrgDb) GetFromPreciseTarget(target *common.PreciseTarget, typeId org
 	return nil, nil, nil
 }
 
+// The section holding everything before the first heading, given an empty
+// headline so it can be treated like any other section.
+func fileRootSection(file *common.OrgFile) *org.Section {
+	sec := file.Doc.Outline.Section
+	if sec != nil && sec.Headline == nil {
+		sec.Headline = &org.Headline{}
+		if file.Doc.Outline.Children != nil && len(file.Doc.Outline.Children) > 0 {
+			sec.Headline.EndPos = file.Doc.Outline.Children[len(file.Doc.Outline.Children)-1].Headline.EndPos
+			sec.Headline.Pos = file.Doc.Outline.Children[len(file.Doc.Outline.Children)-1].Headline.Pos
+		}
+	}
+	return sec
+}
+
 func (self *OrgDb) GetFromTarget(target *common.Target, allowCreate bool) (*common.OrgFile, *org.Section) {
+	return self.GetFromTargetIn(context.Background(), target, allowCreate)
+}
+
+// GetFromTarget for a change, whatever has to be created to reach the
+// target is written as part of the operation in ctx.
+func (self *OrgDb) GetFromTargetIn(ctx context.Context, target *common.Target, allowCreate bool) (*common.OrgFile, *org.Section) {
 	switch target.Type {
 	case "file":
-		file := self.GetOrCreateFile(target, allowCreate)
+		file := self.GetOrCreateFile(ctx, target, allowCreate)
 		if file == nil {
 			return nil, nil
 		}
-		sec := file.Doc.Outline.Section
-		if sec != nil && sec.Headline == nil {
-			sec.Headline = &org.Headline{}
-			if file.Doc.Outline.Children != nil && len(file.Doc.Outline.Children) > 0 {
-				sec.Headline.EndPos = file.Doc.Outline.Children[len(file.Doc.Outline.Children)-1].Headline.EndPos
-				sec.Headline.Pos = file.Doc.Outline.Children[len(file.Doc.Outline.Children)-1].Headline.Pos
-			}
-		}
-		return file, file.Doc.Outline.Section
+		return file, fileRootSection(file)
 	case "hash":
-		if f, ok := self.ByHash[target.Id]; ok {
-			return self.FileFromSection(f), f
+		if sec, file := self.LookupHash(target.Id); sec != nil {
+			return file, sec
 		}
 		return nil, nil
 	case "id":
@@ -816,33 +882,33 @@ func (self *OrgDb) GetFromTarget(target *common.Target, allowCreate bool) (*comm
 		}
 		return nil, nil
 	case "file+olp":
-		return self.FindByOlp(target, allowCreate)
+		return self.FindByOlp(ctx, target, allowCreate)
 	case "file+olp+datetree":
-		file, sec := self.FindByOlp(target, allowCreate)
+		file, sec := self.FindByOlp(ctx, target, allowCreate)
 		if sec != nil {
 			// Now do the datetree!
 			_, dt := DateTreeGenerate(nil)
-			return self.FindDateTree(target, dt, sec, allowCreate)
+			return self.FindDateTree(ctx, target, dt, sec, allowCreate)
 		}
 		return file, sec
 	case "file+datetree":
 		_, dt := DateTreeGenerate(nil)
-		file := self.GetOrCreateFile(target, allowCreate)
-		if file == nil {
+		// The root section of the file, with a headline to insert under.
+		_, sec := self.GetFromTargetIn(ctx, &common.Target{Type: "file", Filename: target.Filename}, allowCreate)
+		if sec == nil {
 			return nil, nil
 		}
-		sec := file.Doc.Outline.Section
-		return self.FindDateTree(target, dt, sec, allowCreate)
+		return self.FindDateTree(ctx, target, dt, sec, allowCreate)
 	case "file+headline":
 		file := self.FindByFile(target.Filename)
 		if file == nil && allowCreate {
 			fname := self.GetFilepath(target.Filename)
-			file = self.CreateOrgFile(fname, "")
+			file = self.CreateOrgFile(ctx, fname, "")
 		}
 		if file != nil {
 			node := self.FindNodeByHeadline(file, target)
 			if node == nil && allowCreate {
-				file, node = self.AppendNodeToFile(file, target)
+				file, node = self.AppendNodeToFile(ctx, file, target)
 			}
 			return file, node
 		}
@@ -851,7 +917,7 @@ func (self *OrgDb) GetFromTarget(target *common.Target, allowCreate bool) (*comm
 		file := self.FindByFile(target.Filename)
 		if file == nil && allowCreate {
 			fname := self.GetFilepath(target.Filename)
-			file = self.CreateOrgFile(fname, "")
+			file = self.CreateOrgFile(ctx, fname, "")
 		}
 		re, err := regexp.Compile(target.Id)
 		if err != nil {
@@ -2,6 +2,9 @@
 package orgs
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/ihdavids/go-org/org"
 	"github.com/ihdavids/orgs/internal/common"
 )
@@ -81,3 +84,138 @@ func (self *Db) GetFromTarget(target *common.Target, allowCreate bool) (*common.
 func (self *Db) GetFromPreciseTarget(target *common.PreciseTarget, typeId org.NodeType) (*common.OrgFile, *org.Section, org.Node) {
 	return GetDb().GetFromPreciseTarget(target, typeId)
 }
+
+// UserDb is the plugin api as one user sees it, files they cannot read
+// do not exist. Exporters are handed one of these so that whatever they
+// query only ever contains what the caller is allowed to see.
+type UserDb struct {
+	Db
+	Username string
+	// Set for POST requests, an exporter may only change files through
+	// a request that is journaled and checked against If-Match.
+	Writable bool
+	// The request the changes are made for, they are journaled with it.
+	Ctx context.Context
+}
+
+// The context a plugin writes through db with. What the server runs for
+// itself is not journaled.
+func dbContext(db common.ODb) context.Context {
+	if udb, ok := db.(*UserDb); ok && udb.Ctx != nil {
+		return udb.Ctx
+	}
+	return context.Background()
+}
+
+func NewUserDb(username string) *UserDb {
+	return &UserDb{Username: username}
+}
+
+func (self *UserDb) todo(t *common.Todo) *common.Todo {
+	if t == nil || !CanReadFile(self.Username, t.Filename) {
+		return nil
+	}
+	return t
+}
+
+func (self *UserDb) QueryTodosExpr(query string) (common.Todos, error) {
+	res, err := self.Db.QueryTodosExpr(query)
+	if err != nil {
+		return res, err
+	}
+	return *FilterTodos(self.Username, &res), nil
+}
+
+func (self *UserDb) FindByHash(hash string) *common.Todo {
+	return self.todo(self.Db.FindByHash(hash))
+}
+
+func (self *UserDb) FindByAnyId(hash string) *common.Todo {
+	return self.todo(self.Db.FindByAnyId(hash))
+}
+
+func (self *UserDb) FindNextSibling(hash string) *common.Todo {
+	return self.todo(self.Db.FindNextSibling(hash))
+}
+
+func (self *UserDb) FindPrevSibling(hash string) *common.Todo {
+	return self.todo(self.Db.FindPrevSibling(hash))
+}
+
+func (self *UserDb) FindLastChild(hash string) *common.Todo {
+	return self.todo(self.Db.FindLastChild(hash))
+}
+
+func (self *UserDb) FindByFile(filename string) *org.Document {
+	if f := self.GetFile(filename); f != nil {
+		return f.Doc
+	}
+	return nil
+}
+
+func (self *UserDb) GetFile(filename string) *common.OrgFile {
+	f := self.Db.GetFile(filename)
+	if f == nil || !CanReadFile(self.Username, f.Filename) {
+		return nil
+	}
+	return f
+}
+
+// Exporters only read, so this view never creates a file.
+func (self *UserDb) GetFromTarget(target *common.Target, allowCreate bool) (*common.OrgFile, *org.Section) {
+	f, sec := self.Db.GetFromTarget(target, false)
+	if f != nil && !CanReadFile(self.Username, f.Filename) {
+		return nil, nil
+	}
+	return f, sec
+}
+
+func (self *UserDb) GetFromPreciseTarget(target *common.PreciseTarget, typeId org.NodeType) (*common.OrgFile, *org.Section, org.Node) {
+	f, sec, n := self.Db.GetFromPreciseTarget(target, typeId)
+	if f != nil && !CanReadFile(self.Username, f.Filename) {
+		return nil, nil, nil
+	}
+	return f, sec, n
+}
+
+// Where a file an exporter wants to read lives. Only files inside the org
+// dirs that the user behind db can read are found.
+func ResolveExportFile(db common.ODb, filename string) (string, bool) {
+	path, ok := ResolveOrgPath(filename)
+	if !ok {
+		return "", false
+	}
+	if udb, isUser := db.(*UserDb); isUser && !CanReadFile(udb.Username, path) {
+		return "", false
+	}
+	return path, true
+}
+
+// HeadingProperties lets exporters record what they learned about a heading,
+// a confluence page id for example, on behalf of whoever ran the export.
+type HeadingProperties struct{}
+
+// Nil when the properties of the heading can be set through this db.
+// Exports the server runs for itself can always set them.
+func (self HeadingProperties) CanSetProperties(db common.ODb, hash string) error {
+	sec, f := GetDb().LookupHash(hash)
+	if sec == nil {
+		return fmt.Errorf("could not find heading [%s]", hash)
+	}
+	if udb, ok := db.(*UserDb); ok {
+		if !udb.Writable {
+			return fmt.Errorf("export changes %s, it has to be a POST", f.Filename)
+		}
+		if !CanWriteFile(udb.Username, f.Filename) {
+			return fmt.Errorf("access denied [%s]", f.Filename)
+		}
+	}
+	return nil
+}
+
+func (self HeadingProperties) SetProperties(db common.ODb, hash string, props map[string]string) error {
+	if err := self.CanSetProperties(db, hash); err != nil {
+		return err
+	}
+	return SetHeadingProperties(dbContext(db), hash, props)
+}
@@ -16,4 +16,5 @@ import (
 	_ "github.com/ihdavids/orgs/internal/app/orgs/plugs/latex"
 	_ "github.com/ihdavids/orgs/internal/app/orgs/plugs/confluence"
 	_ "github.com/ihdavids/orgs/internal/app/orgs/plugs/tangle"
+	_ "github.com/ihdavids/orgs/internal/app/orgs/plugs/timesheet"
 )
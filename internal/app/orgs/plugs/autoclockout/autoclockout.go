@@ -7,6 +7,8 @@ package autoclockout
 	The autoclockout plugin will automatically clock out of any active
 	clock entry at a configured time of day. This is useful for ensuring
 	you don't accidentally leave a clock running overnight.
+	Every user with a running clock is clocked out. It also marks clocks
+	that have run past clockIdleThreshold as dangling.
 
 	Configure it in your orgs.yaml under server.plugins:
 
@@ -40,11 +42,12 @@ type AutoClockOut struct {
 	clock         ClockAccessor
 }
 
-// ClockAccessor abstracts access to the clock singleton so the plugin
+// ClockAccessor abstracts access to the server clocks so the plugin
 // doesn't import internal/app/orgs directly.
 type ClockAccessor interface {
 	IsClockActive() bool
 	ClockOut() (common.ResultMsg, error)
+	CheckIdle(now time.Time) int
 }
 
 var clockAccessor ClockAccessor
@@ -66,10 +69,12 @@ func (self *AutoClockOut) Update(db common.ODb) {
 			return
 		}
 	}
+	now := time.Now()
+	// Dangling clocks are not clocked out, they have to be resolved.
+	self.clock.CheckIdle(now)
 	if !self.clock.IsClockActive() {
 		return
 	}
-	now := time.Now()
 	today := now.Format("2006-01-02")
 	nowMins := now.Hour()*60 + now.Minute()
 
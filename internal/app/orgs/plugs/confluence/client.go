@@ -0,0 +1,170 @@
+package confluence
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConfluenceClient talks to the parts of the Confluence REST api the
+// exporter needs. Url is the base of the site, everything else is relative
+// to it, so it can just as well point at a local stand-in for the api.
+type ConfluenceClient struct {
+	Url   string
+	User  string
+	Token string
+	Http  *http.Client
+}
+
+type ConfluenceVersion struct {
+	Number int `json:"number"`
+}
+
+type ConfluenceLinks struct {
+	Base  string `json:"base"`
+	WebUi string `json:"webui"`
+}
+
+type ConfluencePage struct {
+	Id      string            `json:"id"`
+	Type    string            `json:"type"`
+	Title   string            `json:"title"`
+	Version ConfluenceVersion `json:"version"`
+	Links   ConfluenceLinks   `json:"_links"`
+}
+
+// The address of the page in the browser.
+func (self *ConfluencePage) Link(base string) string {
+	if self.Links.Base != "" {
+		base = self.Links.Base
+	}
+	if self.Links.WebUi == "" {
+		return strings.TrimSuffix(base, "/") + "/pages/viewpage.action?pageId=" + url.QueryEscape(self.Id)
+	}
+	return strings.TrimSuffix(base, "/") + self.Links.WebUi
+}
+
+// A request the api refused.
+type ConfluenceError struct {
+	Status int
+	Msg    string
+}
+
+func (self *ConfluenceError) Error() string {
+	return fmt.Sprintf("confluence: %d %s", self.Status, self.Msg)
+}
+
+func IsNotFound(err error) bool {
+	cerr, ok := err.(*ConfluenceError)
+	return ok && cerr.Status == http.StatusNotFound
+}
+
+func NewConfluenceClient(base string, user string, token string) *ConfluenceClient {
+	return &ConfluenceClient{Url: base, User: user, Token: token, Http: &http.Client{Timeout: 60 * time.Second}}
+}
+
+func (self *ConfluenceClient) do(method string, api string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(self.Url, "/")+api, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	// Attachments are refused without this.
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	if self.User != "" || self.Token != "" {
+		req.SetBasicAuth(self.User, self.Token)
+	}
+	client := self.Http
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := strings.TrimSpace(string(data))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		return &ConfluenceError{Status: resp.StatusCode, Msg: msg}
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (self *ConfluenceClient) doJson(method string, api string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return self.do(method, api, bytes.NewReader(body), "application/json", out)
+}
+
+func pageData(space string, title string, content string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":  "page",
+		"title": title,
+		"space": map[string]string{"key": space},
+		"body": map[string]interface{}{"storage": map[string]string{
+			"value":          content,
+			"representation": "storage"}}}
+}
+
+func (self *ConfluenceClient) GetPage(id string) (*ConfluencePage, error) {
+	page := &ConfluencePage{}
+	err := self.do("GET", "/rest/api/content/"+url.PathEscape(id)+"?expand=version", nil, "", page)
+	return page, err
+}
+
+func (self *ConfluenceClient) CreatePage(space string, title string, parent string, content string) (*ConfluencePage, error) {
+	data := pageData(space, title, content)
+	if parent != "" {
+		data["ancestors"] = []map[string]string{{"id": parent}}
+	}
+	page := &ConfluencePage{}
+	err := self.doJson("POST", "/rest/api/content", data, page)
+	return page, err
+}
+
+// Update a page, version has to be one more than the version of the page now.
+func (self *ConfluenceClient) UpdatePage(id string, space string, title string, content string, version int) (*ConfluencePage, error) {
+	data := pageData(space, title, content)
+	data["id"] = id
+	data["version"] = ConfluenceVersion{Number: version}
+	page := &ConfluencePage{}
+	err := self.doJson("PUT", "/rest/api/content/"+url.PathEscape(id), data, page)
+	return page, err
+}
+
+// Add an attachment to a page, replacing any attachment with the same name.
+func (self *ConfluenceClient) PutAttachment(id string, name string, data []byte) error {
+	buf := bytes.Buffer{}
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	form.WriteField("minorEdit", "true")
+	if err := form.Close(); err != nil {
+		return err
+	}
+	return self.do("PUT", "/rest/api/content/"+url.PathEscape(id)+"/child/attachment", &buf, form.FormDataContentType(), nil)
+}
@@ -21,6 +21,40 @@
     space: "~myusername"
 	#+END_SRC
 
+	Only the part of the file under the first heading tagged :confluence:
+	is exported. The page is titled after the files #+TITLE, or the heading
+	if there is none. The first export creates the page and records it on
+	the heading:
+
+	#+BEGIN_SRC org
+    * Design Notes :confluence:
+      :PROPERTIES:
+      :CONFLUENCE_ID: 12345
+      :CONFLUENCE_VERSION: 1
+      :END:
+	#+END_SRC
+
+	Because the export changes the file it has to be run with
+	=POST /api/file/confluence=, by someone who can write the file:
+
+	#+BEGIN_SRC bash
+    oc export -f confluence -post -l f -query design.org
+	#+END_SRC
+
+	Later exports update that page rather than creating a new one. If the
+	page was edited in confluence since the last export the export is
+	refused, set the force property to t to overwrite it anyway. A page that
+	was deleted in confluence is created again.
+
+	Images linked from the exported headings are uploaded as attachments of
+	the page, replacing any earlier attachment with the same name. Only
+	images inside the org dirs that you can read are uploaded, other links
+	are left as they are.
+
+	Exporting to a file, with =local=, writes the address of the page to it.
+
+	The url can point at any server that speaks the confluence REST api,
+	such as a local stand-in when trying things out.
 
 EDOC */
 
@@ -28,16 +62,13 @@ package confluence
 
 import (
 	"fmt"
-	//"html"
+	"html"
 	//"html/template"
-	"log"
 	"os"
-
-	//"path/filepath"
-	"bytes"
-	"encoding/json"
-	"net/http"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ihdavids/go-org/org"
@@ -55,11 +86,39 @@ type OrgConfluenceExporter struct {
 	Token        string
 	Space        string
 	Url          string
+	Client       *ConfluenceClient `yaml:"-"`
 	out          *logging.Logger
 	pm           *common.PluginManager
 	opts         *common.PluginOpts
 }
 
+// PropertyStore sets properties on the heading with the given hash and
+// writes the file out, on behalf of whoever the db belongs to. It is
+// provided by the server so the plugin doesn't import internal/app/orgs
+// directly.
+type PropertyStore interface {
+	CanSetProperties(db common.ODb, hash string) error
+	SetProperties(db common.ODb, hash string, props map[string]string) error
+}
+
+var propertyStore PropertyStore
+
+// RegisterPropertyStore is called by the server startup.
+func RegisterPropertyStore(p PropertyStore) {
+	propertyStore = p
+}
+
+// FileResolver returns the absolute path of a file if it is inside the org
+// dirs and whoever the db belongs to can read it.
+type FileResolver func(db common.ODb, filename string) (string, bool)
+
+var fileResolver FileResolver
+
+// RegisterFileResolver is called by the server startup.
+func RegisterFileResolver(r FileResolver) {
+	fileResolver = r
+}
+
 func HeadlineAloneHasTag(name string, h *org.Headline) bool {
 	if h != nil {
 		for _, t := range h.Tags {
@@ -125,15 +184,18 @@ func (self *OrgConfluenceExporter) Unmarshal(unmarshal func(interface{}) error)
 	return unmarshal(self)
 }
 
+// Exports the file to its page and writes the address of the page to the
+// to file, if there is one.
 func (self *OrgConfluenceExporter) Export(db common.ODb, query string, to string, opts string, props map[string]string) error {
-	fmt.Printf("CONFLUENCE: Export called", query, to, opts)
-	_, err := db.QueryTodosExpr(query)
+	err, link := self.ExportToString(db, query, opts, props)
 	if err != nil {
-		msg := fmt.Sprintf("ERROR: html failed to query expression, %v [%s]\n", err, query)
-		log.Printf(msg)
-		return fmt.Errorf(msg)
+		return err
 	}
-	return nil
+	self.logf("CONFLUENCE: exported %s to %s\n", query, link)
+	if to == "" {
+		return nil
+	}
+	return os.WriteFile(to, []byte(link+"\n"), 0644)
 }
 
 /*
@@ -145,58 +207,184 @@ func (self *OrgConfluenceExporter) Export(db common.ODb, query string, to string
 		}
 	}
 */
+// Exports the file to its page and returns the address of the page.
 func (self *OrgConfluenceExporter) ExportToString(db common.ODb, query string, opts string, props map[string]string) (error, string) {
-
+	if props == nil {
+		props = map[string]string{}
+	}
+	f := db.GetFile(query)
+	if f == nil || f.Doc == nil {
+		return fmt.Errorf("confluence: could not find file [%s]", query), ""
+	}
+	sec := FindConfluenceSection(f.Doc.Outline.Children)
+	if sec == nil {
+		return fmt.Errorf("confluence: no heading tagged :confluence: in [%s]", query), ""
+	}
+	// Find out before touching confluence, a page we cannot record would
+	// be created again on the next export.
+	if propertyStore == nil {
+		return fmt.Errorf("confluence: cannot record the page on the heading"), ""
+	}
+	if err := propertyStore.CanSetProperties(db, sec.Hash); err != nil {
+		return fmt.Errorf("confluence: %v", err), ""
+	}
 	exp := htmlexp.OrgHtmlExporter{Props: htmlexp.ValidateMap(map[string]interface{}{}), TemplatePath: "html_default.tpl"}
 	exp.ExtendedHeadline = WriteHeadline
 	// Limit our exports to only things tagged with confluence
 	exp.Props["skipnoconfluence"] = "t"
 	exp.Startup(self.pm, self.opts)
 	err, str := exp.ExportToString(db, query, opts, props)
-	if err == nil {
-		self.CreateConfluencePage(str, props)
+	if err != nil {
+		return err, ""
 	}
-	return nil, ""
+	if props["title"] == "" {
+		props["title"] = strings.TrimSpace(f.Doc.Get("TITLE"))
+	}
+	if props["title"] == "" {
+		props["title"] = common.GetSectionTitle(sec)
+	}
+	if props["title"] == "" {
+		props["title"] = strings.TrimSuffix(filepath.Base(f.Filename), filepath.Ext(f.Filename))
+	}
+	page, err := self.SyncConfluencePage(db, f, sec, str, props)
+	if err != nil {
+		return err, ""
+	}
+	return nil, page.Link(self.Url)
 }
 
-func (self *OrgConfluenceExporter) CreateConfluencePage(res string, props map[string]string) *http.Response {
-	// we will run an HTTP server locally to test the POST request
-	url := self.Url + "/rest/api/content"
-
-	title := "My New Page"
-	if t, ok := props["title"]; ok && t != "" {
-		title = t
-	}
-	page_data := map[string]interface{}{
-		"type":  "page",
-		"title": title,
-		"space": map[string]string{"key": self.Space},
-		"body": map[string]interface{}{"storage": map[string]string{
-			"value":          res,
-			"representation": "storage"}}}
-	if pid, ok := props["parent"]; ok && pid != "" {
-		page_data["parentId"] = pid
-	}
-	body, err := json.Marshal(page_data)
-	fmt.Printf("body: %s\n", body)
-	if err != nil {
-		fmt.Printf("ERROR could not marshal json data: ", err)
-		return nil
+// The first heading tagged confluence, this is the heading that owns the page.
+func FindConfluenceSection(secs []*org.Section) *org.Section {
+	for _, sec := range secs {
+		if HeadlineAloneHasTag("confluence", sec.Headline) {
+			return sec
+		}
+		if s := FindConfluenceSection(sec.Children); s != nil {
+			return s
+		}
 	}
-	// create post body
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	req.Header.Add("Content-Type", "application/json")
-	req.SetBasicAuth(self.User, self.Token)
-	resp, err := client.Do(req)
-	//resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		log.Fatal(err)
+	return nil
+}
+
+func (self *OrgConfluenceExporter) logf(format string, args ...interface{}) {
+	if self.out != nil {
+		self.out.Infof(format, args...)
+	}
+}
+
+func (self *OrgConfluenceExporter) client() *ConfluenceClient {
+	if self.Client == nil {
+		self.Client = NewConfluenceClient(self.Url, self.User, self.Token)
+	}
+	return self.Client
+}
+
+var RE_IMG = regexp.MustCompile(`<img\s+src="([^"]*)"[^>]*>`)
+var RE_LOCALIMAGE = regexp.MustCompile(`^https?://localhost:\d+/images/`)
+
+// Where a linked image lives on disk, empty if it is not one of ours.
+// Only images inside the org dirs that the user can read are attached.
+func (self *OrgConfluenceExporter) imagePath(db common.ODb, f *common.OrgFile, src string) string {
+	name := RE_LOCALIMAGE.ReplaceAllString(src, "")
+	if name == src {
+		if !strings.HasPrefix(src, "file://") {
+			return ""
+		}
+		name = src[len("file://"):]
+	}
+	name = html.UnescapeString(name)
+	if fileResolver == nil {
+		return ""
+	}
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(filepath.Dir(f.Filename), name)}
+		if self.pm != nil {
+			for _, dir := range self.pm.OrgDirs {
+				candidates = append(candidates, filepath.Join(dir, name))
+			}
+		}
+	}
+	for _, fname := range candidates {
+		if path, ok := fileResolver(db, fname); ok {
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
 	}
-	defer resp.Body.Close()
-	fmt.Println("Status:", resp.Status)
+	return ""
+}
 
-	return resp
+// Linked images become attachments of the page, the page refers to them by name.
+func (self *OrgConfluenceExporter) attachImages(db common.ODb, f *common.OrgFile, body string) (string, map[string]string) {
+	attach := map[string]string{}
+	body = RE_IMG.ReplaceAllStringFunc(body, func(img string) string {
+		fname := self.imagePath(db, f, html.UnescapeString(RE_IMG.FindStringSubmatch(img)[1]))
+		if fname == "" {
+			return img
+		}
+		name := filepath.Base(fname)
+		attach[name] = fname
+		return fmt.Sprintf(`<ac:image><ri:attachment ri:filename="%s" /></ac:image>`, html.EscapeString(name))
+	})
+	return body, attach
+}
+
+// Create the page for a heading, or update it if the heading already has one.
+// The page id and version are kept in properties on the heading.
+func (self *OrgConfluenceExporter) SyncConfluencePage(db common.ODb, f *common.OrgFile, sec *org.Section, res string, props map[string]string) (*ConfluencePage, error) {
+	client := self.client()
+	body, attach := self.attachImages(db, f, res)
+	title := props["title"]
+	id, ver := "", ""
+	if sec.Headline.Properties != nil {
+		id, _ = sec.Headline.Properties.Get("CONFLUENCE_ID")
+		ver, _ = sec.Headline.Properties.Get("CONFLUENCE_VERSION")
+	}
+	have, _ := strconv.Atoi(ver)
+	var page *ConfluencePage
+	var err error
+	if id != "" {
+		cur, gerr := client.GetPage(id)
+		switch {
+		case IsNotFound(gerr):
+			self.logf("CONFLUENCE: page %s is gone, creating a new one\n", id)
+			id = ""
+		case gerr != nil:
+			return nil, gerr
+		case cur.Version.Number > have && props["force"] != "t":
+			return nil, fmt.Errorf("confluence: page %s was changed in confluence (version %d, last exported %d), export with force to overwrite it", id, cur.Version.Number, have)
+		default:
+			page, err = client.UpdatePage(id, self.Space, title, body, cur.Version.Number+1)
+		}
+	}
+	if id == "" {
+		page, err = client.CreatePage(self.Space, title, props["parent"], body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range attach {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, rerr := os.ReadFile(attach[name])
+		if rerr == nil {
+			rerr = client.PutAttachment(page.Id, name, data)
+		}
+		if rerr != nil {
+			return page, fmt.Errorf("confluence: failed to attach %s to page %s: %v", name, page.Id, rerr)
+		}
+	}
+	if propertyStore == nil {
+		return page, fmt.Errorf("confluence: page %s exported but cannot record it on the heading", page.Id)
+	}
+	err = propertyStore.SetProperties(db, sec.Hash, map[string]string{
+		"CONFLUENCE_ID":      page.Id,
+		"CONFLUENCE_VERSION": strconv.Itoa(page.Version.Number)})
+	return page, err
 }
 
 func (self *OrgConfluenceExporter) Startup(manager *common.PluginManager, opts *common.PluginOpts) {
@@ -0,0 +1,270 @@
+package confluence
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+type fakePage struct {
+	Title       string
+	Space       string
+	Parent      string
+	Body        string
+	Version     int
+	Attachments map[string]string
+}
+
+// A stand-in for the parts of the confluence api the client uses.
+type fakeConfluence struct {
+	mu     sync.Mutex
+	pages  map[string]*fakePage
+	nextId int
+	srv    *httptest.Server
+}
+
+func newFakeConfluence(t *testing.T) *fakeConfluence {
+	fc := &fakeConfluence{pages: map[string]*fakePage{}, nextId: 100}
+	fc.srv = httptest.NewServer(http.HandlerFunc(fc.serve))
+	t.Cleanup(fc.srv.Close)
+	return fc
+}
+
+func (self *fakeConfluence) page(id string) *fakePage {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.pages[id]
+}
+
+func (self *fakeConfluence) count() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return len(self.pages)
+}
+
+func (self *fakeConfluence) reply(w http.ResponseWriter, id string, p *fakePage) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConfluencePage{Id: id, Type: "page", Title: p.Title,
+		Version: ConfluenceVersion{Number: p.Version},
+		Links:   ConfluenceLinks{WebUi: "/pages/" + id}})
+}
+
+type fakeContent struct {
+	Title string `json:"title"`
+	Space struct {
+		Key string `json:"key"`
+	} `json:"space"`
+	Body struct {
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+	Ancestors []struct {
+		Id string `json:"id"`
+	} `json:"ancestors"`
+	Version ConfluenceVersion `json:"version"`
+}
+
+func (self *fakeConfluence) serve(w http.ResponseWriter, r *http.Request) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	rest := strings.TrimPrefix(r.URL.Path, "/rest/api/content")
+	id, attach := strings.CutSuffix(strings.TrimPrefix(rest, "/"), "/child/attachment")
+	switch {
+	case r.Method == "POST" && id == "":
+		var c fakeContent
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		self.nextId++
+		id = strconv.Itoa(self.nextId)
+		p := &fakePage{Title: c.Title, Space: c.Space.Key, Body: c.Body.Storage.Value, Version: 1, Attachments: map[string]string{}}
+		if len(c.Ancestors) > 0 {
+			p.Parent = c.Ancestors[0].Id
+		}
+		self.pages[id] = p
+		self.reply(w, id, p)
+	case self.pages[id] == nil:
+		http.Error(w, "no such page", http.StatusNotFound)
+	case r.Method == "GET":
+		self.reply(w, id, self.pages[id])
+	case r.Method == "PUT" && attach:
+		if r.Header.Get("X-Atlassian-Token") != "no-check" {
+			http.Error(w, "missing token", http.StatusForbidden)
+			return
+		}
+		file, hdr, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		self.pages[id].Attachments[hdr.Filename] = string(data)
+		w.Write([]byte(`{"results":[]}`))
+	case r.Method == "PUT":
+		var c fakeContent
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := self.pages[id]
+		if c.Version.Number != p.Version+1 {
+			http.Error(w, "version must be one more than the current version", http.StatusConflict)
+			return
+		}
+		p.Title, p.Body, p.Version = c.Title, c.Body.Storage.Value, c.Version.Number
+		self.reply(w, id, p)
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+// Keeps what the exporter records instead of writing a file.
+type fakeStore struct {
+	props map[string]string
+}
+
+func (self *fakeStore) CanSetProperties(db common.ODb, hash string) error {
+	return nil
+}
+
+func (self *fakeStore) SetProperties(db common.ODb, hash string, props map[string]string) error {
+	self.props = props
+	return nil
+}
+
+func useStore(t *testing.T) *fakeStore {
+	store := &fakeStore{}
+	old := propertyStore
+	propertyStore = store
+	t.Cleanup(func() { propertyStore = old })
+	return store
+}
+
+func newExporter(fc *fakeConfluence) *OrgConfluenceExporter {
+	exp := NewConfluenceExp()
+	exp.Space = "DOCS"
+	exp.Url = fc.srv.URL
+	exp.Client = NewConfluenceClient(fc.srv.URL, "user", "token")
+	return exp
+}
+
+func confluenceSection(t *testing.T, text string) (*common.OrgFile, *org.Section) {
+	doc := org.New().Parse(strings.NewReader(text), "design.org")
+	sec := FindConfluenceSection(doc.Outline.Children)
+	if sec == nil {
+		t.Fatalf("no :confluence: heading in %q", text)
+	}
+	return &common.OrgFile{Filename: filepath.Join(t.TempDir(), "design.org"), Doc: doc}, sec
+}
+
+func TestCreatePage(t *testing.T) {
+	fc := newFakeConfluence(t)
+	store := useStore(t)
+	f, sec := confluenceSection(t, "* Design :confluence:\nSome notes\n")
+	page, err := newExporter(fc).SyncConfluencePage(nil, f, sec, "<p>notes</p>", map[string]string{"title": "Design", "parent": "7"})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	p := fc.page(page.Id)
+	if p == nil || p.Title != "Design" || p.Space != "DOCS" || p.Parent != "7" || p.Body != "<p>notes</p>" || p.Version != 1 {
+		t.Fatalf("page not created as expected: %+v", p)
+	}
+	if store.props["CONFLUENCE_ID"] != page.Id || store.props["CONFLUENCE_VERSION"] != "1" {
+		t.Fatalf("page not recorded on the heading: %v", store.props)
+	}
+}
+
+func TestUpdatePageBumpsVersion(t *testing.T) {
+	fc := newFakeConfluence(t)
+	store := useStore(t)
+	fc.pages["42"] = &fakePage{Title: "Design", Space: "DOCS", Version: 3, Attachments: map[string]string{}}
+	f, sec := confluenceSection(t, "* Design :confluence:\n:PROPERTIES:\n:CONFLUENCE_ID: 42\n:CONFLUENCE_VERSION: 3\n:END:\n")
+	page, err := newExporter(fc).SyncConfluencePage(nil, f, sec, "<p>new</p>", map[string]string{"title": "Design"})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if page.Id != "42" || fc.page("42").Version != 4 || fc.page("42").Body != "<p>new</p>" {
+		t.Fatalf("page not updated to version 4: %+v", fc.page("42"))
+	}
+	if fc.count() != 1 {
+		t.Fatalf("a new page was created instead of updating: %d pages", fc.count())
+	}
+	if store.props["CONFLUENCE_VERSION"] != "4" {
+		t.Fatalf("new version not recorded: %v", store.props)
+	}
+}
+
+func TestUpdatePageConflict(t *testing.T) {
+	fc := newFakeConfluence(t)
+	store := useStore(t)
+	fc.pages["42"] = &fakePage{Title: "Design", Body: "<p>edited in confluence</p>", Version: 5, Attachments: map[string]string{}}
+	text := "* Design :confluence:\n:PROPERTIES:\n:CONFLUENCE_ID: 42\n:CONFLUENCE_VERSION: 3\n:END:\n"
+	f, sec := confluenceSection(t, text)
+	exp := newExporter(fc)
+	if _, err := exp.SyncConfluencePage(nil, f, sec, "<p>mine</p>", map[string]string{"title": "Design"}); err == nil {
+		t.Fatalf("export over a page changed in confluence should be refused")
+	}
+	if fc.page("42").Version != 5 || fc.page("42").Body != "<p>edited in confluence</p>" || store.props != nil {
+		t.Fatalf("refused export still changed something: %+v %v", fc.page("42"), store.props)
+	}
+	if _, err := exp.SyncConfluencePage(nil, f, sec, "<p>mine</p>", map[string]string{"title": "Design", "force": "t"}); err != nil {
+		t.Fatalf("forced export failed: %v", err)
+	}
+	if fc.page("42").Version != 6 || fc.page("42").Body != "<p>mine</p>" {
+		t.Fatalf("forced export did not overwrite the page: %+v", fc.page("42"))
+	}
+
+	// The client on its own reports the conflict from the server.
+	_, err := exp.Client.UpdatePage("42", "DOCS", "Design", "<p>stale</p>", 6)
+	if cerr, ok := err.(*ConfluenceError); !ok || cerr.Status != http.StatusConflict {
+		t.Fatalf("expected a 409 updating with a stale version, got %v", err)
+	}
+}
+
+func TestAttachImages(t *testing.T) {
+	fc := newFakeConfluence(t)
+	useStore(t)
+	dir := t.TempDir()
+	img := filepath.Join(dir, "diagram.png")
+	if err := os.WriteFile(img, []byte("png data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.png")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := fileResolver
+	fileResolver = func(db common.ODb, filename string) (string, bool) {
+		return filename, filepath.Dir(filename) == dir
+	}
+	t.Cleanup(func() { fileResolver = old })
+
+	f, sec := confluenceSection(t, "* Design :confluence:\n")
+	body := `<p><img src="file://` + img + `"></p><p><img src="file://` + outside + `"></p>`
+	page, err := newExporter(fc).SyncConfluencePage(nil, f, sec, body, map[string]string{"title": "Design"})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	p := fc.page(page.Id)
+	if p.Attachments["diagram.png"] != "png data" {
+		t.Fatalf("image not attached: %v", p.Attachments)
+	}
+	if _, ok := p.Attachments["secret.png"]; ok {
+		t.Fatalf("image the user cannot read was attached")
+	}
+	if !strings.Contains(p.Body, `<ri:attachment ri:filename="diagram.png" />`) || !strings.Contains(p.Body, outside) {
+		t.Fatalf("page does not refer to the attachment by name: %s", p.Body)
+	}
+}
@@ -75,7 +75,7 @@ func (self *Notify) Update(db common.ODb) {
 	//fmt.Printf("Notify Update...%v\n", time.Now())
 
 	curDate := time.Now()
-	query := fmt.Sprintf(`!IsProject() && !IsArchived() && IsTodo() && OnDate("%s")`, curDate.Format("2006 02 01"))
+	query := fmt.Sprintf(`!IsProject() && !IsArchived() && IsTodo() && OnDate("%s")`, curDate.Format("2006-01-02"))
 	notWindow := max(self.NotifyBeforeMins, int(self.freq/60.0))
 
 	if reply, err := db.QueryTodosExpr(query); err == nil {
@@ -0,0 +1,338 @@
+//lint:file-ignore ST1006 allow the use of self
+/* SDOC: Exporters
+
+* Timesheet
+  Exports the CLOCK entries from the logbook of every heading that matches the
+  query as a timesheet that can be handed to billing or finance tools.
+
+  Each clock entry becomes one row with its start and end time, the rounded
+  and raw minutes, the outline path of the heading, its tags and the value
+  of a configurable property like CLIENT or JIRA. Properties are inherited
+  from parent headings. Clocks that are still running are skipped. Clocks are
+  read the same way as for the clock table, so the two agree.
+
+  To enable the plugin you should add the following to your orgs.yaml file.
+
+	#+BEGIN_SRC yaml
+  - name: "timesheet"
+    format: "csv"        # csv, json or ics
+    property: "CLIENT"   # property column, empty for none
+    round: 15            # round each entry to this many minutes, 0 for none
+    roundMode: "up"      # up, down or nearest
+    drawer: "LOGBOOK"    # drawer the clocks live in, clockIntoDrawer by default
+	#+END_SRC
+
+  All of these can be overridden per export through the export properties,
+  along with =from= and =to= (=2024-01-31= style dates) to limit the clocks
+  to a date range. The =to= date is inclusive. A clock that runs over either
+  end is cut there, the way the clock table cuts clocks at its =:block=.
+
+EDOC */
+
+package timesheet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+type TimesheetEntry struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Mins         int       `json:"mins"`
+	RawMins      float64   `json:"rawMins"`
+	Headline     string    `json:"headline"`
+	Path         string    `json:"path"`
+	Tags         []string  `json:"tags"`
+	Filename     string    `json:"filename"`
+	Hash         string    `json:"hash"`
+	Property     string    `json:"property,omitempty"`
+	PropertyName string    `json:"propertyName,omitempty"`
+}
+
+type Timesheet struct {
+	Format    string
+	Property  string
+	Round     int
+	RoundMode string `yaml:"roundMode"`
+	Drawer    string
+	pm        *common.PluginManager
+}
+
+func (self *Timesheet) Unmarshal(unmarshal func(interface{}) error) error {
+	return unmarshal(self)
+}
+
+func (self *Timesheet) Startup(manager *common.PluginManager, opts *common.PluginOpts) {
+	self.pm = manager
+}
+
+// The settings for a single export, the config with any export properties applied.
+type timesheetOpts struct {
+	Timesheet
+	from time.Time
+	to   time.Time
+}
+
+func (self *Timesheet) options(opts string, props map[string]string) (*timesheetOpts, error) {
+	o := &timesheetOpts{Timesheet: *self}
+	if opts != "" {
+		o.Format = opts
+	}
+	if v, ok := props["format"]; ok && v != "" {
+		o.Format = v
+	}
+	if v, ok := props["property"]; ok {
+		o.Property = v
+	}
+	if v, ok := props["drawer"]; ok && v != "" {
+		o.Drawer = v
+	}
+	if v, ok := props["roundMode"]; ok && v != "" {
+		o.RoundMode = v
+	}
+	if v, ok := props["round"]; ok && v != "" {
+		r, err := strconv.Atoi(v)
+		if err != nil || r < 0 {
+			return nil, fmt.Errorf("timesheet round must be a number of minutes, got %q", v)
+		}
+		o.Round = r
+	}
+	if v, ok := props["from"]; ok && v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("timesheet from date %q is not of the form 2006-01-02", v)
+		}
+		o.from = t
+	}
+	if v, ok := props["to"]; ok && v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("timesheet to date %q is not of the form 2006-01-02", v)
+		}
+		o.to = t.AddDate(0, 0, 1)
+	}
+	o.Format = strings.ToLower(o.Format)
+	switch o.Format {
+	case "csv", "json", "ics":
+	default:
+		return nil, fmt.Errorf("unknown timesheet format %q, expected csv, json or ics", o.Format)
+	}
+	switch o.RoundMode {
+	case "up", "down", "nearest":
+	default:
+		return nil, fmt.Errorf("unknown timesheet round mode %q, expected up, down or nearest", o.RoundMode)
+	}
+	return o, nil
+}
+
+// Round a duration to the configured increment.
+func (self *timesheetOpts) round(mins float64) int {
+	if self.Round <= 0 {
+		return int(math.Round(mins))
+	}
+	steps := mins / float64(self.Round)
+	switch self.RoundMode {
+	case "down":
+		steps = math.Floor(steps)
+	case "nearest":
+		steps = math.Round(steps)
+	default:
+		// Avoid rounding 15.0000001 up to 30
+		steps = math.Ceil(steps - 1e-9)
+	}
+	return int(steps) * self.Round
+}
+
+// The drawer to read clocks from, the server clock drawer unless configured.
+func (self *timesheetOpts) drawer() string {
+	if self.Drawer != "" {
+		return self.Drawer
+	}
+	if self.pm != nil && self.pm.ClockDrawer != "" {
+		return self.pm.ClockDrawer
+	}
+	return "LOGBOOK"
+}
+
+// Properties like CLIENT are usually set on a parent heading.
+func inheritedProperty(sec *org.Section, name string) string {
+	for ; sec != nil; sec = sec.Parent {
+		if sec.Headline != nil && sec.Headline.Properties != nil {
+			if v, ok := sec.Headline.Properties.Get(name); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+func (self *Timesheet) collect(db common.ODb, query string, o *timesheetOpts) ([]TimesheetEntry, error) {
+	tds, err := db.QueryTodosExpr(query)
+	if err != nil {
+		msg := fmt.Sprintf("ERROR: timesheet failed to query expression, %v [%s]\n", err, query)
+		log.Print(msg)
+		return nil, fmt.Errorf("%s", msg)
+	}
+	entries := []TimesheetEntry{}
+	for _, td := range tds {
+		_, sec := db.GetFromTarget(&common.Target{Type: "hash", Id: td.Hash}, false)
+		if sec == nil || sec.Headline == nil {
+			continue
+		}
+		for _, clk := range common.HeadlineClocks(sec.Headline, o.drawer()) {
+			start, end := common.ClipClock(clk.Date, o.from, o.to)
+			if (!o.from.IsZero() || !o.to.IsZero()) && !end.After(start) {
+				continue
+			}
+			raw := end.Sub(start).Minutes()
+			e := TimesheetEntry{
+				Start:    start,
+				End:      end,
+				Mins:     o.round(raw),
+				RawMins:  raw,
+				Headline: td.Headline,
+				Path:     common.BuildOutlinePath(sec, "/"),
+				Tags:     sec.Headline.Tags,
+				Filename: td.Filename,
+				Hash:     td.Hash,
+			}
+			if o.Property != "" {
+				e.PropertyName = o.Property
+				e.Property = inheritedProperty(sec, o.Property)
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func writeCsv(entries []TimesheetEntry, o *timesheetOpts) (string, error) {
+	buf := bytes.NewBufferString("")
+	w := csv.NewWriter(buf)
+	header := []string{"start", "end", "minutes", "hours", "raw_minutes", "headline", "path", "tags", "file"}
+	if o.Property != "" {
+		header = append(header, strings.ToLower(o.Property))
+	}
+	w.Write(header)
+	for _, e := range entries {
+		row := []string{
+			e.Start.Format("2006-01-02 15:04"),
+			e.End.Format("2006-01-02 15:04"),
+			strconv.Itoa(e.Mins),
+			strconv.FormatFloat(float64(e.Mins)/60.0, 'f', 2, 64),
+			strconv.FormatFloat(e.RawMins, 'f', 0, 64),
+			e.Headline,
+			e.Path,
+			strings.Join(e.Tags, ":"),
+			e.Filename,
+		}
+		if o.Property != "" {
+			row = append(row, e.Property)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+func writeJson(entries []TimesheetEntry) (string, error) {
+	total := 0
+	for _, e := range entries {
+		total += e.Mins
+	}
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"entries":  entries,
+		"totalMin": total,
+	}, "", "  ")
+	return string(out), err
+}
+
+// Escape text for an iCalendar TEXT value.
+func icsEscape(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n")
+	return r.Replace(s)
+}
+
+func writeIcs(entries []TimesheetEntry, o *timesheetOpts) string {
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//orgs//timesheet//EN\r\nCALSCALE:GREGORIAN\r\n")
+	now := time.Now().UTC().Format(stamp)
+	for _, e := range entries {
+		desc := e.Path
+		if o.Property != "" && e.Property != "" {
+			desc += fmt.Sprintf("\n%s: %s", o.Property, e.Property)
+		}
+		desc += fmt.Sprintf("\nMinutes: %d", e.Mins)
+		b.WriteString("BEGIN:VEVENT\r\n")
+		fmt.Fprintf(&b, "UID:%s-%d@orgs\r\n", e.Hash, e.Start.Unix())
+		fmt.Fprintf(&b, "DTSTAMP:%s\r\n", now)
+		fmt.Fprintf(&b, "DTSTART:%s\r\n", e.Start.UTC().Format(stamp))
+		// The event covers the billed time rather than the raw clock.
+		fmt.Fprintf(&b, "DTEND:%s\r\n", e.Start.Add(time.Duration(e.Mins)*time.Minute).UTC().Format(stamp))
+		fmt.Fprintf(&b, "SUMMARY:%s\r\n", icsEscape(e.Headline))
+		fmt.Fprintf(&b, "DESCRIPTION:%s\r\n", icsEscape(desc))
+		if len(e.Tags) > 0 {
+			tags := []string{}
+			for _, t := range e.Tags {
+				tags = append(tags, icsEscape(t))
+			}
+			fmt.Fprintf(&b, "CATEGORIES:%s\r\n", strings.Join(tags, ","))
+		}
+		b.WriteString("END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	return b.String()
+}
+
+func (self *Timesheet) ExportToString(db common.ODb, query string, opts string, props map[string]string) (error, string) {
+	o, err := self.options(opts, props)
+	if err != nil {
+		return err, ""
+	}
+	entries, err := self.collect(db, query, o)
+	if err != nil {
+		return err, ""
+	}
+	switch o.Format {
+	case "json":
+		txt, err := writeJson(entries)
+		return err, txt
+	case "ics":
+		return nil, writeIcs(entries, o)
+	}
+	txt, err := writeCsv(entries, o)
+	return err, txt
+}
+
+func (self *Timesheet) Export(db common.ODb, query string, to string, opts string, props map[string]string) error {
+	err, txt := self.ExportToString(db, query, opts, props)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(to, []byte(txt), 0644); err != nil {
+		msg := fmt.Sprintf("Failed to write file[%v]: %v\n", err, to)
+		log.Print(msg)
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// init function is called at boot
+func init() {
+	common.AddExporter("timesheet", func() common.Exporter {
+		return &Timesheet{Format: "csv", RoundMode: "up"}
+	})
+}
@@ -9,6 +9,7 @@ EDOC */
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"regexp"
@@ -55,7 +56,7 @@ func formatHeadingAt(dest *org.Section, src *org.Section) string {
 	return res
 }
 
-func InsertSection(to *common.OrgFile, toInsert *org.Section, destination *org.Section, res *common.ResultMsg) {
+func InsertSection(ctx context.Context, to *common.OrgFile, toInsert *org.Section, destination *org.Section, res *common.ResultMsg) {
 	fmt.Printf("  [InsertSection]\n")
 	if r, err := os.Open(to.Doc.Path); err == nil {
 		defer r.Close()
@@ -100,14 +101,14 @@ func InsertSection(to *common.OrgFile, toInsert *org.Section, destination *org.S
 				}
 			}
 			fmt.Printf("Writing FILE: %v\n", to.Doc.Path)
-			os.WriteFile(to.Doc.Path, []byte(fileContent), 0644)
+			writeOrgFile(ctx, to.Doc.Path, []byte(fileContent), 0644)
 			res.Ok = true
 			res.Msg = "Insert successful"
 		}
 	}
 }
 
-func DeleteTree(filename string, sec *org.Section, res *common.ResultMsg) {
+func DeleteTree(ctx context.Context, filename string, sec *org.Section, res *common.ResultMsg) {
 	fmt.Printf("[DeleteEntry]\n")
 	if r, err := os.Open(filename); err == nil {
 		defer r.Close()
@@ -133,16 +134,25 @@ func DeleteTree(filename string, sec *org.Section, res *common.ResultMsg) {
 				fileContent += "\n"
 			}
 			fmt.Printf("Writing FILE: %v\n", filename)
-			os.WriteFile(filename, []byte(fileContent), 0644)
+			writeOrgFile(ctx, filename, []byte(fileContent), 0644)
 			res.Ok = true
 			res.Msg = "Delete successful"
 		}
 	}
 }
 
+// Find the target of a change, whatever has to be created to reach it is
+// written as part of the operation in ctx. A user's view never creates.
+func targetForWrite(ctx context.Context, db common.ODb, target *common.Target, allowCreate bool) (*common.OrgFile, *org.Section) {
+	if _, isUser := db.(*UserDb); isUser || !allowCreate {
+		return db.GetFromTarget(target, allowCreate)
+	}
+	return GetDb().GetFromTargetIn(ctx, target, allowCreate)
+}
+
 type ModifySourceFunc func(ofile *common.OrgFile, sec *org.Section) *org.Section
 
-func Refile(db common.ODb, args *common.Refile, mod ModifySourceFunc, allowCreate bool) (common.ResultMsg, error) {
+func Refile(ctx context.Context, db common.ODb, args *common.Refile, mod ModifySourceFunc, allowCreate bool) (common.ResultMsg, error) {
 	var res common.ResultMsg = common.ResultMsg{}
 	res.Ok = false
 	res.Msg = "Refile: unknown failure, did not refile"
@@ -153,7 +163,7 @@ func Refile(db common.ODb, args *common.Refile, mod ModifySourceFunc, allowCreat
 		fmt.Printf(">>> ERROR REFILE FROM NOT FOUND %s\n", res.Msg)
 		return res, nil
 	}
-	toFile, toSecs := db.GetFromTarget(&args.ToId, allowCreate)
+	toFile, toSecs := targetForWrite(ctx, db, &args.ToId, allowCreate)
 	if toFile == nil || toSecs == nil {
 		res.Msg = fmt.Sprintf("Refile: could not find destination target [%s]", args.ToId.Type)
 		res.Ok = false
@@ -163,14 +173,14 @@ func Refile(db common.ODb, args *common.Refile, mod ModifySourceFunc, allowCreat
 	if mod != nil {
 		fromSecs = mod(fromFile, fromSecs)
 	}
-	InsertSection(toFile, fromSecs, toSecs, &res)
+	InsertSection(ctx, toFile, fromSecs, toSecs, &res)
 	if res.Ok {
-		DeleteTree(fromFile.Doc.Path, fromSecs, &res)
+		DeleteTree(ctx, fromFile.Doc.Path, fromSecs, &res)
 	}
 	return res, nil
 }
 
-func Delete(db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
+func Delete(ctx context.Context, db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
 	var res common.ResultMsg = common.ResultMsg{}
 	res.Ok = false
 	res.Msg = "Delete: unknown failure, did not delete"
@@ -180,7 +190,7 @@ func Delete(db common.ODb, tgt *common.Target) (common.ResultMsg, error) {
 		res.Ok = false
 		return res, nil
 	}
-	DeleteTree(file.Doc.Path, secs, &res)
+	DeleteTree(ctx, file.Doc.Path, secs, &res)
 	return res, nil
 }
 
@@ -0,0 +1,147 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Repeating Tasks
+
+  A SCHEDULED or DEADLINE date can carry a repeater, just like in org mode:
+
+  #+BEGIN_SRC org
+  ** TODO Water the plants
+     SCHEDULED: <2024-01-15 Mon +1w>
+  #+END_SRC
+
+  When a task like this is moved to a DONE state orgs does not leave it DONE.
+  Instead it:
+  - Moves the SCHEDULED and DEADLINE dates forward by their repeater.
+  - Puts the task back into its first active TODO state, or the state named
+    in its =REPEAT_TO_STATE= property.
+  - Logs a =- State "DONE" from "TODO" [timestamp]= note in the logbook drawer
+    (see logIntoDrawer).
+  - Sets the =LAST_REPEAT= property to the time it was completed.
+
+  The three repeater styles are supported:
+  - =+1w= moves the date forward by one interval.
+  - =++1w= moves the date forward by whole intervals until it is in the future.
+  - =.+1w= moves the date to one interval from today.
+
+  Units can be h, d, w, m or y.
+EDOC */
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// Move a date on by its repeater. Returns false if the date does not repeat.
+func advanceRepeater(date *org.OrgDate, now time.Time) bool {
+	if date == nil || date.RepeatDWMY == "" {
+		return false
+	}
+	pre := date.RepeatPre
+	n, err := strconv.Atoi(strings.TrimLeft(pre, ".+"))
+	if err != nil || n <= 0 {
+		n = 1
+	}
+	step := func(t time.Time, count int) time.Time {
+		switch date.RepeatDWMY {
+		case "h":
+			return t.Add(time.Duration(count) * time.Hour)
+		case "w":
+			return t.AddDate(0, 0, 7*count)
+		case "m":
+			return t.AddDate(0, count, 0)
+		case "y":
+			return t.AddDate(count, 0, 0)
+		}
+		return t.AddDate(0, 0, count)
+	}
+	length := date.End.Sub(date.Start)
+	switch {
+	case strings.HasPrefix(pre, ".+"):
+		// From today, keeping the time of day.
+		s := date.Start
+		from := time.Date(now.Year(), now.Month(), now.Day(), s.Hour(), s.Minute(), s.Second(), 0, s.Location())
+		if date.RepeatDWMY == "h" {
+			from = now
+		}
+		date.Start = step(from, n)
+	case strings.HasPrefix(pre, "++"):
+		date.Start = step(date.Start, n)
+		for !date.Start.After(now) {
+			date.Start = step(date.Start, n)
+		}
+	default:
+		date.Start = step(date.Start, n)
+	}
+	if !date.End.IsZero() {
+		date.End = date.Start.Add(length)
+	}
+	return true
+}
+
+func repeatSDC(n *org.Headline, sdc *org.SDC, dtype org.DateType, now time.Time) *org.SDC {
+	if sdc == nil || sdc.Date == nil {
+		return sdc
+	}
+	date := *sdc.Date
+	if !advanceRepeater(&date, now) {
+		return sdc
+	}
+	next := &org.SDC{Date: &date, DateType: dtype}
+	removeSDCChild(n, dtype)
+	insertSDCChild(n, next)
+	return next
+}
+
+func HasRepeatingDate(n *org.Headline) bool {
+	for _, sdc := range []*org.SDC{n.Scheduled, n.Deadline} {
+		if sdc != nil && sdc.Date != nil && sdc.Date.RepeatDWMY != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// The state a repeating task goes back to once it is done.
+func repeatToState(n *org.Headline, f *common.OrgFile, from string) string {
+	if n.Properties != nil {
+		if st, ok := n.Properties.Get("REPEAT_TO_STATE"); ok && st != "" {
+			return st
+		}
+	}
+	active, _ := ValidStatusFromFile(f)
+	if len(active) > 0 {
+		return active[0]
+	}
+	return from
+}
+
+// Apply org repeater semantics to a heading that is being marked done.
+// Returns false, leaving the heading alone, if it has nothing to repeat.
+func RepeatTask(n *org.Headline, f *common.OrgFile, from, to, note string, now time.Time) bool {
+	if !HasRepeatingDate(n) {
+		return false
+	}
+	n.Scheduled = repeatSDC(n, n.Scheduled, org.Scheduled, now)
+	n.Deadline = repeatSDC(n, n.Deadline, org.Deadline, now)
+	n.Status = repeatToState(n, f, from)
+	AddLogNote(n, StateChangeNote(to, from, note, now))
+	if n.Properties == nil {
+		n.Properties = &org.PropertyDrawer{}
+	}
+	n.Properties.Set("LAST_REPEAT", now.Format(logTimestampLayout))
+	return true
+}
+
+func isDoneState(f *common.OrgFile, status string) bool {
+	if status == "" {
+		return false
+	}
+	_, done := ValidStatusFromFile(f)
+	return contains(done, status)
+}
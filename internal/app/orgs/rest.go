@@ -38,23 +38,30 @@ func RestApi(router *mux.Router) {
 	} else {
 		fmt.Println("WARNING: Authentication is disabled (noAuth: true)")
 	}
+	api.Use(journalMiddleware)
 
 	api.HandleFunc("/refresh", refresh).Methods("POST")
 	api.HandleFunc("/orgfile", RequestOrgFile)
+	api.HandleFunc("/revision", RequestRevision).Methods("GET")
 	api.HandleFunc("/findfile", RequestFindFileInDb)
 	api.HandleFunc("/files", RequestFiles)
 	api.HandleFunc("/file", CreateFile).Methods("POST")
 	api.HandleFunc("/dirs", RequestDirs)
 	api.HandleFunc("/newtemplates", RequestNewTemplates)
+	api.HandleFunc("/file/{type}", PostFile).Methods("POST")  // exporters that change the file
 	api.HandleFunc("/file/{type}", RequestFile)               // html etc
 	api.HandleFunc("/filecontents/headings", RequestHeadings) // Get all todos in file
 	api.HandleFunc("/filters", RequestFilters)                // Get all stored filters from the server
 	api.HandleFunc("/taggroups", RequestTagGroups)
 	api.HandleFunc("/grep", RequestGrep)
 	api.HandleFunc("/search", RequestTodosExpr)
+	api.HandleFunc("/textsearch", RequestTextSearch)
 	api.HandleFunc("/lookuphash", RequestHash)
 	api.HandleFunc("/todohtml/{hash}", RequestFullTodoHtml)
 	api.HandleFunc("/logbook/{hash}", RequestLogbook)
+	api.HandleFunc("/columns/{hash}", RequestColumns)
+	api.HandleFunc("/checkbox/toggle", PostCheckboxToggle).Methods("POST")
+	api.HandleFunc("/checkbox/{hash}", RequestCheckboxes)
 	api.HandleFunc("/filehtml/{hash}", RequestFullFileHtml)
 	api.HandleFunc("/todofull/{hash}", RequestFullTodo)
 	api.HandleFunc("/hash/{hash}", RequestByHash)
@@ -80,6 +87,12 @@ func RestApi(router *mux.Router) {
 	api.HandleFunc("/refilefiles", RequestRefileTargets)
 	api.HandleFunc("/refile", PostRefile).Methods("POST")
 	api.HandleFunc("/archive", PostArchive).Methods("POST")
+	api.HandleFunc("/archive/tag", PostArchiveTag).Methods("POST")
+	api.HandleFunc("/archive/sibling", PostArchiveSibling).Methods("POST")
+	api.HandleFunc("/archive/bulk", PostArchiveBulk).Methods("POST")
+	api.HandleFunc("/undo", PostUndo).Methods("POST")
+	api.HandleFunc("/redo", PostRedo).Methods("POST")
+	api.HandleFunc("/journal", RequestJournal).Methods("GET")
 	api.HandleFunc("/reformat", PostReformat).Methods("POST")
 	api.HandleFunc("/setexclusivemarker", PostMarker).Methods("POST")
 	api.HandleFunc("/exclusivemarker", RequestMarker)
@@ -87,14 +100,21 @@ func RestApi(router *mux.Router) {
 	api.HandleFunc("/clockin", PostClockIn).Methods("POST")
 	api.HandleFunc("/clockout", PostClockOut).Methods("POST")
 	api.HandleFunc("/clock", RequestClock)
+	api.HandleFunc("/clock/history", RequestClockHistory)
+	api.HandleFunc("/clock/resolve", PostClockResolve).Methods("POST")
 	api.HandleFunc("/clockreport", RequestClockReport)
 	api.HandleFunc("/execb", PostExecb).Methods("POST")
 	api.HandleFunc("/exectable", PostExect).Methods("POST")
 	api.HandleFunc("/execalltables", PostExecAllT).Methods("POST")
+	api.HandleFunc("/recalctables", PostRecalcTables).Methods("POST")
+	api.HandleFunc("/table/export", RequestTableExport).Methods("GET")
+	api.HandleFunc("/table/import", PostTableImport).Methods("POST")
+	api.HandleFunc("/table/op", PostTableOp).Methods("POST")
 	api.HandleFunc("/tableformulainfo", PostFormulaInfo).Methods("POST")
 	api.HandleFunc("/tablerandomget", RequestTableRandomGet)
 	api.HandleFunc("/tablenames", RequestTableNames)
 	api.HandleFunc("/tangle", RequestTangle)
+	api.HandleFunc("/events", RequestEvents).Methods("GET")
 
 	// Per-user extensions: stored queries
 	api.HandleFunc("/ext/queries", RequestStoredQueries).Methods("GET")
@@ -107,6 +127,13 @@ func RestApi(router *mux.Router) {
 	api.HandleFunc("/ext/capture/template", PostUserCaptureTemplate).Methods("POST")
 	api.HandleFunc("/ext/capture/template", DeleteUserCaptureTemplate).Methods("DELETE")
 
+	// User management
+	api.HandleFunc("/users", RequestUsers).Methods("GET")
+	api.HandleFunc("/user/add", PostAddUser).Methods("POST")
+	api.HandleFunc("/user/password", PostSetPassword).Methods("POST")
+	api.HandleFunc("/user/disable", PostDisableUser).Methods("POST")
+	api.HandleFunc("/user/role", PostSetRole).Methods("POST")
+
 }
 
 type MiddlewareFunc func(http.Handler) http.Handler
@@ -152,7 +179,7 @@ func GetHash(vars map[string]string, name string) (string, error) {
 func RequestFiles(w http.ResponseWriter, r *http.Request) {
 	//vars := mux.Vars(r)
 	//key := vars["id"]
-	res := GetDb().GetFiles()
+	res := FilterFiles(GetUsername(r), GetDb().GetFiles())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
@@ -176,7 +203,11 @@ func RequestFiles(w http.ResponseWriter, r *http.Request) {
 	EDOC */
 func RequestFindFileInDb(w http.ResponseWriter, r *http.Request) {
 	fname := r.URL.Query().Get("filename")
-	if res, err := FindFileInDb(fname); err != nil {
+	res, err := FindFileInDb(fname)
+	if err == nil && !CanReadFile(GetUsername(r), res) {
+		err = fmt.Errorf("Could not find file: %s", fname)
+	}
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		msg := common.ResultMsg{Ok: false, Msg: err.Error()}
 		json.NewEncoder(w).Encode(msg)
@@ -214,7 +245,7 @@ func RequestGrep(w http.ResponseWriter, r *http.Request) {
 		del = ":"
 	}
 	w.Header().Set("Content-Type", "application/json")
-	if res, err := Grep(qry, del); err != nil {
+	if res, err := Grep(qry, del, FilterFiles(GetUsername(r), GetDb().GetFiles())); err != nil {
 		fmt.Printf("ERROR: %v\n", err)
 		json.NewEncoder(w).Encode([]string{})
 	} else {
@@ -222,6 +253,37 @@ func RequestGrep(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* SDOC: API
+* GET /textsearch — Ranked Full Text Search
+	Searches the full text index the server keeps over every heading. Unlike =/grep= this
+	does not read the files from disk and results are ranked with BM25, best first. See
+	the Full Text Search section for the query syntax (phrases, =prefix*=, =field:term=
+	and =-term=).
+
+	*Method:* =GET=
+
+	*Query Parameters:*
+	| Parameter | Type   | Required | Description                                      |
+	|-----------+--------+----------+--------------------------------------------------|
+	| =query=   | string | yes      | The search query.                                |
+	| =limit=   | int    | no       | Maximum number of results to return. Default 50. |
+
+	*Response:* A JSON array of =SearchResult= objects:
+	#+BEGIN_SRC json
+	[{"todo": {"Headline": "Release plan", "Filename": "/home/user/org/work.org", "LineNum": 12, "Hash": "..."},
+	  "score": 7.41, "snippet": "...agree the release plan with..."}]
+	#+END_SRC
+	EDOC */
+func RequestTextSearch(w http.ResponseWriter, r *http.Request) {
+	qry := r.URL.Query().Get("query")
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SearchText(qry, limit, GetUsername(r)))
+}
+
 /* SDOC: API
 * GET /orgfile — Read Raw Org File Contents
 	Reads the raw text content of an org file from disk and returns it as a string.
@@ -243,7 +305,12 @@ func RequestOrgFile(w http.ResponseWriter, r *http.Request) {
 	//vars := mux.Vars(r)
 	filename := r.URL.Query().Get("filename")
 	w.Header().Set("Content-Type", "application/json")
+	if !requireFileAccess(w, r, filename, AccessRead) {
+		return
+	}
+	filename, _ = ResolveOrgPath(filename)
 	if f, err := ioutil.ReadFile(filename); err == nil {
+		setETag(w, revisions.remember(filename, f))
 		msg := common.ResultMsg{Ok: true, Msg: string(f)}
 		json.NewEncoder(w).Encode(msg)
 	} else {
@@ -274,7 +341,8 @@ func RequestHeadings(w http.ResponseWriter, r *http.Request) {
 	fname := r.URL.Query().Get("filename")
 	res, _ := GetAllTodosInFile(fname)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	setRevisionHeader(w, GetDb().FindByFile(fname))
+	json.NewEncoder(w).Encode(FilterTodos(GetUsername(r), res))
 }
 
 /* SDOC: API
@@ -322,6 +390,8 @@ func RequestFilters(w http.ResponseWriter, r *http.Request) {
 	By default, the exported content is returned as a string in the response body.
 	When =local=t= is set, the exporter writes to the file specified by =filename=
 	instead and the response reports success/failure.
+	Exporters that record something in the org file itself, like =confluence=, have
+	to be run with =POST /file/{type}= instead.
 
 	*Method:* =GET=
 
@@ -361,11 +431,72 @@ func RequestFile(w http.ResponseWriter, r *http.Request) {
 	if httpslinks == "t" {
 		opts.Opts += "httpslinks;"
 	}
+	// Exporters that take a file are refused up front, ones that run a
+	// query only ever see the files the caller can read.
+	if GetDb().FindByFile(query) != nil && !requireFileAccess(w, r, query, AccessRead) {
+		return
+	}
+	if local == "t" && !requireFileAccess(w, r, fname, AccessWrite) {
+		return
+	}
+	udb := NewUserDb(GetUsername(r))
 	var res common.ResultMsg
 	if local == "t" {
-		res, _ = ExportToFile(db, &opts)
+		res, _ = ExportToFile(udb, &opts)
 	} else {
-		res, _ = ExportToString(db, &opts)
+		res, _ = ExportToString(udb, &opts)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+/* SDOC: API
+* POST /file/{type} — Export a File and Record the Result in It
+	Runs an exporter that writes back to the org file it exports, like =confluence=
+	recording the page it created on the heading. These exporters refuse to run
+	through =GET /file/{type}=. The user needs write access to the file, the change
+	can be undone and an =If-Match= header is checked against the file.
+
+	*Method:* =POST=
+
+	*Path Parameters:*
+	| Parameter | Type   | Description                                        |
+	|-----------+--------+----------------------------------------------------|
+	| ={type}=  | string | Name of the exporter plugin to use.                |
+
+	*Request Body (JSON):*
+	| Field      | Type   | Required | Description                                                   |
+	|------------+--------+----------+---------------------------------------------------------------|
+	| =Query=    | string | yes      | The org file to export.                                       |
+	| =Filename= | string | no       | Also write the result of the export to this file.             |
+	| =Opts=     | string | no       | Exporter options, e.g. =filelinks;=.                          |
+	| =Props=    | object | no       | Properties passed to the exporter, e.g. =parent= or =force=.  |
+
+	*Response:* A =ResultMsg= JSON object, as for =GET /file/{type}=.
+	EDOC */
+func PostFile(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var opts common.ExportToFile
+	if err := json.Unmarshal(body, &opts); err != nil {
+		fmt.Println("Export failed to deserialize", err, string(body))
+		json.NewEncoder(w).Encode(err)
+		return
+	}
+	opts.Name = mux.Vars(r)["type"]
+	if !requireFileAccess(w, r, opts.Query, AccessWrite) || !requireRevision(w, r, opts.Query) {
+		return
+	}
+	if opts.Filename != "" && !requireFileAccess(w, r, opts.Filename, AccessWrite) {
+		return
+	}
+	udb := NewUserDb(GetUsername(r))
+	udb.Writable = true
+	udb.Ctx = r.Context()
+	var res common.ResultMsg
+	if opts.Filename != "" {
+		res, _ = ExportToFile(udb, &opts)
+	} else {
+		res, _ = ExportToString(udb, &opts)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
@@ -428,6 +559,13 @@ func RequestTangle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	writeToDisk := r.URL.Query().Get("write") == "t"
+	level := AccessRead
+	if writeToDisk {
+		level = AccessWrite
+	}
+	if !requireFileAccess(w, r, query, level) {
+		return
+	}
 	result, err := tangle.Tangle(db, query, writeToDisk)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -458,6 +596,15 @@ func RequestRefileTargets(w http.ResponseWriter, r *http.Request) {
 	//query := r.URL.Query().Get("query")
 	//local := r.URL.Query().Get("local")
 	targets := GetRefileTargetsList([]string{})
+	if !HasFullAccess(GetUsername(r), AccessWrite) {
+		allowed := []string{}
+		for _, t := range targets {
+			if CanWriteFile(GetUsername(r), strings.SplitN(t, "|", 2)[0]) {
+				allowed = append(allowed, t)
+			}
+		}
+		targets = allowed
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(targets)
 }
@@ -480,6 +627,9 @@ func RequestRefileTargets(w http.ResponseWriter, r *http.Request) {
 func RequestFullFileHtml(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var hash common.TodoHash = common.TodoHash(h)
 		reply, err := QueryFullFileHtml(&hash)
 		if err == nil {
@@ -509,6 +659,9 @@ func RequestFullFileHtml(w http.ResponseWriter, r *http.Request) {
 func RequestFullTodoHtml(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var hash common.TodoHash = common.TodoHash(h)
 		reply, err := QueryFullTodoHtml(&hash)
 		if err == nil {
@@ -539,9 +692,14 @@ func RequestFullTodoHtml(w http.ResponseWriter, r *http.Request) {
 func RequestFullTodo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var hash common.TodoHash = common.TodoHash(string(h))
 		reply, err := QueryFullTodo(&hash)
 		if err == nil {
+			_, f := GetDb().LookupHash(h)
+			setRevisionHeader(w, f)
 			json.NewEncoder(w).Encode(reply)
 		} else {
 			fmt.Println("ERROR during request", err)
@@ -586,11 +744,14 @@ func CreateFile(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "Filename is required"})
 		return
 	}
+	if !requireFileAccess(w, r, req.Filename, AccessWrite) {
+		return
+	}
 	title := req.Title
 	if title == "" {
 		title = strings.TrimSuffix(filepath.Base(req.Filename), filepath.Ext(req.Filename))
 	}
-	file := GetDb().CreateOrgFileFromTemplate(req.Filename, title, req.Template)
+	file := GetDb().CreateOrgFileFromTemplate(r.Context(), req.Filename, title, req.Template)
 	if file != nil {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(common.FileList{file.Filename})
@@ -615,12 +776,14 @@ func CreateFile(w http.ResponseWriter, r *http.Request) {
 func RequestDirs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	dirSet := map[string]bool{}
-	files := GetDb().GetFiles()
+	files := FilterFiles(GetUsername(r), GetDb().GetFiles())
 	for _, f := range files {
 		dirSet[filepath.Dir(f)] = true
 	}
-	for _, d := range Conf().Server.OrgDirs {
-		dirSet[d] = true
+	if HasFullAccess(GetUsername(r), AccessRead) {
+		for _, d := range Conf().Server.OrgDirs {
+			dirSet[d] = true
+		}
 	}
 	dirs := make([]string, 0, len(dirSet))
 	for d := range dirSet {
@@ -664,6 +827,9 @@ func RequestNewTemplates(w http.ResponseWriter, r *http.Request) {
 	Changes the TODO/DONE status keyword of a heading identified by its hash.
 	The new status value must be a valid keyword for the file (as defined by the
 	file's =#+TODO= line or the server's =defaultTodoStates= / =defaultNextStates=).
+	Moving into a DONE state adds a =CLOSED:= timestamp and the change is logged to the
+	logbook when the keywords ask for it (see logDone). Repeating tasks are moved on to
+	their next date instead of being left DONE.
 
 	*Method:* =POST=
 
@@ -672,6 +838,7 @@ func RequestNewTemplates(w http.ResponseWriter, r *http.Request) {
 	|---------+--------+----------+--------------------------------------------------------------|
 	| =Hash=  | string | yes      | The dynamic hash identifying the heading.                    |
 	| =Value= | string | yes      | The new status keyword (e.g. =DONE=, =TODO=, =NEXT=, =""=). |
+	| =Note=  | string | no       | A note to log with the change.                               |
 
 	*Response:* A =Result= JSON object with ={"status": true}= on success.
 	EDOC */
@@ -680,8 +847,11 @@ func PostChangeStatus(w http.ResponseWriter, r *http.Request) {
 	var args common.TodoItemChange
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = ChangeStatus(&args)
+		reply, err = ChangeStatus(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -712,8 +882,11 @@ func PostRenameHeadline(w http.ResponseWriter, r *http.Request) {
 	var args common.TodoItemChange
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = RenameHeadline(&args)
+		reply, err = RenameHeadline(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -744,8 +917,11 @@ func PostChangeBody(w http.ResponseWriter, r *http.Request) {
 	var args common.TodoItemChange
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = ChangeBody(&args)
+		reply, err = ChangeBody(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -777,8 +953,11 @@ func PostChangeDate(w http.ResponseWriter, r *http.Request) {
 	var args common.TodoDateChange
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = ChangeDate(&args)
+		reply, err = ChangeDate(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -810,8 +989,11 @@ func DeleteDate(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		args.Value = ""
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = ChangeDate(&args)
+		reply, err = ChangeDate(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -846,8 +1028,11 @@ func PostChangeProperty(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("Deserialized")
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = ChangeProperty(&args)
+		reply, err = ChangeProperty(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -882,8 +1067,11 @@ func PostToggleTags(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("Deserialized")
+		if !requireHashAccess(w, r, args.Hash, AccessWrite) || !requireHashRevision(w, r, args.Hash) {
+			return
+		}
 		var reply common.Result
-		reply, err = ToggleTag(&args)
+		reply, err = ToggleTag(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -917,8 +1105,13 @@ func PostReformat(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("Deserialized")
+		for _, f := range args {
+			if !requireFileAccess(w, r, f, AccessWrite) || !requireRevision(w, r, f) {
+				return
+			}
+		}
 		var reply common.Result
-		reply, err = Reformat(&args)
+		reply, err = Reformat(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -951,6 +1144,7 @@ func RequestTodosExpr(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("query")
 	var args common.StringQuery
 	args.Query = query
+	args.User = GetUsername(r)
 	reply, err := QueryStringTodos(&args)
 	w.Header().Set("Content-Type", "application/json")
 	if err == nil {
@@ -985,6 +1179,9 @@ func RequestHash(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode("Failed to convert position value")
 		return
 	}
+	if !requireFileAccess(w, r, fname, AccessRead) {
+		return
+	}
 	reply, err := FindNodeInFile(pos, fname)
 	if err == nil {
 		json.NewEncoder(w).Encode(reply)
@@ -1012,6 +1209,9 @@ func RequestByHash(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	w.Header().Set("Content-Type", "application/json")
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var hash common.TodoHash = common.TodoHash(h)
 		var err error = nil
 		res := FindByHash(&hash)
@@ -1021,6 +1221,8 @@ func RequestByHash(w http.ResponseWriter, r *http.Request) {
 			}
 			json.NewEncoder(w).Encode(fmt.Sprintf("could not find hash %s %s", hash, err))
 		} else {
+			_, f := GetDb().LookupHash(h)
+			setRevisionHeader(w, f)
 			json.NewEncoder(w).Encode(res)
 		}
 	} else {
@@ -1048,6 +1250,9 @@ func RequestByAnyId(w http.ResponseWriter, r *http.Request) {
 	var hash common.TodoHash = common.TodoHash(vars["id"])
 	var err error = nil
 	res := FindByAnyId(&hash)
+	if res != nil && !CanReadFile(GetUsername(r), res.Filename) {
+		res = nil
+	}
 
 	if res == nil || err != nil {
 		if err == nil {
@@ -1076,7 +1281,10 @@ func RequestByAnyId(w http.ResponseWriter, r *http.Request) {
 	EDOC */
 func PostCreateDayPage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	res, err := CreateDayPage()
+	if fname, _ := getDayPageFilename(time.Now()); !requireFileAccess(w, r, fname, AccessWrite) {
+		return
+	}
+	res, err := CreateDayPage(r.Context())
 	if res == nil || err != nil {
 		if err == nil {
 			err = fmt.Errorf("")
@@ -1107,6 +1315,9 @@ func RequestDayPageAt(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	var args common.Date = common.Date(vars["date"])
 	res, err := GetDayPageAt(&args)
+	if err == nil && len(res) > 0 && !requireFileAccess(w, r, res[0], AccessRead) {
+		return
+	}
 	if res == nil || err != nil {
 		if err == nil {
 			err = fmt.Errorf("")
@@ -1205,8 +1416,11 @@ func PostCapture(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if temp := FindCaptureTemplate(args.Template, username); temp != nil && !requireTargetRevision(w, r, &temp.CapTarget) {
+			return
+		}
 		var reply common.ResultMsg
-		reply, err = Capture(db, &args, username)
+		reply, err = Capture(r.Context(), db, &args, username)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1239,6 +1453,7 @@ func RequestMarker(w http.ResponseWriter, r *http.Request) {
 	// This a parameter rather than path
 	args := r.URL.Query().Get("name")
 	res, err := GetMarkerTag(args)
+	res = FilterTodos(GetUsername(r), res)
 	if err != nil {
 		Log().Errorf("GetMarkerErr: %s", err.Error())
 	}
@@ -1278,8 +1493,11 @@ func PostMarker(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if !requireTargetAccess(w, r, &args.ToId, AccessWrite) {
+			return
+		}
 		var reply common.Result
-		reply, err = SetMarkerTag(&args)
+		reply, err = SetMarkerTag(r.Context(), &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1317,8 +1535,11 @@ func PostDelete(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if !requireTargetAccess(w, r, &args, AccessWrite) || !requireTargetRevision(w, r, &args) {
+			return
+		}
 		var reply common.ResultMsg
-		reply, err = Delete(db, &args)
+		reply, err = Delete(r.Context(), db, &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1355,8 +1576,14 @@ func PostUpdate(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if !requireTargetAccess(w, r, &args.Target, AccessWrite) || !requireTargetRevision(w, r, &args.Target) {
+			return
+		}
+		udb := NewUserDb(GetUsername(r))
+		udb.Writable = true
+		udb.Ctx = r.Context()
 		var reply common.ResultMsg
-		reply, err = PluginUpdateTarget(db, &args.Target, args.Name)
+		reply, err = PluginUpdateTarget(udb, &args.Target, args.Name)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1392,8 +1619,12 @@ func PostRefile(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if !requireTargetAccess(w, r, &args.FromId, AccessWrite) || !requireTargetAccess(w, r, &args.ToId, AccessWrite) ||
+			!requireTargetRevision(w, r, &args.FromId) || !requireTargetRevision(w, r, &args.ToId) {
+			return
+		}
 		var reply common.ResultMsg
-		reply, err = Refile(db, &args, nil, false)
+		reply, err = Refile(r.Context(), db, &args, nil, false)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1410,7 +1641,8 @@ func PostRefile(w http.ResponseWriter, r *http.Request) {
 * POST /archive — Archive a Heading
 	Archives the heading identified by the target. The heading is moved from its current
 	file into the corresponding =_archive= file (e.g. =todo.org_archive=) following
-	standard Org mode archiving conventions. The original file is re-saved.
+	standard Org mode archiving conventions. The original file is re-saved. The user needs
+	write access to both the heading's file and the archive file.
 
 	*Method:* =POST=
 
@@ -1421,6 +1653,8 @@ func PostRefile(w http.ResponseWriter, r *http.Request) {
 	| =Id=       | string | varies   | The identifier.                                                  |
 	| =Type=     | string | yes      | One of =file+headline=, =id=, =customid=, =hash=, =file+line=.  |
 
+	The destination comes from the ARCHIVE property, see Archiving.
+
 	*Response:* A =ResultMsg= JSON object.
 	EDOC */
 func PostArchive(w http.ResponseWriter, r *http.Request) {
@@ -1430,8 +1664,15 @@ func PostArchive(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if !requireTargetAccess(w, r, &args, AccessWrite) || !requireTargetRevision(w, r, &args) {
+			return
+		}
+		// The heading ends up in the archive file, that has to be writable too.
+		if at := FindArchiveTarget(db, &args); at != nil && (!requireFileAccess(w, r, at.Filename, AccessWrite) || !requireRevision(w, r, at.Filename)) {
+			return
+		}
 		var reply common.ResultMsg
-		reply, err = Archive(db, &args)
+		reply, err = Archive(r.Context(), db, &args)
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1444,11 +1685,182 @@ func PostArchive(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* SDOC: API
+* POST /archive/tag — Toggle the ARCHIVE Tag
+	Adds the =:ARCHIVE:= tag to the heading identified by the target, or removes it
+	if it is already there. The heading stays where it is.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):* A =Target= object identifying the heading.
+
+	*Response:* A =ResultMsg= JSON object, =Msg= is =tagged= or =untagged=.
+	EDOC */
+func PostArchiveTag(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var args common.Target
+	var err = json.Unmarshal(body, &args)
+	if err == nil {
+		if !requireTargetAccess(w, r, &args, AccessWrite) || !requireTargetRevision(w, r, &args) {
+			return
+		}
+		reply, _ := ToggleArchiveTag(r.Context(), db, &args)
+		json.NewEncoder(w).Encode(reply)
+	} else {
+		fmt.Println("Archive tag failed to deserialize", err, string(body))
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
+/* SDOC: API
+* POST /archive/sibling — Archive to a Sibling
+	Moves the heading identified by the target under its =Archive= sibling, which is
+	created with an =:ARCHIVE:= tag when needed. See Archiving.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):* A =Target= object identifying the heading to archive.
+
+	*Response:* A =ResultMsg= JSON object.
+	EDOC */
+func PostArchiveSibling(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var args common.Target
+	var err = json.Unmarshal(body, &args)
+	if err == nil {
+		if !requireTargetAccess(w, r, &args, AccessWrite) || !requireTargetRevision(w, r, &args) {
+			return
+		}
+		reply, _ := ArchiveToSibling(r.Context(), db, &args)
+		json.NewEncoder(w).Encode(reply)
+	} else {
+		fmt.Println("Archive sibling failed to deserialize", err, string(body))
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
+/* SDOC: API
+* POST /archive/bulk — Archive Old Done Headings
+	Archives every DONE heading that matches the query and was closed more than
+	=days= days ago. Headings in files the user cannot write are left alone, headings
+	whose archive file the user cannot write are reported in =failed=.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field    | Type   | Required | Description                                                    |
+	|----------+--------+----------+----------------------------------------------------------------|
+	| =query=  | string | no       | Query selecting the headings, defaults to the unarchived done ones. |
+	| =days=   | int    | no       | Only archive headings closed more than this many days ago.     |
+	| =mode=   | string | no       | =archive= (default), =sibling= or =tag=.                       |
+	| =dryRun= | bool   | no       | Return what would be archived without changing anything.       |
+
+	*Response:* An =ArchiveBulkResult= JSON object listing the =archived= and =failed=
+	headings as =file::outline path=.
+	EDOC */
+func PostArchiveBulk(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var args common.ArchiveBulkRequest
+	var err = json.Unmarshal(body, &args)
+	if err == nil {
+		reply, _ := ArchiveBulk(r.Context(), db, &args, GetUsername(r))
+		json.NewEncoder(w).Encode(reply)
+	} else {
+		fmt.Println("Archive bulk failed to deserialize", err, string(body))
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
+/* SDOC: API
+* POST /undo — Undo the Last Change
+	Puts the files changed by the last request of the current user back the way
+	they were. Refused if any of those files has changed since. See Undo and Redo.
+
+	*Method:* =POST=
+
+	*Request Body:* None.
+
+	*Response:* A =ResultMsg= JSON object naming the request that was undone.
+	EDOC */
+func PostUndo(w http.ResponseWriter, r *http.Request) {
+	reply, _ := GetJournal().Undo(GetUsername(r))
+	json.NewEncoder(w).Encode(reply)
+}
+
+/* SDOC: API
+* POST /redo — Redo the Last Undone Change
+	Applies the last change undone by the current user again.
+
+	*Method:* =POST=
+
+	*Request Body:* None.
+
+	*Response:* A =ResultMsg= JSON object naming the request that was redone.
+	EDOC */
+func PostRedo(w http.ResponseWriter, r *http.Request) {
+	reply, _ := GetJournal().Redo(GetUsername(r))
+	json.NewEncoder(w).Encode(reply)
+}
+
+/* SDOC: API
+* GET /journal — List Undoable Changes
+	Returns the changes the current user can undo, newest first, followed by the
+	ones they can redo.
+
+	*Method:* =GET=
+
+	*Response:* A JSON array of =JournalEntry= objects.
+	| Field    | Type     | Description                                       |
+	|----------+----------+---------------------------------------------------|
+	| =id=     | int      | Increasing number of the change.                  |
+	| =op=     | string   | The request that made the change, =POST /refile= |
+	| =time=   | string   | When the change was made.                         |
+	| =files=  | []string | The files it changed.                             |
+	| =undone= | bool     | True if the change has been undone and can be redone. |
+	EDOC */
+func RequestJournal(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(GetJournal().List(GetUsername(r)))
+}
+
+/* SDOC: API
+* GET /revision — Get the Revision of a File
+	Returns the current revision of an org file, the value to send in =If-Match=
+	with an edit. The revision is also set as the =ETag= header. See Revisions.
+
+	*Method:* =GET=
+
+	*Parameters:*
+	| Name       | Type   | Required | Description            |
+	|------------+--------+----------+------------------------|
+	| =filename= | string | yes      | The org file to check. |
+
+	*Response:* A =ResultMsg= JSON object with the revision in =Msg=.
+	EDOC */
+func RequestRevision(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	w.Header().Set("Content-Type", "application/json")
+	if !requireFileAccess(w, r, filename, AccessRead) {
+		return
+	}
+	filename, _ = ResolveOrgPath(filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: err.Error()})
+		return
+	}
+	rev := revisions.remember(filename, data)
+	setETag(w, rev)
+	json.NewEncoder(w).Encode(common.ResultMsg{Ok: true, Msg: rev})
+}
+
 /* SDOC: API
 * GET /clock — Get Current Clock Status
-	Returns the current clocking state. If a heading is actively being clocked,
-	the response includes the start time, the target heading, and =Active: true=.
-	If no clock is running, =Active= is =false=.
+	Returns the clocking state of the calling user. If a heading is actively being
+	clocked, the response includes the start time, the target heading, and =Active: true=.
+	If no clock is running, =Active= is =false=. =Paused= lists the tasks waiting on
+	the interrupt stack, the last one is resumed first. =Dangling= is =true= if the
+	clock has run past clockIdleThreshold and must be resolved, =LastSeen= is when
+	the server last saw it running.
 
 	*Method:* =GET=
 
@@ -1459,34 +1871,83 @@ func PostArchive(w http.ResponseWriter, r *http.Request) {
 	{
 	  "Active": true,
 	  "Time": { "start": "...", "end": "..." },
-	  "Target": { "Filename": "...", "Id": "...", "Type": "..." }
+	  "Target": { "Filename": "...", "Id": "...", "Type": "..." },
+	  "Paused": [ { "Filename": "...", "Id": "...", "Type": "..." } ],
+	  "Dangling": false,
+	  "LastSeen": "..."
 	}
 	#+END_SRC
 	EDOC */
 func RequestClock(w http.ResponseWriter, r *http.Request) {
 	type ClockData struct {
-		Active bool
-		Time   org.OrgDate
-		Target common.Target
+		Active   bool
+		Time     org.OrgDate
+		Target   common.Target
+		Paused   []common.Target
+		Dangling bool
+		LastSeen time.Time
 	}
 	data := ClockData{}
-	active := Clock().IsClockActive()
+	user := GetUsername(r)
+	clk := Clocks().User(user).State()
+	active := clk.active()
+	if active && !CanReadTarget(user, clk.Target) {
+		active = false
+	}
 	data.Active = active
 	if active {
-		data.Time = *Clock().GetTime()
-		data.Target = *Clock().GetTarget()
+		data.Time = *clk.Time
+		data.Target = *clk.Target
+		data.Dangling = clk.Dangling
+		data.LastSeen = clk.LastSeen
+		for _, t := range clk.Stack {
+			if CanReadTarget(user, t) {
+				data.Paused = append(data.Paused, *t)
+			}
+		}
 	}
 	json.NewEncoder(w).Encode(data)
 }
 
+/* SDOC: API
+* GET /clock/history — Recently Clocked Tasks
+	Returns the tasks the calling user has clocked into most recently, newest first,
+	so they can be clocked into again quickly. Each entry holds the =target= to
+	post to =/clockin=, the =headline=, =filename=, =lastClocked= time and the
+	=mins= of the last completed clock on it.
+
+	*Method:* =GET=
+
+	*Parameters:* None.
+
+	*Response:* A JSON array of =ClockHistoryEntry= objects.
+	EDOC */
+func RequestClockHistory(w http.ResponseWriter, r *http.Request) {
+	user := GetUsername(r)
+	hist := []common.ClockHistoryEntry{}
+	for _, h := range Clocks().GetHistory(user) {
+		if CanReadTarget(user, &h.Target) {
+			hist = append(hist, h)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hist)
+}
+
 /* SDOC: API
 * POST /clockin — Clock In to a Heading
 	Starts a clock on the heading identified by the target. If another heading is
 	currently clocked in, it is automatically clocked out first. A =CLOCK:= entry
-	with the start time is added to the heading's logbook drawer.
+	with the start time is added to the heading's logbook drawer. Each user has
+	their own clock.
 
 	*Method:* =POST=
 
+	*Parameters:*
+	| Parameter   | Required | Description                                                        |
+	|-------------+----------+--------------------------------------------------------------------|
+	| =interrupt= | no       | If =true= the running task is paused and resumed on clock out.     |
+
 	*Request Body (JSON):* A =Target= object identifying the heading to clock into.
 	| Field      | Type   | Required | Description                                                      |
 	|------------+--------+----------+------------------------------------------------------------------|
@@ -1503,8 +1964,16 @@ func PostClockIn(w http.ResponseWriter, r *http.Request) {
 	var err = json.Unmarshal(body, &args)
 	if err == nil {
 		fmt.Println("  Deserialized", args)
+		if !requireTargetAccess(w, r, &args, AccessWrite) {
+			return
+		}
 		var reply common.ResultMsg
-		reply, err = Clock().ClockIn(&args)
+		clk := Clocks().User(GetUsername(r))
+		if r.URL.Query().Get("interrupt") == "true" {
+			reply, err = clk.Interrupt(r.Context(), &args)
+		} else {
+			reply, err = clk.ClockIn(r.Context(), &args)
+		}
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1519,8 +1988,9 @@ func PostClockIn(w http.ResponseWriter, r *http.Request) {
 
 /* SDOC: API
 * POST /clockout — Clock Out
-	Stops the currently active clock. The end time is recorded on the open =CLOCK:=
-	entry and the duration is calculated. If no clock is active, this is a no-op.
+	Stops the calling user's active clock. The end time is recorded on the open =CLOCK:=
+	entry and the duration is calculated. If the task was an interruption the task it
+	paused is clocked back in. If no clock is active, this is a no-op.
 
 	*Method:* =POST=
 
@@ -1532,8 +2002,12 @@ func PostClockOut(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("PostClockOut")
 	body, err := io.ReadAll(r.Body)
 	if err == nil {
+		clk := Clocks().User(GetUsername(r))
+		if clk.IsClockActive() && !requireTargetAccess(w, r, clk.GetTarget(), AccessWrite) {
+			return
+		}
 		var reply common.ResultMsg
-		reply, err = Clock().ClockOut()
+		reply, err = clk.ClockOut(r.Context())
 		if err == nil {
 			json.NewEncoder(w).Encode(reply)
 		} else {
@@ -1546,6 +2020,39 @@ func PostClockOut(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* SDOC: API
+* POST /clock/resolve — Resolve a Dangling Clock
+	Resolves the calling user's running clock, normally one that has been flagged
+	as dangling. See Dangling Clocks. The decision is noted in the logbook drawer.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field      | Type   | Required | Description                                                |
+	|------------+--------+----------+------------------------------------------------------------|
+	| =action=   | string | yes      | One of =keep=, =subtract=, =clockout= or =cancel=.         |
+	| =idleMins= | int    | varies   | Idle minutes to drop from the end, for subtract/clockout.  |
+	| =at=       | string | varies   | Or the time the clock should have stopped.                 |
+
+	*Response:* A =ResultMsg= JSON object.
+	EDOC */
+func PostClockResolve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var args common.ClockResolveRequest
+	var err = json.Unmarshal(body, &args)
+	if err == nil {
+		clk := Clocks().User(GetUsername(r))
+		if clk.IsClockActive() && !requireTargetAccess(w, r, clk.GetTarget(), AccessWrite) {
+			return
+		}
+		reply, _ := clk.Resolve(r.Context(), &args, time.Now())
+		json.NewEncoder(w).Encode(reply)
+	} else {
+		fmt.Println("ClockResolve to deserialize", err, string(body))
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
 /* SDOC: API
 * GET /clockreport — Generate a Clock Report
 	Generates a summary of clocked time across all headings for the specified time block.
@@ -1567,6 +2074,17 @@ func RequestClockReport(w http.ResponseWriter, r *http.Request) {
 		block = "today"
 	}
 	report := GenerateClockReport(block)
+	if !HasFullAccess(GetUsername(r), AccessRead) {
+		entries := []common.ClockEntry{}
+		report.TotalMin = 0
+		for _, e := range report.Entries {
+			if CanReadFile(GetUsername(r), e.Filename) {
+				entries = append(entries, e)
+				report.TotalMin += e.Mins
+			}
+		}
+		report.Entries = entries
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(report)
 }
@@ -1598,7 +2116,10 @@ func RequestLogbook(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
 		hash := string(h)
-		if s, ok := GetDb().ByHash[hash]; ok {
+		if !requireHashAccess(w, r, hash, AccessRead) {
+			return
+		}
+		if s, _ := GetDb().LookupHash(hash); s != nil {
 			var logbook common.Logbook
 			drawer := s.Headline.FindDrawer(Conf().ClockIntoDrawer)
 			if drawer != nil && drawer.Children != nil {
@@ -1629,6 +2150,141 @@ func RequestLogbook(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* SDOC: API
+* GET /columns/{hash} — Get the Column View of a Heading
+	Returns the column view of a heading and every heading under it, with
+	the values of each column rolled up the tree by its summary operator.
+	See Column View.
+
+	*Method:* =GET=
+
+	*Path Parameters:*
+	| Parameter | Type   | Description                                              |
+	|-----------+--------+----------------------------------------------------------|
+	| ={hash}=  | string | Base64-URL-encoded hash of the heading.                  |
+
+	*Query Parameters:*
+	| Parameter | Required | Description                                                   |
+	|-----------+----------+---------------------------------------------------------------|
+	| =columns= | no       | A COLUMNS definition to use instead of the one for the heading. |
+
+	*Response:* A =ColumnView= JSON object:
+	#+BEGIN_SRC json
+	{
+	  "columns": [ {"property": "Effort", "title": "Estimate", "width": 0, "summary": ":"} ],
+	  "rows": [
+	    {"hash": "...", "headline": "Project", "level": 1, "values": ["3:30"],
+	     "effortMins": 210, "clockedMins": 90, "progress": 43}
+	  ]
+	}
+	#+END_SRC
+	Returns =404= if the hash is not found, =400= on an invalid hash or COLUMNS definition.
+	EDOC */
+func RequestColumns(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if h, err := GetHash(vars, "hash"); err == nil {
+		hash := string(h)
+		if !requireHashAccess(w, r, hash, AccessRead) {
+			return
+		}
+		if s, f := GetDb().LookupHash(hash); s != nil {
+			view, err := GetColumnView(s, f, r.URL.Query().Get("columns"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(view)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		}
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
+/* SDOC: API
+* GET /checkbox/{hash} — List the Checkboxes of a Heading
+	Returns the checkbox list items in the body of a heading in file order.
+	The =row= of an item can be passed to =/checkbox/toggle=.
+
+	*Method:* =GET=
+
+	*Path Parameters:*
+	| Parameter | Type   | Description                                              |
+	|-----------+--------+----------------------------------------------------------|
+	| ={hash}=  | string | Base64-URL-encoded hash of the heading.                  |
+
+	*Response:* A JSON array of =CheckboxItem= objects:
+	#+BEGIN_SRC json
+	[ {"row": 12, "status": "X", "text": "Tent", "depth": 0} ]
+	#+END_SRC
+	Returns =404= if the hash is not found, =400= on invalid hash encoding.
+	EDOC */
+func RequestCheckboxes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if h, err := GetHash(vars, "hash"); err == nil {
+		hash := string(h)
+		if !requireHashAccess(w, r, hash, AccessRead) {
+			return
+		}
+		if s, _ := GetDb().LookupHash(hash); s != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(ListCheckboxes(s))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		}
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
+/* SDOC: API
+* POST /checkbox/toggle — Toggle a Checkbox
+	Toggles the checkbox on a list item. The item is found by a =PreciseTarget=,
+	a =Target= for the heading and the =Row= of the item in the file. Parent items
+	and the statistics cookies of the items and the heading are updated to match.
+	See Checkboxes.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field    | Type   | Required | Description                                     |
+	|----------+--------+----------+-------------------------------------------------|
+	| =Target= | Target | yes      | A =Target= identifying the heading.             |
+	| =Row=    | int    | yes      | The row of the list item in the file.           |
+
+	*Response:* A =ResultMsg= JSON object, =Msg= holds the new status of the item.
+	EDOC */
+func PostCheckboxToggle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var args common.PreciseTarget
+	var err = json.Unmarshal(body, &args)
+	if err == nil {
+		if !requireTargetAccess(w, r, &args.Target, AccessWrite) || !requireTargetRevision(w, r, &args.Target) {
+			return
+		}
+		reply := common.ResultMsg{Ok: false, Msg: "Could not find target"}
+		if ofile, sec := GetDb().GetFromTarget(&args.Target, false); sec != nil {
+			if status, err := ToggleCheckbox(sec, ofile, args.Row); err == nil {
+				WriteOutOrgFile(r.Context(), ofile, sec.Hash)
+				reply = common.ResultMsg{Ok: true, Msg: status}
+			} else {
+				reply.Msg = err.Error()
+			}
+		}
+		json.NewEncoder(w).Encode(reply)
+	} else {
+		fmt.Println("CheckboxToggle to deserialize", err, string(body))
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
 /* SDOC: API
 * POST /execb — Execute a Source Block
 	Executes the source block at the specified position in an org file. The block is
@@ -1644,6 +2300,9 @@ func RequestLogbook(w http.ResponseWriter, r *http.Request) {
 	| =Target=       | Target  | yes      | Identifies the heading containing the block.               |
 	| =Row=          | int     | yes      | Line offset within the heading to locate the block.        |
 
+	Source blocks are only executed when babelEnabled is set, the language is
+	listed in babelLanguages and the caller is an admin.
+
 	*Response:* A =ResultMsg= JSON object. On success, =msg= contains the execution result.
 	EDOC */
 func PostExecb(w http.ResponseWriter, r *http.Request) {
@@ -1653,8 +2312,11 @@ func PostExecb(w http.ResponseWriter, r *http.Request) {
 		var args common.PreciseTarget
 		var err = json.Unmarshal(body, &args)
 		if err == nil {
+			if !requireTargetAccess(w, r, &args.Target, AccessWrite) || !requireTargetRevision(w, r, &args.Target) {
+				return
+			}
 			var reply common.ResultMsg
-			reply, err = ExecBlock(db, &args)
+			reply, err = ExecBlock(r.Context(), db, &args, GetUsername(r))
 			if err == nil {
 				json.NewEncoder(w).Encode(reply)
 			} else {
@@ -1695,7 +2357,12 @@ func RequestTableRandomGet(w http.ResponseWriter, r *http.Request) {
 	name = strings.TrimSpace(name)
 	var rep common.ResultMsg
 	if name != "" {
-		tables := GetDb().GetNamedTables(name)
+		tables := []*TableFile{}
+		for _, t := range GetDb().GetNamedTables(name) {
+			if t.File != nil && CanReadFile(GetUsername(r), t.File.Filename) {
+				tables = append(tables, t)
+			}
+		}
 		if len(tables) > 0 {
 			table := tables[0]
 			max := len(table.Table.Rows)
@@ -1725,6 +2392,162 @@ func RequestTableRandomGet(w http.ResponseWriter, r *http.Request) {
 	//json.NewEncoder(w).Encode(data)
 }
 
+// The named table a request is after, the first one the user can read
+// unless a filename picks a particular one.
+func findRequestTable(r *http.Request, name string, filename string, level AccessLevel) *TableFile {
+	for _, t := range GetDb().GetNamedTables(name) {
+		if t.File == nil || (filename != "" && t.File.Filename != filename) {
+			continue
+		}
+		if FileAccess(GetUsername(r), t.File.Filename) >= level {
+			return t
+		}
+	}
+	return nil
+}
+
+/* SDOC: API
+* GET /table/export — Export a Named Table
+	Returns the rows of a named table as CSV, TSV or JSON, see Import and Export.
+
+	*Method:* =GET=
+
+	*Query Parameters:*
+	| Parameter  | Type   | Required | Description                                          |
+	|------------+--------+----------+------------------------------------------------------|
+	| =name=     | string | yes      | The =#+NAME:= of the table.                          |
+	| =format=   | string | no       | =csv= (the default), =tsv= or =json=.                |
+	| =filename= | string | no       | The org file to take the table from if several files have a table with that name. |
+
+	*Response:* The table in the requested format, with the revision of the file in the =ETag= header.
+
+	*Errors:*
+	- =400= if =name= is missing or the format is unknown.
+	- =404= if there is no table with that name.
+	EDOC */
+func RequestTableExport(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "name parameter required"})
+		return
+	}
+	t := findRequestTable(r, name, r.URL.Query().Get("filename"), AccessRead)
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: fmt.Sprintf("Failed to find table with name %s", name)})
+		return
+	}
+	data, err := ExportTable(t.Table, format)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", tableContentType(format))
+	setRevisionHeader(w, t.File)
+	w.Write(data)
+}
+
+/* SDOC: API
+* POST /table/import — Import Rows into a Named Table
+	Replaces the rows of a named table, or appends to them, from CSV, TSV or JSON.
+	The =#+TBLFM:= lines of the table are kept and its formulas are run again,
+	see Import and Export.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):* A =TableImportRequest= object.
+	| Field      | Type   | Required | Description                                              |
+	|------------+--------+----------+----------------------------------------------------------|
+	| =name=     | string | yes      | The =#+NAME:= of the table.                              |
+	| =filename= | string | no       | The org file holding the table if several files have a table with that name. |
+	| =format=   | string | no       | =csv= (the default), =tsv= or =json=.                    |
+	| =data=     | string | yes      | The rows to import.                                      |
+	| =append=   | bool   | no       | Add the rows to the end of the table instead of replacing them. |
+	| =header=   | bool   | no       | The first CSV or TSV record is a header.                 |
+
+	#+BEGIN_SRC json
+	{"name": "Prices", "format": "csv", "header": true, "data": "Item,Price\nBread,4.50\n"}
+	#+END_SRC
+
+	*Response:* A =ResultMsg= JSON object.
+	EDOC */
+func PostTableImport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		var args common.TableImportRequest
+		var err = json.Unmarshal(body, &args)
+		if err == nil {
+			args.Format = strings.ToLower(args.Format)
+			t := findRequestTable(r, strings.TrimSpace(args.Name), args.Filename, AccessRead)
+			if t == nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: fmt.Sprintf("Failed to find table with name %s", args.Name)})
+				return
+			}
+			if !requireFileAccess(w, r, t.File.Filename, AccessWrite) || !requireRevision(w, r, t.File.Filename) {
+				return
+			}
+			reply, _ := ImportTable(r.Context(), t, &args)
+			json.NewEncoder(w).Encode(reply)
+		} else {
+			fmt.Println("TableImport failed to deserialize", err, string(body))
+			rep := common.ResultMsg{Ok: false, Msg: err.Error()}
+			json.NewEncoder(w).Encode(rep)
+		}
+	} else {
+		fmt.Println("TableImport failed to read body", err)
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
+/* SDOC: API
+* POST /table/op — Change the Structure of a Table
+	Sorts a table, inserts, deletes or moves rows and columns, or lines the
+	columns up, fixing the references in the =#+TBLFM:= lines to match. See
+	Table Operations. The file is re-saved to disk after the change.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):* A =TableOpRequest= object.
+	| Field    | Type          | Required | Description                                              |
+	|----------+---------------+----------+----------------------------------------------------------|
+	| =table=  | PreciseTarget | yes      | Identifies the table, as for =/exectable=.               |
+	| =op=     | string        | yes      | =sort=, =insertrow=, =deleterow=, =moverow=, =insertcol=, =deletecol=, =movecol= or =align=. |
+	| =row=    | int           | no       | The row to work on, numbered like =@r=.                  |
+	| =col=    | int           | no       | The column to work on, numbered like =$c=.               |
+	| =to=     | int           | no       | Where =moverow= and =movecol= move to.                   |
+	| =sort=   | string        | no       | =a=, =n=, =t= or =c=, upper case to sort in reverse.     |
+	| =order=  | []string      | no       | The order of the values for a custom sort.               |
+	| =values= | []string      | no       | The cells of an inserted row or column.                  |
+
+	*Response:* A =ResultMsg= JSON object, =msg= holds the new table and its formulas
+	and =pos= and =end= the lines they replaced.
+	EDOC */
+func PostTableOp(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		var args common.TableOpRequest
+		var err = json.Unmarshal(body, &args)
+		if err == nil {
+			if !requireTargetAccess(w, r, &args.Table.Target, AccessWrite) || !requireTargetRevision(w, r, &args.Table.Target) {
+				return
+			}
+			reply, _ := TableOpAt(r.Context(), db, &args)
+			json.NewEncoder(w).Encode(reply)
+		} else {
+			fmt.Println("TableOp failed to deserialize", err, string(body))
+			rep := common.ResultMsg{Ok: false, Msg: err.Error()}
+			json.NewEncoder(w).Encode(rep)
+		}
+	} else {
+		fmt.Println("TableOp failed to read body", err)
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
 /* SDOC: API
 * GET /tablenames — List All Named Tables
 	Returns the names of all tables across all org files that have a =#+NAME:= keyword.
@@ -1753,8 +2576,13 @@ func RequestTableNames(w http.ResponseWriter, r *http.Request) {
 	tables := GetDb().GetTableNames()
 	if len(tables) > 0 {
 		keys := make([]string, 0, len(tables))
-		for k := range tables {
-			keys = append(keys, k)
+		for k, list := range tables {
+			for _, t := range list {
+				if t.File != nil && CanReadFile(GetUsername(r), t.File.Filename) {
+					keys = append(keys, k)
+					break
+				}
+			}
 		}
 		rep = ResultTableNames{Ok: true, NamedTables: keys}
 	} else {
@@ -1787,6 +2615,9 @@ func PostFormulaInfo(w http.ResponseWriter, r *http.Request) {
 		var args common.PreciseTarget
 		var err = json.Unmarshal(body, &args)
 		if err == nil {
+			if !requireTargetAccess(w, r, &args.Target, AccessRead) {
+				return
+			}
 			var reply common.ResultTableDetailsMsg
 			reply, err = FormulaDetailsAt(db, &args)
 			if err == nil {
@@ -1831,6 +2662,9 @@ func PostExect(w http.ResponseWriter, r *http.Request) {
 		var args common.PreciseTarget
 		var err = json.Unmarshal(body, &args)
 		if err == nil {
+			if !requireTargetAccess(w, r, &args.Target, AccessWrite) || !requireTargetRevision(w, r, &args.Target) {
+				return
+			}
 			var reply common.ResultMsg
 			reply, err = ExecTableAt(db, &args)
 			if err == nil {
@@ -1873,6 +2707,9 @@ func PostExecAllT(w http.ResponseWriter, r *http.Request) {
 		var args string
 		var err = json.Unmarshal(body, &args)
 		if err == nil {
+			if !requireFileAccess(w, r, args, AccessWrite) || !requireRevision(w, r, args) {
+				return
+			}
 			reply, errs := ExecAllTables(db, args)
 			if len(errs) <= 0 {
 				json.NewEncoder(w).Encode(reply)
@@ -1897,6 +2734,45 @@ func PostExecAllT(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* SDOC: API
+* POST /recalctables — Recalculate Tables and Their Dependents
+	Recalculates every table in the specified org file, then every table in a file
+	with =#+STARTUP: autorecalc= that reads one of them through =remote()=, in
+	dependency order. Files whose tables changed are written back to disk.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):* A JSON string containing the org filename.
+	#+BEGIN_SRC json
+	"budget.org"
+	#+END_SRC
+
+	*Response:* A list of =ResultMsg= objects, one per table recalculated. Tables that
+	read each other in a loop are not recalculated and are reported in a =ResultMsg=
+	with ={"Ok": false}=.
+	EDOC */
+func PostRecalcTables(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		var args string
+		var err = json.Unmarshal(body, &args)
+		if err == nil {
+			if !requireFileAccess(w, r, args, AccessWrite) || !requireRevision(w, r, args) {
+				return
+			}
+			reply, _ := GetDb().RecalcTables(r.Context(), args)
+			json.NewEncoder(w).Encode(reply)
+		} else {
+			fmt.Println("RecalcTables failed to deserialize", err, string(body))
+			rep := common.ResultMsg{Ok: false, Msg: err.Error()}
+			json.NewEncoder(w).Encode(rep)
+		}
+	} else {
+		fmt.Println("RecalcTables failed to read body", err)
+		json.NewEncoder(w).Encode(err)
+	}
+}
+
 /* SDOC: API
 * GET /status/{hash} — Get Valid Status Keywords for a Heading
 	Returns the list of valid TODO status keywords that can be applied to the heading
@@ -1917,6 +2793,9 @@ func PostExecAllT(w http.ResponseWriter, r *http.Request) {
 func RequestValidStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var args common.TodoHash = common.TodoHash(h)
 		res, err := ValidStatus(&args)
 		if err != nil {
@@ -1950,6 +2829,9 @@ func RequestValidStatus(w http.ResponseWriter, r *http.Request) {
 func RequestNextSibling(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var args common.TodoHash = common.TodoHash(h)
 		res := NextSibling(&args)
 		if res == nil {
@@ -1980,6 +2862,9 @@ func RequestNextSibling(w http.ResponseWriter, r *http.Request) {
 func RequestPrevSibling(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var args common.TodoHash = common.TodoHash(h)
 		res := PrevSibling(&args)
 		if res == nil {
@@ -2009,6 +2894,9 @@ func RequestPrevSibling(w http.ResponseWriter, r *http.Request) {
 func RequestLastChild(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if h, err := GetHash(vars, "hash"); err == nil {
+		if !requireHashAccess(w, r, h, AccessRead) {
+			return
+		}
 		var args common.TodoHash = common.TodoHash(h)
 		res := LastChild(&args)
 		if res == nil {
@@ -2030,11 +2918,13 @@ func RequestLastChild(w http.ResponseWriter, r *http.Request) {
 
 	*Parameters:* None.
 
+	Only tags from files the caller can read are returned.
+
 	*Response:* A JSON array of tag strings (e.g. =["WORK", "HOME", "urgent", "PROJECT"]=),
 	or an error string if the tag list cannot be retrieved.
 	EDOC */
 func RequestTags(w http.ResponseWriter, r *http.Request) {
-	res := GetDb().GetAllTags()
+	res := FilterTags(GetUsername(r))
 	if res == nil {
 		json.NewEncoder(w).Encode("could not get tags list")
 	} else {
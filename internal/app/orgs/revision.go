@@ -0,0 +1,219 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Revisions
+
+  Every org file the server has loaded carries a revision, a hash of its
+  content. Reads of a file or heading return it in the =ETag= header, and
+  =GET /revision?filename== returns it on its own.
+
+  Editing requests that send the revision back in an =If-Match= header are
+  only applied if the file on disk still has that revision. Otherwise the
+  server replies =409 Conflict= with the current revision and a diff of
+  what changed since, so the client can reload and try again rather than
+  overwriting someone elses change. Requests that change more than one
+  file, like a refile or an archive, check every file against the header,
+  so send the revision of each of them. The revision is checked again when
+  the change is written, so of two requests sent with the same revision
+  only the first is applied.
+
+  Requests without =If-Match= are still applied, but if the file was
+  changed on disk by another program and the server has not picked that
+  up yet it is reloaded before the edit, so the external change is kept.
+EDOC */
+
+import (
+	"context"
+	"crypto/sha1"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// How many old versions of a file are kept to diff against.
+const revisionHistory = 4
+
+type fileRevision struct {
+	Revision string
+	Content  string
+}
+
+// The last few versions of each file, so a conflict can show what changed.
+type revisionCache struct {
+	files map[string][]fileRevision
+	mu    sync.Mutex
+}
+
+var revisions = &revisionCache{files: map[string][]fileRevision{}}
+
+func contentRevision(data []byte) string {
+	h := sha1.Sum(data)
+	return b64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// Remember this version of a file and return its revision.
+func (self *revisionCache) remember(filename string, data []byte) string {
+	rev := contentRevision(data)
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	revs := self.files[filename]
+	if len(revs) > 0 && revs[len(revs)-1].Revision == rev {
+		return rev
+	}
+	revs = append(revs, fileRevision{Revision: rev, Content: string(data)})
+	if len(revs) > revisionHistory {
+		revs = revs[len(revs)-revisionHistory:]
+	}
+	self.files[filename] = revs
+	return rev
+}
+
+func (self *revisionCache) content(filename string, rev string) (string, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, r := range self.files[filename] {
+		if r.Revision == rev {
+			return r.Content, true
+		}
+	}
+	return "", false
+}
+
+// The revisions in an If-Match header, without quotes or weak markers.
+func parseIfMatch(header string) []string {
+	res := []string{}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		t = strings.TrimPrefix(t, "W/")
+		t = strings.Trim(t, "\"")
+		if t != "" {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// A short diff between two versions of a file, the lines that differ
+// between the common start and end of the two with a little context.
+func conflictDiff(before string, after string) string {
+	const context = 2
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	ea, eb := len(a), len(b)
+	for ea > start && eb > start && a[ea-1] == b[eb-1] {
+		ea--
+		eb--
+	}
+	if start == ea && start == eb {
+		return ""
+	}
+	from := max(start-context, 0)
+	toA := min(ea+context, len(a))
+	toB := min(eb+context, len(b))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", from+1, toA-from, from+1, toB-from)
+	for _, l := range a[from:start] {
+		sb.WriteString(" " + l + "\n")
+	}
+	for _, l := range a[start:ea] {
+		sb.WriteString("-" + l + "\n")
+	}
+	for _, l := range b[start:eb] {
+		sb.WriteString("+" + l + "\n")
+	}
+	for _, l := range a[ea:toA] {
+		sb.WriteString(" " + l + "\n")
+	}
+	return sb.String()
+}
+
+// Check a file against the revisions in an If-Match header. Returns nil
+// when the edit can go ahead. A file that changed on disk without the
+// database noticing is reloaded first. The writes made with ctx are
+// checked against the same revision.
+func CheckRevision(ctx context.Context, f *common.OrgFile, ifMatch string) *common.RevisionConflict {
+	data, err := os.ReadFile(f.Filename)
+	if err != nil {
+		// Let the edit itself report the problem.
+		return nil
+	}
+	cur := revisions.remember(f.Filename, data)
+	if tags := parseIfMatch(ifMatch); len(tags) > 0 {
+		ok := false
+		for _, t := range tags {
+			ok = ok || t == "*" || t == cur
+		}
+		if slices.Contains(tags, cur) {
+			expectRevision(ctx, f.Filename, cur)
+		}
+		if !ok {
+			c := &common.RevisionConflict{
+				Ok:       false,
+				Msg:      fmt.Sprintf("%s has changed, reload it and try again", f.Filename),
+				Filename: f.Filename,
+				Revision: cur,
+			}
+			if old, found := revisions.content(f.Filename, tags[0]); found {
+				c.Diff = conflictDiff(old, string(data))
+			}
+			return c
+		}
+	}
+	if cur != f.Revision {
+		GetDb().ReloadFile(f.Filename)
+	}
+	return nil
+}
+
+func setETag(w http.ResponseWriter, rev string) {
+	w.Header().Set("ETag", "\""+rev+"\"")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+}
+
+func setRevisionHeader(w http.ResponseWriter, f *common.OrgFile) {
+	if f != nil && f.Revision != "" {
+		setETag(w, f.Revision)
+	}
+}
+
+// REST helpers, these write a 409 and return false on a conflict.
+func requireRevision(w http.ResponseWriter, r *http.Request, filename string) bool {
+	f := GetDb().FindByFile(filename)
+	if f == nil {
+		return true
+	}
+	if c := CheckRevision(r.Context(), f, r.Header.Get("If-Match")); c != nil {
+		w.Header().Set("Content-Type", "application/json")
+		setETag(w, c.Revision)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(c)
+		return false
+	}
+	return true
+}
+
+func requireHashRevision(w http.ResponseWriter, r *http.Request, hash string) bool {
+	if _, f := GetDb().LookupHash(hash); f != nil {
+		return requireRevision(w, r, f.Filename)
+	}
+	return true
+}
+
+func requireTargetRevision(w http.ResponseWriter, r *http.Request, t *common.Target) bool {
+	if fname := targetFilename(t); fname != "" {
+		return requireRevision(w, r, fname)
+	}
+	return true
+}
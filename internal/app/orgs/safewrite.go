@@ -0,0 +1,114 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Editing
+* Safe Writes
+
+  Org files are never written in place. The new content goes to a
+  temporary file next to the original which is synced to disk and then
+  renamed over it, so a crash or a full disk leaves either the old file or
+  the new one and never half of each. The permissions and owner of the
+  original file are kept, and symlinked files are written through the link.
+
+  Before a file is replaced the previous version is copied to the =backups=
+  directory next to the server settings. The last backupCount versions of
+  each file are kept as =<name>-<id>.1= (the newest) to =<name>-<id>.N=.
+
+  A file the server writes is parsed again when the file watcher sees it,
+  unless a request already reloaded it. Writing out a change does not
+  move the positions, hashes, named tables or search entries the
+  database holds for the rest of the file, only parsing it again does.
+EDOC */
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// New files are not made executable or writable by others.
+const newFileMask fs.FileMode = 0133
+
+func GetBackupPath() string {
+	return path.Join(Conf().PlugManager.HomeDir, "backups")
+}
+
+// The backup name for a file, the id keeps files with the same name in
+// different directories apart.
+func backupName(filename string, n int) string {
+	h := sha1.Sum([]byte(filepath.Dir(filename)))
+	return filepath.Join(GetBackupPath(), fmt.Sprintf("%s-%s.%d", filepath.Base(filename), hex.EncodeToString(h[:4]), n))
+}
+
+// Copy the current content of a file into the backups, shifting the older
+// backups up by one and dropping the oldest.
+func rotateBackups(filename string, data []byte) error {
+	count := Conf().BackupCount
+	if count <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(GetBackupPath(), 0700); err != nil {
+		return err
+	}
+	os.Remove(backupName(filename, count))
+	for i := count - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(filename, i), backupName(filename, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.WriteFile(backupName(filename, 1), data, 0600)
+}
+
+// Write a file by way of a temporary file and a rename.
+func atomicWriteFile(filename string, data []byte, perm fs.FileMode) (err error) {
+	// Write through symlinks rather than replacing them.
+	if target, lerr := filepath.EvalSymlinks(filename); lerr == nil {
+		filename = target
+	}
+	mode := perm &^ newFileMask
+	info, statErr := os.Stat(filename)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+		if old, rerr := os.ReadFile(filename); rerr == nil {
+			if berr := rotateBackups(filename, old); berr != nil {
+				Log().Errorf("backup: failed to back up %s: %v", filename, berr)
+			}
+		}
+	}
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if statErr == nil {
+		keepOwner(tmp.Name(), info)
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package orgs
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// Give a replacement file the owner of the file it replaces. This only
+// works when running as root or as the owner, otherwise it is left alone.
+func keepOwner(name string, info fs.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		os.Chown(name, int(st.Uid), int(st.Gid))
+	}
+}
+
+// Make a rename in the directory durable.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
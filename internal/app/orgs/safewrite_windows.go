@@ -0,0 +1,13 @@
+//go:build windows
+
+package orgs
+
+import "io/fs"
+
+// Files on windows take the owner of the directory they are in.
+func keepOwner(name string, info fs.FileInfo) {
+}
+
+// Directories can not be synced on windows.
+func syncDir(dir string) {
+}
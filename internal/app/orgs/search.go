@@ -0,0 +1,483 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Querying
+* Full Text Search
+
+  Alongside the expression queries orgs maintains an inverted index over every heading
+  in every file it tracks. The index is updated incrementally whenever a file is loaded
+  or reloaded, so searching does not need to touch the disk.
+
+  Each heading is indexed as a document with 4 fields:
+
+  | Field      | Contents                                   |
+  |------------+--------------------------------------------|
+  | =headline= | The title of the heading                   |
+  | =body=     | The text of the heading up to its children |
+  | =tags=     | The tags on the heading                    |
+  | =props=    | The names and values of its properties     |
+
+  Queries are a list of terms that must all match:
+
+  | Syntax            | Meaning                                          |
+  |-------------------+--------------------------------------------------|
+  | =word=            | The word appears in any field                    |
+  | =wor*=            | A word starting with =wor= appears               |
+  | ="two words"=     | The words appear next to each other, in order    |
+  | =headline:word=   | Restrict a term or phrase to a single field      |
+  | =-word=           | Exclude headings containing the word             |
+
+  Results are ranked with BM25, matches in the headline and tags count for more than
+  matches in the body. Each result carries a short snippet of the text around the
+  first match.
+
+  #+BEGIN_SRC bash
+  oc search -query 'headline:"release plan" tags:work budg*'
+  #+END_SRC
+EDOC */
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+const (
+	searchFieldHeadline = "headline"
+	searchFieldBody     = "body"
+	searchFieldTags     = "tags"
+	searchFieldProps    = "props"
+
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	snippetRadius = 80
+)
+
+var searchFields = []string{searchFieldHeadline, searchFieldBody, searchFieldTags, searchFieldProps}
+
+var searchFieldBoost = map[string]float64{
+	searchFieldHeadline: 2.0,
+	searchFieldBody:     1.0,
+	searchFieldTags:     1.5,
+	searchFieldProps:    1.0,
+}
+
+type searchDoc struct {
+	id       int
+	sec      *org.Section
+	file     *common.OrgFile
+	text     map[string]string
+	lengths  map[string]int
+	filename string
+}
+
+// term -> doc id -> positions of the term within the field
+type postingList map[string]map[int][]int
+
+type SearchIndex struct {
+	lock     sync.RWMutex
+	nextId   int
+	docs     map[int]*searchDoc
+	byFile   map[string][]int
+	postings map[string]postingList
+	totalLen map[string]int
+}
+
+func NewSearchIndex() *SearchIndex {
+	idx := &SearchIndex{
+		docs:     map[int]*searchDoc{},
+		byFile:   map[string][]int{},
+		postings: map[string]postingList{},
+		totalLen: map[string]int{},
+	}
+	for _, f := range searchFields {
+		idx.postings[f] = postingList{}
+	}
+	return idx
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// The body of a heading for searching, without its property drawer.
+func searchBody(sec *org.Section) string {
+	nodes := []org.Node{}
+	for _, n := range sec.Headline.Children {
+		switch n.(type) {
+		case org.Headline, *org.Headline:
+			return writeSearchNodes(nodes)
+		case org.PropertyDrawer, *org.PropertyDrawer:
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return writeSearchNodes(nodes)
+}
+
+func writeSearchNodes(nodes []org.Node) string {
+	w := org.NewOrgWriter()
+	org.WriteNodes(w, nodes...)
+	return w.String()
+}
+
+func searchFieldText(sec *org.Section) map[string]string {
+	props := []string{}
+	if sec.Headline.Properties != nil {
+		for _, p := range sec.Headline.Properties.Properties {
+			props = append(props, strings.Join(p, " "))
+		}
+	}
+	return map[string]string{
+		searchFieldHeadline: common.GetHeadlineTitle(sec.Headline),
+		searchFieldBody:     searchBody(sec),
+		searchFieldTags:     strings.Join(sec.Headline.Tags, " "),
+		searchFieldProps:    strings.Join(props, "\n"),
+	}
+}
+
+func (self *SearchIndex) removeFile(filename string) {
+	for _, id := range self.byFile[filename] {
+		doc := self.docs[id]
+		for field, text := range doc.text {
+			for _, t := range tokenize(text) {
+				if plist, ok := self.postings[field][t]; ok {
+					delete(plist, id)
+					if len(plist) == 0 {
+						delete(self.postings[field], t)
+					}
+				}
+			}
+			self.totalLen[field] -= doc.lengths[field]
+		}
+		delete(self.docs, id)
+	}
+	delete(self.byFile, filename)
+}
+
+func (self *SearchIndex) addSection(sec *org.Section, f *common.OrgFile) {
+	if sec.Headline != nil {
+		self.nextId += 1
+		doc := &searchDoc{id: self.nextId, sec: sec, file: f, filename: f.Filename, lengths: map[string]int{}}
+		doc.text = searchFieldText(sec)
+		for field, text := range doc.text {
+			toks := tokenize(text)
+			for pos, t := range toks {
+				plist, ok := self.postings[field][t]
+				if !ok {
+					plist = map[int][]int{}
+					self.postings[field][t] = plist
+				}
+				plist[doc.id] = append(plist[doc.id], pos)
+			}
+			doc.lengths[field] = len(toks)
+			self.totalLen[field] += len(toks)
+		}
+		self.docs[doc.id] = doc
+		self.byFile[f.Filename] = append(self.byFile[f.Filename], doc.id)
+	}
+	for _, c := range sec.Children {
+		self.addSection(c, f)
+	}
+}
+
+// Replace everything we know about a file with its current contents.
+func (self *SearchIndex) IndexFile(f *common.OrgFile) {
+	if f == nil || f.Doc == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.removeFile(f.Filename)
+	for _, sec := range f.Doc.Outline.Children {
+		self.addSection(sec, f)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Queries
+// ---------------------------------------------------------------------------
+
+type searchTerm struct {
+	field   string // empty means any field
+	words   []string
+	prefix  bool
+	exclude bool
+}
+
+// Split a query into terms, honouring quotes, field: prefixes, - and *.
+func parseSearchQuery(query string) []searchTerm {
+	terms := []searchTerm{}
+	runes := []rune(strings.TrimSpace(query))
+	for i := 0; i < len(runes); {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i >= len(runes) {
+			break
+		}
+		term := searchTerm{}
+		if runes[i] == '-' {
+			term.exclude = true
+			i++
+		}
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' && runes[i] != ':' {
+			i++
+		}
+		if i < len(runes) && runes[i] == ':' {
+			field := strings.ToLower(string(runes[start:i]))
+			if field == "prop" || field == "property" || field == "properties" {
+				field = searchFieldProps
+			} else if field == "tag" {
+				field = searchFieldTags
+			}
+			if _, ok := searchFieldBoost[field]; ok {
+				term.field = field
+				i++
+				start = i
+			}
+		}
+		var raw string
+		if i == start && i < len(runes) && runes[i] == '"' {
+			i++
+			start = i
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			raw = string(runes[start:i])
+			i++
+		} else {
+			i = start
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			raw = string(runes[start:i])
+			if strings.HasSuffix(raw, "*") {
+				term.prefix = true
+				raw = strings.TrimSuffix(raw, "*")
+			}
+		}
+		term.words = tokenize(raw)
+		if len(term.words) > 0 {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// The indexed words a query word stands for, itself or everything it prefixes.
+func (self *SearchIndex) expand(field string, word string, prefix bool) []string {
+	if !prefix {
+		if _, ok := self.postings[field][word]; ok {
+			return []string{word}
+		}
+		return nil
+	}
+	res := []string{}
+	for t := range self.postings[field] {
+		if strings.HasPrefix(t, word) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Positions within a field that a term matches at, keyed by doc.
+// For phrases this is the position of the first word.
+func (self *SearchIndex) matchTerm(field string, term *searchTerm) map[int][]int {
+	res := map[int][]int{}
+	for wi, word := range term.words {
+		// Only the last word of a phrase can be a prefix.
+		words := self.expand(field, word, term.prefix && wi == len(term.words)-1)
+		cur := map[int][]int{}
+		for _, w := range words {
+			for doc, positions := range self.postings[field][w] {
+				if wi == 0 {
+					cur[doc] = append(cur[doc], positions...)
+					continue
+				}
+				prev, ok := res[doc]
+				if !ok {
+					continue
+				}
+				have := map[int]bool{}
+				for _, p := range positions {
+					have[p-wi] = true
+				}
+				for _, p := range prev {
+					if have[p] {
+						cur[doc] = append(cur[doc], p)
+					}
+				}
+			}
+		}
+		res = cur
+		if len(res) == 0 {
+			break
+		}
+	}
+	return res
+}
+
+func (self *SearchIndex) bm25(field string, docFreq int, termFreq int, docLen int) float64 {
+	n := float64(len(self.docs))
+	idf := math.Log(1.0 + (n-float64(docFreq)+0.5)/(float64(docFreq)+0.5))
+	avg := 1.0
+	if len(self.docs) > 0 {
+		avg = math.Max(1.0, float64(self.totalLen[field])/n)
+	}
+	tf := float64(termFreq)
+	return idf * (tf * (bm25K1 + 1)) / (tf + bm25K1*(1-bm25B+bm25B*float64(docLen)/avg))
+}
+
+type searchHit struct {
+	doc   *searchDoc
+	score float64
+	words []string
+}
+
+func (self *SearchIndex) scoreTerm(term *searchTerm) map[int]float64 {
+	fields := searchFields
+	if term.field != "" {
+		fields = []string{term.field}
+	}
+	matched := map[int]float64{}
+	for _, field := range fields {
+		docs := self.matchTerm(field, term)
+		for doc, positions := range docs {
+			matched[doc] += searchFieldBoost[field] * self.bm25(field, len(docs), len(positions), self.docs[doc].lengths[field])
+		}
+	}
+	return matched
+}
+
+func (self *SearchIndex) Search(query string, limit int, allow func(filename string) bool) []common.SearchResult {
+	terms := parseSearchQuery(query)
+	self.lock.RLock()
+	var hits map[int]*searchHit
+	for ti := range terms {
+		term := &terms[ti]
+		if term.exclude {
+			continue
+		}
+		matched := self.scoreTerm(term)
+		next := map[int]*searchHit{}
+		for doc, score := range matched {
+			hit, ok := hits[doc]
+			if hits == nil {
+				hit = &searchHit{doc: self.docs[doc]}
+			} else if !ok {
+				continue
+			}
+			hit.score += score
+			hit.words = append(hit.words, term.words...)
+			next[doc] = hit
+		}
+		hits = next
+	}
+	for ti := range terms {
+		if term := &terms[ti]; term.exclude {
+			for doc := range self.scoreTerm(term) {
+				delete(hits, doc)
+			}
+		}
+	}
+	results := []*searchHit{}
+	for _, hit := range hits {
+		if allow == nil || allow(hit.doc.filename) {
+			results = append(results, hit)
+		}
+	}
+	self.lock.RUnlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score == results[j].score {
+			return results[i].doc.id < results[j].doc.id
+		}
+		return results[i].score > results[j].score
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	res := []common.SearchResult{}
+	for _, hit := range results {
+		td := SectionToTodo(hit.doc.sec, hit.doc.file)
+		res = append(res, common.SearchResult{Todo: *td, Score: hit.score, Snippet: makeSnippet(hit.doc, hit.words)})
+	}
+	return res
+}
+
+// Where a lower case word first appears in text, ignoring case. The offset
+// is into text itself, lowering text can change the length of it.
+func indexFold(text string, word string) int {
+	for i := range text {
+		rest := text[i:]
+		matched := true
+		for _, wr := range word {
+			r, size := utf8.DecodeRuneInString(rest)
+			if size == 0 || unicode.ToLower(r) != wr {
+				matched = false
+				break
+			}
+			rest = rest[size:]
+		}
+		if matched {
+			return i
+		}
+	}
+	return -1
+}
+
+// A short window of text around the first matched word, body first.
+func makeSnippet(doc *searchDoc, words []string) string {
+	for _, field := range []string{searchFieldBody, searchFieldHeadline, searchFieldProps} {
+		text := doc.text[field]
+		best := -1
+		for _, w := range words {
+			if at := indexFold(text, w); at >= 0 && (best < 0 || at < best) {
+				best = at
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		if best >= len(text) {
+			best = len(text) - 1
+		}
+		start := best - snippetRadius
+		end := best + snippetRadius
+		prefix, suffix := "...", "..."
+		if start <= 0 {
+			start, prefix = 0, ""
+		}
+		if end >= len(text) {
+			end, suffix = len(text), ""
+		}
+		// Do not cut a multi byte character in half.
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+		return prefix + strings.Join(strings.Fields(text[start:end]), " ") + suffix
+	}
+	return ""
+}
+
+// Full text search over the files a user may read.
+func SearchText(query string, limit int, username string) []common.SearchResult {
+	return GetDb().Search.Search(query, limit, func(filename string) bool {
+		return CanReadFile(username, filename)
+	})
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/ihdavids/orgs/internal/app/orgs/plugs/autoclockout"
+	"github.com/ihdavids/orgs/internal/app/orgs/plugs/confluence"
 	"github.com/ihdavids/orgs/internal/common"
 	"github.com/ihdavids/orgs/worg"
 	"github.com/rs/cors"
@@ -236,6 +237,7 @@ func StartServer(sets *common.ServerSettings) {
 	// Force config parsing right up front
 	DefaultKeystore()
 	Conf()
+	LoadKeystore()
 	LoadExtensions()
 	GetDb().Watch()
 	defer func() {
@@ -342,7 +344,9 @@ func StartServer(sets *common.ServerSettings) {
 }
 
 func startPlugins(sets *common.ServerSettings) {
-	autoclockout.RegisterClockAccessor(Clock())
+	autoclockout.RegisterClockAccessor(Clocks())
+	confluence.RegisterPropertyStore(HeadingProperties{})
+	confluence.RegisterFileResolver(ResolveExportFile)
 	for _, plug := range sets.Plugins {
 		plug.Start(db)
 	}
@@ -72,6 +72,9 @@ type Config struct {
 	// the archived entry, with a prefix \"ARCHIVE_\", to remember this
 	// information."
 	ArchiveSaveContextInfo []string `yaml:"archiveSaveContextInfo"`
+	// The heading a subtree is moved under when archiving to a sibling.
+	// org-archive-sibling-heading
+	ArchiveSiblingHeading string `yaml:"archiveSiblingHeading"`
 
 	// If true empty properties in the ArchiveSaveContextInfo are not included
 	// in the archive output
@@ -120,6 +123,108 @@ type Config struct {
 		EDOC */
 	ClockIntoDrawer string `yaml:"clockIntoDrawer"`
 	/* SDOC: Settings
+	* Clock Idle Threshold
+		A clock that has been running for more than this many minutes is
+		treated as dangling and has to be resolved before it can be clocked out.
+		See Dangling Clocks.
+		#+BEGIN_SRC yaml
+		 clockIdleThreshold: 720
+		#+END_SRC
+
+		This defaults to 720 (12 hours), 0 turns the check off.
+
+		EDOC */
+	ClockIdleThreshold int `yaml:"clockIdleThreshold"`
+	/* SDOC: Settings
+	* Journal Size
+		How many changes are kept for each user so they can be undone.
+		See Undo and Redo.
+		#+BEGIN_SRC yaml
+		 journalSize: 50
+		#+END_SRC
+
+		This defaults to 50, 0 keeps every change.
+
+		EDOC */
+	JournalSize int `yaml:"journalSize"`
+	/* SDOC: Settings
+	* Backup Count
+		How many old versions of each org file are kept in the backups
+		directory when the server writes to it. See Safe Writes.
+		#+BEGIN_SRC yaml
+		 backupCount: 5
+		#+END_SRC
+
+		This defaults to 5, 0 turns backups off.
+
+		EDOC */
+	BackupCount int `yaml:"backupCount"`
+	/* SDOC: Settings
+	* Babel Execution
+		Executing source blocks runs code on the server as the user the
+		server runs as, so it is off until you turn it on. Only the
+		languages listed can be run and only admins can run them.
+		#+BEGIN_SRC yaml
+		 babelEnabled: true
+		 babelLanguages: ["sh", "python"]
+		 babelSessionTimeout: 30
+		#+END_SRC
+
+		babelSessionTimeout is the number of minutes a =:session= interpreter
+		can sit idle before it is shut down. This defaults to 30.
+
+		EDOC */
+	BabelEnabled        bool     `yaml:"babelEnabled"`
+	BabelLanguages      []string `yaml:"babelLanguages"`
+	BabelSessionTimeout int      `yaml:"babelSessionTimeout"`
+	/* SDOC: Settings
+	* Allowed Origins
+		Web pages that may open the =/events= WebSocket. Pages served by
+		orgs itself are always allowed.
+		#+BEGIN_SRC yaml
+		 allowedOrigins: ["http://localhost:3000"]
+		#+END_SRC
+
+		EDOC */
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	/* SDOC: Settings
+	* Log Into Drawer
+		State changes, like a repeating task being marked DONE, are logged
+		as notes in a drawer on the heading. This option lets you choose the
+		name of the drawer.
+		#+BEGIN_SRC yaml
+		 logIntoDrawer: "LOGBOOK"
+		#+END_SRC
+
+		This defaults to the clockIntoDrawer drawer.
+
+		EDOC */
+	LogIntoDrawer string `yaml:"logIntoDrawer"`
+	/* SDOC: Settings
+	* Log Done
+		When a heading moves into a DONE state orgs adds a =CLOSED:= timestamp
+		to it, and removes it again if the heading is moved back to an active state.
+		#+BEGIN_SRC yaml
+		 logDone: false
+		#+END_SRC
+
+		This defaults to true.
+
+		Logging of individual state changes is controlled per keyword with the
+		org mode syntax in the =#+TODO= line, or in defaultTodoStates:
+		#+BEGIN_SRC org
+		#+TODO: TODO(t) WAIT(w@/!) | DONE(d!) CANCELLED(c@)
+		#+END_SRC
+		A =!= logs the time and a =@= logs the time and a note when entering
+		the state. After a slash the same applies to leaving the state.
+		Entries look like =- State "DONE" from "TODO" [timestamp]= and are
+		written to the logIntoDrawer drawer. Notes are taken from the =Note=
+		field of a status change, a state that asks for a note is still
+		logged when no note is given.
+
+		EDOC */
+	LogDone bool `yaml:"logDone"`
+	/* SDOC: Settings
 	* Image Path
 		Images and fonts are secondary html requests when loading an html
 		document in vscode. In addition, orgs may want to provide
@@ -254,11 +359,17 @@ func (self *Config) Defaults() {
 	self.ArchiveDefaultTarget = "%s_archive::"
 	self.ArchiveSaveContextInfo = []string{"time", "file", "ltags", "itags", "todo", "category", "olpath"}
 	self.ArchiveSkipEmptyProperties = true
+	self.ArchiveSiblingHeading = "Archive"
 
 	self.DateTreeYearFormat = "2006"
 	self.DateTreeMonthFormat = "January"
 	self.DateTreeDayFormat = "02 Monday"
 	self.ClockIntoDrawer = "LOGBOOK"
+	self.ClockIdleThreshold = 720
+	self.JournalSize = 50
+	self.BackupCount = 5
+	self.BabelSessionTimeout = 30
+	self.LogDone = true
 	self.TemplateImagesPath = "./templates/html_styles/images"
 	self.TemplateFontPath = "./templates/fonts"
 
@@ -356,6 +467,7 @@ func (self *Config) ParseConfig() {
 		manager.Port = self.Server.Port
 		manager.TLSPort = self.Server.TLSPort
 		manager.OrgDirs = self.Server.OrgDirs
+		manager.ClockDrawer = self.ClockIntoDrawer
 	}
 	config.PlugManager = manager
 	if self.Server != nil {
@@ -0,0 +1,75 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+const logTimestampLayout = "[2006-01-02 Mon 15:04]"
+
+// Add a note to the logbook drawer of a heading. The note is
+// parsed as org text so lists stay lists.
+func AddLogNote(n *org.Headline, note string) {
+	doc := org.New().Parse(strings.NewReader(note+"\n"), "./")
+	name := LogDrawerName()
+	drawer := n.FindDrawer(name)
+	if drawer != nil {
+		for _, node := range doc.Nodes {
+			drawer.Append(n, node)
+		}
+	} else {
+		drawer := &org.Drawer{Name: name, Children: doc.Nodes}
+		n.AddDrawer(drawer)
+	}
+}
+
+// State changes go to logIntoDrawer, or the clock drawer if that is not set.
+func LogDrawerName() string {
+	if Conf().LogIntoDrawer != "" {
+		return Conf().LogIntoDrawer
+	}
+	return Conf().ClockIntoDrawer
+}
+
+// A logbook entry in the org mode format, any note follows on indented lines.
+func StateChangeNote(to, from, note string, now time.Time) string {
+	entry := fmt.Sprintf("- State %-12s from %-12s %s", "\""+to+"\"", "\""+from+"\"", now.Format(logTimestampLayout))
+	if note = strings.TrimSpace(note); note != "" {
+		entry += " \\\\"
+		for _, line := range strings.Split(note, "\n") {
+			entry += "\n  " + strings.TrimRight(line, " \t\r")
+		}
+	}
+	return entry
+}
+
+// Record a status change the way org mode does. Entering a done state
+// adds CLOSED (see logDone) and going back to an active state removes it.
+// A note is logged when either keyword asks for one or a note was given.
+func LogStateChange(n *org.Headline, f *common.OrgFile, from, to, note string, now time.Time) {
+	if from == to {
+		return
+	}
+	if Conf().LogDone {
+		if isDoneState(f, to) && !isDoneState(f, from) {
+			if date, dtype := org.ParseSDC("CLOSED: " + now.Format(logTimestampLayout)); date != nil {
+				sdc := &org.SDC{Date: date, DateType: dtype}
+				removeSDCChild(n, org.Closed)
+				n.Closed = sdc
+				insertSDCChild(n, sdc)
+			}
+		} else if !isDoneState(f, to) && n.Closed != nil {
+			n.Closed = nil
+			removeSDCChild(n, org.Closed)
+		}
+	}
+	logging := StateLoggingFromFile(f)
+	if logging[to].Enter != "" || logging[from].Leave != "" || strings.TrimSpace(note) != "" {
+		AddLogNote(n, StateChangeNote(to, from, note, now))
+	}
+}
@@ -0,0 +1,352 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Tables
+* Automatic Recalculation
+
+  Tables are normally only recalculated when a client asks for it with
+  =/api/exectable= or =/api/execalltables=. A file can opt in to having
+  its tables kept up to date by the server:
+
+  #+BEGIN_SRC org
+  #+STARTUP: autorecalc
+  #+END_SRC
+
+  When such a file is changed, by the server or on disk, its tables are
+  recalculated, along with every table that reads one of them through
+  =remote(NAME, ...)=, in this file or any other file that has also opted
+  in. Tables are recalculated in dependency order, so a table always sees
+  the new values of the tables it reads. Files are written back once, at
+  the end.
+
+  #+BEGIN_SRC org
+  #+STARTUP: autorecalc
+  #+NAME: Totals
+  | Item  | Total |
+  |-------+-------|
+  | Bread |  4.50 |
+  #+TBLFM: @2$2=vsum(remote(Prices,@2$2..@>$2))
+  #+END_SRC
+
+  Tables that read each other in a loop are left alone and the loop is
+  reported as an error. =POST /api/recalctables= runs the same pass for a
+  file on demand, whether or not it has opted in, and returns the results.
+EDOC */
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// A table with formulas and the named tables its formulas read.
+type tableRef struct {
+	Key   string
+	Name  string
+	File  *common.OrgFile
+	Sec   *org.Section
+	Table *org.Table
+	Uses  []string
+}
+
+func (self *tableRef) Label() string {
+	if self.Name != "" {
+		return self.Name
+	}
+	return fmt.Sprintf("%s:%d", self.File.Filename, self.Table.GetPos().Row+1)
+}
+
+// TableGraph tracks which tables read which named tables, it is rebuilt
+// for a file each time the file is scanned.
+type TableGraph struct {
+	tables map[string]*tableRef
+	byFile map[string][]*tableRef
+}
+
+func NewTableGraph() *TableGraph {
+	return &TableGraph{tables: map[string]*tableRef{}, byFile: map[string][]*tableRef{}}
+}
+
+func tableKey(filename string, tbl *org.Table) string {
+	return fmt.Sprintf("%s:%d", filename, tbl.GetPos().Row)
+}
+
+// The named tables read by the remote() calls in a tables formulas.
+func tableUses(tbl *org.Table) []string {
+	uses := []string{}
+	if tbl.Formulas == nil {
+		return uses
+	}
+	idx := RE_REMOTE.SubexpIndex("tblnm")
+	for _, kw := range tbl.Formulas.Keywords {
+		for _, m := range RE_REMOTE.FindAllStringSubmatch(kw.Value, -1) {
+			if !contains(uses, m[idx]) {
+				uses = append(uses, m[idx])
+			}
+		}
+	}
+	return uses
+}
+
+func (self *TableGraph) RemoveFile(filename string) {
+	for _, t := range self.byFile[filename] {
+		delete(self.tables, t.Key)
+	}
+	delete(self.byFile, filename)
+}
+
+func (self *TableGraph) ScanFile(f *common.OrgFile) {
+	self.RemoveFile(f.Filename)
+	names := map[*org.Table]string{}
+	for name, node := range f.Doc.NamedNodes {
+		if tbl, ok := node.(*org.Table); ok {
+			names[tbl] = name
+		}
+	}
+	refs := []*tableRef{}
+	add := func(sec *org.Section, tbl *org.Table) {
+		t := &tableRef{Key: tableKey(f.Filename, tbl), Name: names[tbl], File: f, Sec: sec, Table: tbl, Uses: tableUses(tbl)}
+		self.tables[t.Key] = t
+		refs = append(refs, t)
+	}
+	var walk func(secs []*org.Section)
+	walk = func(secs []*org.Section) {
+		for _, sec := range secs {
+			for _, tbl := range sec.Headline.Tables {
+				add(sec, tbl)
+			}
+			walk(sec.Children)
+		}
+	}
+	walk(f.Doc.Outline.Children)
+	// Tables before the first heading belong to the file itself.
+	if root := fileRootSection(f); root != nil {
+		for _, n := range f.Doc.Nodes {
+			if tbl, ok := n.(*org.Table); ok {
+				if _, seen := self.tables[tableKey(f.Filename, tbl)]; !seen {
+					add(root, tbl)
+				}
+			}
+		}
+		for tbl := range names {
+			if _, seen := self.tables[tableKey(f.Filename, tbl)]; !seen {
+				add(root, tbl)
+			}
+		}
+	}
+	self.byFile[f.Filename] = refs
+}
+
+// Which tables read each table, resolving names the same way remote() does.
+func (self *TableGraph) dependents(db *OrgDb) map[string][]*tableRef {
+	deps := map[string][]*tableRef{}
+	for _, t := range self.tables {
+		for _, name := range t.Uses {
+			if src := db.GetNamedTable(name, t.File.Filename); src != nil {
+				key := tableKey(src.File.Filename, src.Table)
+				deps[key] = append(deps[key], t)
+			}
+		}
+	}
+	for _, d := range deps {
+		sort.Slice(d, func(i, j int) bool { return d[i].Key < d[j].Key })
+	}
+	return deps
+}
+
+func wantsAutoRecalc(f *common.OrgFile) bool {
+	for _, opt := range strings.Fields(f.Doc.Get("STARTUP")) {
+		if strings.EqualFold(opt, "autorecalc") {
+			return true
+		}
+	}
+	return false
+}
+
+// The tables to recalculate after a file changed, in the order to
+// recalculate them, and the tables left out because they form a loop.
+func (self *TableGraph) plan(db *OrgDb, filename string, force bool) ([]*tableRef, []*tableRef) {
+	deps := self.dependents(db)
+	include := func(t *tableRef) bool {
+		return t.Table.Formulas != nil && (wantsAutoRecalc(t.File) || (force && t.File.Filename == filename))
+	}
+	dirty := map[string]*tableRef{}
+	queue := []*tableRef{}
+	for _, t := range self.byFile[filename] {
+		if include(t) {
+			dirty[t.Key] = t
+		}
+		// The tables in the file changed whether we recalculate them or not.
+		queue = append(queue, t)
+	}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, d := range deps[t.Key] {
+			if _, ok := dirty[d.Key]; !ok && include(d) {
+				dirty[d.Key] = d
+				queue = append(queue, d)
+			}
+		}
+	}
+	// Kahn's algorithm over the dirty tables, whatever is left over is in or behind a loop.
+	incoming := map[string]int{}
+	for key := range dirty {
+		incoming[key] += 0
+		for _, d := range deps[key] {
+			if _, ok := dirty[d.Key]; ok {
+				incoming[d.Key]++
+			}
+		}
+	}
+	ready := []string{}
+	for key, n := range incoming {
+		if n == 0 {
+			ready = append(ready, key)
+		}
+	}
+	sort.Strings(ready)
+	order := []*tableRef{}
+	for len(ready) > 0 {
+		key := ready[0]
+		ready = ready[1:]
+		order = append(order, dirty[key])
+		for _, d := range deps[key] {
+			if _, ok := dirty[d.Key]; ok {
+				incoming[d.Key]--
+				if incoming[d.Key] == 0 {
+					ready = append(ready, d.Key)
+				}
+			}
+		}
+	}
+	stuck := []*tableRef{}
+	for key, n := range incoming {
+		if n > 0 {
+			stuck = append(stuck, dirty[key])
+		}
+	}
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].Key < stuck[j].Key })
+	return order, stuck
+}
+
+func (self *OrgDb) recalcTables(ctx context.Context, filename string, force bool) ([]common.ResultMsg, []error) {
+	res := []common.ResultMsg{}
+	errs := []error{}
+	self.dblock.RLock()
+	order, stuck := self.TableDeps.plan(self, filename, force)
+	self.dblock.RUnlock()
+	if len(stuck) > 0 {
+		labels := []string{}
+		for _, t := range stuck {
+			labels = append(labels, t.Label())
+		}
+		err := fmt.Errorf("tables read each other in a loop, not recalculating: %s", strings.Join(labels, ", "))
+		res = append(res, common.ResultMsg{Ok: false, Msg: err.Error()})
+		errs = append(errs, err)
+	}
+	// Keep the content of each file from before we touch it, so we only write what changed.
+	before := map[string]string{}
+	files := []*common.OrgFile{}
+	// The tables are changed in place, nobody gets to look them up half way.
+	// Formulas only reach other tables through GetNamedTable, which does not lock.
+	func() {
+		self.dblock.Lock()
+		defer self.dblock.Unlock()
+		for _, t := range order {
+			if _, ok := before[t.File.Filename]; !ok {
+				w := org.NewOrgWriter()
+				t.File.Doc.Write(w)
+				before[t.File.Filename] = w.String()
+				files = append(files, t.File)
+			}
+			r, err := ExecTable(self, t.File, t.Sec, t.Table)
+			if err != nil {
+				err = fmt.Errorf("%s: %v", t.Label(), err)
+				errs = append(errs, err)
+				r.Msg = err.Error()
+			} else {
+				r.Msg = "recalculated " + t.Label()
+			}
+			res = append(res, r)
+		}
+	}()
+	for _, f := range files {
+		w := org.NewOrgWriter()
+		f.Doc.Write(w)
+		if w.String() == before[f.Filename] {
+			continue
+		}
+		if !WriteOutOrgFile(ctx, f) {
+			errs = append(errs, fmt.Errorf("failed to write %s", f.Filename))
+			continue
+		}
+		self.ReloadFile(f.Filename)
+	}
+	return res, errs
+}
+
+// A file that was written while a recalculation was running.
+type pendingRecalc struct {
+	ctx      context.Context
+	filename string
+}
+
+// Called when a file is written or reloaded. While a recalculation is
+// running the file is queued, whether the recalculation wrote it or
+// someone else did, and recalculated once it is done.
+func (self *OrgDb) autoRecalc(ctx context.Context, filename string) {
+	self.recalcMu.Lock()
+	if !slices.ContainsFunc(self.recalcQueue, func(p pendingRecalc) bool { return p.filename == filename }) {
+		self.recalcQueue = append(self.recalcQueue, pendingRecalc{ctx: ctx, filename: filename})
+	}
+	if self.recalcBusy {
+		self.recalcMu.Unlock()
+		return
+	}
+	self.recalcBusy = true
+	self.recalcMu.Unlock()
+	self.drainRecalcs()
+}
+
+// Recalculate queued files until none are left. The caller set recalcBusy,
+// it is cleared once the queue is empty.
+func (self *OrgDb) drainRecalcs() {
+	for {
+		self.recalcMu.Lock()
+		if len(self.recalcQueue) == 0 {
+			self.recalcBusy = false
+			self.recalcIdle.Broadcast()
+			self.recalcMu.Unlock()
+			return
+		}
+		p := self.recalcQueue[0]
+		self.recalcQueue = self.recalcQueue[1:]
+		self.recalcMu.Unlock()
+		_, errs := self.recalcTables(p.ctx, p.filename, false)
+		for _, err := range errs {
+			Log().Errorf("autorecalc: %v", err)
+		}
+	}
+}
+
+// Recalculate the tables in a file and every table that depends on them.
+func (self *OrgDb) RecalcTables(ctx context.Context, filename string) ([]common.ResultMsg, []error) {
+	self.recalcMu.Lock()
+	for self.recalcBusy {
+		self.recalcIdle.Wait()
+	}
+	self.recalcBusy = true
+	self.recalcMu.Unlock()
+	defer self.drainRecalcs()
+	if self.FindByFile(filename) == nil {
+		err := fmt.Errorf("could not find file [%s]", filename)
+		return []common.ResultMsg{{Ok: false, Msg: err.Error()}}, []error{err}
+	}
+	return self.recalcTables(ctx, filename, true)
+}
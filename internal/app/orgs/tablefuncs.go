@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ihdavids/go-org/org"
@@ -119,19 +122,62 @@ import (
    - weekdayname
    - yearday
    - duration
-  
-  TODO: Fill in information on table functions
+
+   Lookups and conditionals
+
+   - lookupfirst(val, slist, [rlist], [op]) - like org-lookup-first, the entry of rlist
+     in the same position as the first entry of slist that matches val. Without rlist
+     the matching entry itself. op is one of ==, !=, <, <=, >, >= or ~ (a regex), == by default.
+   - lookuplast - the same for the last match, like org-lookup-last
+   - lookupall - a list of every match, like org-lookup-all, vsum(lookupall(...)) adds them up
+   - if(cond, then, else)
+   - sumif(list, op, val, [sumlist]) - the sum of the entries of list (or sumlist) that match
+   - countif(list, op, val) - how many entries of list match
+   - find(val, list) - the 1 based position of val in the list, 0 if it is not there
+   - head - the first entry of a list
+   - tail - all but the first entry of a list
+
+   Vector methods
+
+   - vmul - multiply two lists entry by entry, vsum(vmul($2,$3)) is a sum of products
+   - vadd - add two lists entry by entry
+
+   String methods
+
+   - concat - join all of its arguments
+   - substr(str, start, [len]) - 0 based like the lisp substring, negative start counts from the end
+   - upper
+   - lower
+   - trim
+   - strlen
+
+   #+BEGIN_SRC org
+   #+NAME: Budget
+   | Category  | Item     |  Amount |
+   |-----------+----------+---------|
+   | Food      | Bread    |   $4.50 |
+   | Transport | Bus      |   $2.75 |
+   | Food      | Milk     |   $3.20 |
+   |-----------+----------+---------|
+   | Food      |          |   $7.70 |
+   #+TBLFM: @>$3=sumif(@2$1..@-1$1,'==',@>$1,@2$3..@-1$3);$
+   #+END_SRC
+
+   Formats
+
+   After the formula a =;= can be followed by a printf style format like =%.2f=
+   and any of these modes:
+
+   - =N= treat every cell as a number, non numbers are 0
+   - =E= keep empty cells in ranges
+   - =H= include the header row in column ranges
+   - =$=, =€=, =£= or =¥= currency, the symbol and thousands separators are stripped
+     when reading cells and results are written with the symbol and 2 decimal places
+   - =%= percentages, cells like =45%= read as 0.45 and results are written times 100
+     with a % sign, with 1 decimal place unless a format is given
+   - =,= write results with thousands separators
 EDOC */
 
-// Additional functions
-// head - Get first cell
-// tail - Get all but first cell
-// eq - Equals?
-// lt - Less than?
-// find - Find first field which is equal
-// / neg - Negate all field values
-// mul - Vector multipication
-// add - Vector addition
 var functions map[string]govaluate.ExpressionFunction = map[string]govaluate.ExpressionFunction{
 	"vmean":   vmean,
 	"vmedian": vmedian,
@@ -194,6 +240,26 @@ var functions map[string]govaluate.ExpressionFunction = map[string]govaluate.Exp
 	"weekdayname": tblWeekdayName,
 	"yearday":     tblYearday,
 	"duration":    tblDuration,
+	// Lookups and conditionals
+	"lookupfirst": tblLookupFirst,
+	"lookuplast":  tblLookupLast,
+	"lookupall":   tblLookupAll,
+	"if":          tblIf,
+	"sumif":       tblSumIf,
+	"countif":     tblCountIf,
+	"find":        tblFind,
+	"head":        tblHead,
+	"tail":        tblTail,
+	// Vector methods
+	"vmul": tblVMul,
+	"vadd": tblVAdd,
+	// String methods
+	"concat": tblConcat,
+	"substr": tblSubstr,
+	"upper":  tblUpper,
+	"lower":  tblLower,
+	"trim":   tblTrim,
+	"strlen": tblStrLen,
 }
 
 // Table cells are expanded from a range iterator in entirety for V* functions
@@ -1157,3 +1223,391 @@ func tblDuration(args ...interface{}) (interface{}, error) {
 	}
 	return nil, fmt.Errorf("failed to parse duration from cell")
 }
+
+// Resolve a single cell reference to its value.
+func cellVal(a interface{}) interface{} {
+	if r, ok := a.(*RangeIter); ok {
+		if r.IsRange() {
+			return r
+		}
+		return r.NextVal()
+	}
+	return a
+}
+
+// Expand a range keeping empty cells, so that two ranges of the same size
+// line up with each other. Header rows are still skipped.
+func alignedList(a interface{}) []interface{} {
+	if r, ok := a.(*RangeIter); ok {
+		if !r.IsRange() {
+			return []interface{}{r.NextVal()}
+		}
+		mode := r.Mode
+		if mode == nil {
+			mode = &CalcState{SkipHeader: true}
+		}
+		res := []interface{}{}
+		it := r.Form.CreateIterator(r.Tbl)
+		for ref := it(); ref != nil; ref = it() {
+			if mode.SkipHeader && TableHasHeader(r.Tbl) && ref.Row == 1 {
+				continue
+			}
+			res = append(res, mode.GetCell(r.Tbl, ref))
+		}
+		return res
+	}
+	switch v := a.(type) {
+	case []interface{}:
+		return v
+	case []float64, []int, []string:
+		return tblBuildList(v)
+	}
+	return []interface{}{a}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case common.OrgDuration:
+		return n.Mins, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toStr(v interface{}) string {
+	switch n := v.(type) {
+	case string:
+		return n
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case time.Time:
+		return fmt.Sprintf("<%s>", n.Format("2006-01-02 Mon 15:04"))
+	case common.OrgDuration:
+		return n.ToString()
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func isTruthy(v interface{}) bool {
+	switch n := v.(type) {
+	case bool:
+		return n
+	case string:
+		return n != "" && !isFalse(n)
+	case nil:
+		return false
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// Compare a cell against a value with one of the comparison operators,
+// numbers are compared as numbers and everything else as text.
+// ~ matches a regular expression.
+func cellMatches(cell interface{}, op string, val interface{}) (bool, error) {
+	if op == "~" {
+		re, err := regexp.Compile(toStr(val))
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(toStr(cell)), nil
+	}
+	cmp := 0
+	a, aok := toFloat(cell)
+	b, bok := toFloat(val)
+	if aok && bok {
+		cmp = cmpNum(a, b)
+	} else {
+		cmp = strings.Compare(toStr(cell), toStr(val))
+	}
+	switch op {
+	case "==", "=", "":
+		return cmp == 0, nil
+	case "!=", "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparison [%s] expected ==, !=, <, <=, >, >= or ~", op)
+}
+
+func cmpNum(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// lookup(val, slist, [rlist], [op]) the org-lookup-* family. Returns the
+// entries of rlist (or slist) in the same position as the entries of slist
+// that match val.
+func lookup(name string, all bool, last bool, args ...interface{}) ([]interface{}, error) {
+	if len(args) < 2 || len(args) > 4 {
+		return nil, fmt.Errorf("%s expects (val, slist, [rlist], [op]) got %d arguments", name, len(args))
+	}
+	val := cellVal(args[0])
+	slist := alignedList(args[1])
+	rlist := slist
+	op := "=="
+	if len(args) > 2 {
+		if s, ok := args[2].(string); ok && len(args) == 3 {
+			op = s
+		} else {
+			rlist = alignedList(args[2])
+		}
+	}
+	if len(args) > 3 {
+		op = toStr(args[3])
+	}
+	res := []interface{}{}
+	for i, s := range slist {
+		ok, err := cellMatches(s, op, val)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		var r interface{} = ""
+		if i < len(rlist) {
+			r = rlist[i]
+		}
+		if !all && !last {
+			return []interface{}{r}, nil
+		}
+		res = append(res, r)
+	}
+	if last && len(res) > 1 {
+		res = res[len(res)-1:]
+	}
+	return res, nil
+}
+
+func tblLookupFirst(args ...interface{}) (interface{}, error) {
+	res, err := lookup("lookupfirst", false, false, args...)
+	if err != nil || len(res) == 0 {
+		return "", err
+	}
+	return res[0], nil
+}
+
+func tblLookupLast(args ...interface{}) (interface{}, error) {
+	res, err := lookup("lookuplast", false, true, args...)
+	if err != nil || len(res) == 0 {
+		return "", err
+	}
+	return res[0], nil
+}
+
+func tblLookupAll(args ...interface{}) (interface{}, error) {
+	return lookup("lookupall", true, false, args...)
+}
+
+// if(cond, then, else)
+func tblIf(args ...interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("if expects (cond, then, else) got %d arguments", len(args))
+	}
+	if isTruthy(cellVal(args[0])) {
+		return cellVal(args[1]), nil
+	}
+	return cellVal(args[2]), nil
+}
+
+// The entries of a list that match op and val, or the entries of a second
+// list in the same position.
+func matching(name string, args ...interface{}) ([]interface{}, error) {
+	if len(args) < 3 || len(args) > 4 {
+		return nil, fmt.Errorf("%s expects (list, op, val, [sumlist]) got %d arguments", name, len(args))
+	}
+	crit := alignedList(args[0])
+	vals := crit
+	if len(args) == 4 {
+		vals = alignedList(args[3])
+	}
+	op := toStr(cellVal(args[1]))
+	val := cellVal(args[2])
+	res := []interface{}{}
+	for i, c := range crit {
+		ok, err := cellMatches(c, op, val)
+		if err != nil {
+			return nil, err
+		}
+		if ok && i < len(vals) {
+			res = append(res, vals[i])
+		}
+	}
+	return res, nil
+}
+
+// sumif(list, op, val, [sumlist])
+func tblSumIf(args ...interface{}) (interface{}, error) {
+	res, err := matching("sumif", args...)
+	if err != nil {
+		return nil, err
+	}
+	acc := 0.0
+	for _, v := range res {
+		if f, ok := toFloat(v); ok {
+			acc += f
+		}
+	}
+	return acc, nil
+}
+
+// countif(list, op, val)
+func tblCountIf(args ...interface{}) (interface{}, error) {
+	res, err := matching("countif", args...)
+	if err != nil {
+		return nil, err
+	}
+	return len(res), nil
+}
+
+// Apply an operation to each pair of entries of two lists, a single value
+// is paired with every entry of the other list.
+func vectorOp(name string, op func(a, b float64) float64, args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s expects 2 arguments got %d", name, len(args))
+	}
+	a := alignedList(args[0])
+	b := alignedList(args[1])
+	n := max(len(a), len(b))
+	if len(a) != n && len(a) != 1 || len(b) != n && len(b) != 1 {
+		return nil, fmt.Errorf("%s vectors have different lengths %d and %d", name, len(a), len(b))
+	}
+	res := []interface{}{}
+	for i := 0; i < n; i++ {
+		x, _ := toFloat(a[min(i, len(a)-1)])
+		y, _ := toFloat(b[min(i, len(b)-1)])
+		res = append(res, op(x, y))
+	}
+	return res, nil
+}
+
+func tblVMul(args ...interface{}) (interface{}, error) {
+	return vectorOp("vmul", func(a, b float64) float64 { return a * b }, args...)
+}
+
+func tblVAdd(args ...interface{}) (interface{}, error) {
+	return vectorOp("vadd", func(a, b float64) float64 { return a + b }, args...)
+}
+
+func tblHead(args ...interface{}) (interface{}, error) {
+	for _, a := range args {
+		if l := alignedList(a); len(l) > 0 {
+			return l[0], nil
+		}
+	}
+	return "", nil
+}
+
+func tblTail(args ...interface{}) (interface{}, error) {
+	for _, a := range args {
+		if l := alignedList(a); len(l) > 0 {
+			return l[1:], nil
+		}
+	}
+	return []interface{}{}, nil
+}
+
+// find(val, list) the 1 based position of the first entry equal to val, 0 if there is none.
+func tblFind(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("find expects (val, list) got %d arguments", len(args))
+	}
+	val := cellVal(args[0])
+	for i, v := range alignedList(args[1]) {
+		if ok, _ := cellMatches(v, "==", val); ok {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func tblConcat(args ...interface{}) (interface{}, error) {
+	var sb strings.Builder
+	for _, a := range args {
+		for _, v := range alignedList(a) {
+			sb.WriteString(toStr(v))
+		}
+	}
+	return sb.String(), nil
+}
+
+// substr(str, start, [len]) like the lisp substring, start is 0 based and
+// negative positions count back from the end.
+func tblSubstr(args ...interface{}) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("substr expects (str, start, [len]) got %d arguments", len(args))
+	}
+	s := []rune(toStr(cellVal(args[0])))
+	f, ok := toFloat(cellVal(args[1]))
+	if !ok {
+		return nil, fmt.Errorf("substr start must be a number")
+	}
+	start := int(f)
+	if start < 0 {
+		start += len(s)
+	}
+	start = min(max(start, 0), len(s))
+	end := len(s)
+	if len(args) == 3 {
+		l, ok := toFloat(cellVal(args[2]))
+		if !ok {
+			return nil, fmt.Errorf("substr length must be a number")
+		}
+		end = min(max(start+int(l), start), len(s))
+	}
+	return string(s[start:end]), nil
+}
+
+func strOp(name string, f func(string) string, args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument got %d", name, len(args))
+	}
+	return f(toStr(cellVal(args[0]))), nil
+}
+
+func tblUpper(args ...interface{}) (interface{}, error) {
+	return strOp("upper", strings.ToUpper, args...)
+}
+
+func tblLower(args ...interface{}) (interface{}, error) {
+	return strOp("lower", strings.ToLower, args...)
+}
+
+func tblTrim(args ...interface{}) (interface{}, error) {
+	return strOp("trim", strings.TrimSpace, args...)
+}
+
+func tblStrLen(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("strlen expects 1 argument got %d", len(args))
+	}
+	return len([]rune(toStr(cellVal(args[0])))), nil
+}
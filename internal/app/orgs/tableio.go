@@ -0,0 +1,451 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Tables
+* Import and Export
+
+  Named tables can be read and written as CSV, TSV or JSON, so scripts can
+  share reference tables with your org files.
+
+  =GET /api/table/export?name=Prices&format=csv= returns the table. If the
+  table has a header (a separator after the first row) the header is the
+  first CSV record, or the keys of each JSON object. Tables without a header
+  are exported to JSON as a list of lists.
+
+  =POST /api/table/import= replaces the rows of a table, or appends to them
+  with =append=. When both the table and the data have a header, columns are
+  matched up by name and columns the table does not have yet are added at
+  the end, so formulas that refer to columns by number keep working. The
+  =#+TBLFM:= lines of the table are kept and its formulas are run again
+  once the new rows are in.
+
+  #+BEGIN_SRC sh
+  oc table export -name Prices -format csv -out prices.csv
+  oc table import -name Prices -in prices.csv -header
+  oc table import -name Prices -in new.json -append
+  oc table list
+  #+END_SRC
+EDOC */
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// The header (nil if the table has none) and the data rows of a table.
+// Separators and rows of names or parameters are left out.
+func tableRecords(tbl *org.Table) ([]string, [][]string) {
+	var header []string
+	rows := [][]string{}
+	w := org.NewOrgWriter()
+	for i, row := range tbl.Rows {
+		if len(row.Columns) == 0 || row.IsSpecial || ShouldSkipAdvancedRow(row.IsAdvanced) {
+			continue
+		}
+		rec := []string{}
+		for _, col := range row.Columns {
+			rec = append(rec, strings.TrimSpace(w.WriteNodesAsString(col.Children...)))
+		}
+		if i == 0 && TableHasHeader(tbl) {
+			header = rec
+			continue
+		}
+		rows = append(rows, rec)
+	}
+	return header, rows
+}
+
+func tableContentType(format string) string {
+	switch format {
+	case "tsv":
+		return "text/tab-separated-values"
+	case "json":
+		return "application/json"
+	}
+	return "text/csv"
+}
+
+func ExportTable(tbl *org.Table, format string) ([]byte, error) {
+	header, rows := tableRecords(tbl)
+	buf := bytes.Buffer{}
+	switch format {
+	case "csv", "tsv", "":
+		out := csv.NewWriter(&buf)
+		if format == "tsv" {
+			out.Comma = '\t'
+		}
+		if header != nil {
+			out.Write(header)
+		}
+		out.WriteAll(rows)
+		if err := out.Error(); err != nil {
+			return nil, err
+		}
+	case "json":
+		var data interface{} = rows
+		if header != nil {
+			objs := []map[string]string{}
+			for _, row := range rows {
+				obj := map[string]string{}
+				for i, h := range header {
+					if i < len(row) {
+						obj[h] = row[i]
+					} else {
+						obj[h] = ""
+					}
+				}
+				objs = append(objs, obj)
+			}
+			data = objs
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown table format [%s] expected csv, tsv or json", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// Read the records of an import, the header is nil if the data has none.
+// JSON can be a list of lists or a list of objects, the keys of the objects
+// become the header.
+func parseTableImport(req *common.TableImportRequest) ([]string, [][]string, error) {
+	switch req.Format {
+	case "csv", "tsv", "":
+		in := csv.NewReader(strings.NewReader(req.Data))
+		if req.Format == "tsv" {
+			in.Comma = '\t'
+		}
+		in.FieldsPerRecord = -1
+		in.LazyQuotes = true
+		recs, err := in.ReadAll()
+		if err != nil {
+			return nil, nil, err
+		}
+		if req.Header && len(recs) > 0 {
+			return recs[0], recs[1:], nil
+		}
+		return nil, recs, nil
+	case "json":
+		var data []interface{}
+		if err := json.Unmarshal([]byte(req.Data), &data); err != nil {
+			return nil, nil, err
+		}
+		var header []string
+		rows := [][]string{}
+		for _, d := range data {
+			switch v := d.(type) {
+			case []interface{}:
+				rec := []string{}
+				for _, c := range v {
+					rec = append(rec, toStr(c))
+				}
+				rows = append(rows, rec)
+			case map[string]interface{}:
+				keys := []string{}
+				for k := range v {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					if !contains(header, k) {
+						header = append(header, k)
+					}
+				}
+				rec := make([]string, len(header))
+				for i, h := range header {
+					if c, ok := v[h]; ok {
+						rec[i] = toStr(c)
+					}
+				}
+				rows = append(rows, rec)
+			default:
+				return nil, nil, fmt.Errorf("json table rows must be lists or objects")
+			}
+		}
+		return header, rows, nil
+	}
+	return nil, nil, fmt.Errorf("unknown table format [%s] expected csv, tsv or json", req.Format)
+}
+
+// Line the columns of the data up with the columns of the table by their
+// header names. Columns the table does not have are added to the end.
+func matchColumns(tblHeader []string, header []string, rows [][]string) ([]string, [][]string) {
+	if tblHeader == nil || header == nil {
+		return tblHeader, rows
+	}
+	res := append([]string{}, tblHeader...)
+	idx := []int{}
+	for _, h := range header {
+		found := -1
+		for i, t := range res {
+			if strings.EqualFold(strings.TrimSpace(t), strings.TrimSpace(h)) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			res = append(res, h)
+			found = len(res) - 1
+		}
+		idx = append(idx, found)
+	}
+	out := [][]string{}
+	for _, row := range rows {
+		rec := make([]string, len(res))
+		for i, c := range row {
+			if i < len(idx) {
+				rec[idx[i]] = c
+			}
+		}
+		out = append(out, rec)
+	}
+	return res, out
+}
+
+func tableCell(s string) string {
+	s = strings.ReplaceAll(s, "\r", "")
+	s = strings.ReplaceAll(s, "\n", " ")
+	return strings.ReplaceAll(s, "|", "\\vert{}")
+}
+
+func tableRowLine(rec []string) string {
+	cells := []string{}
+	for _, c := range rec {
+		cells = append(cells, tableCell(c))
+	}
+	return "| " + strings.Join(cells, " | ") + " |"
+}
+
+func isTableSeparator(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "|-")
+}
+
+func splitTableLine(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i, c := range cells {
+		cells[i] = strings.TrimSpace(c)
+	}
+	return cells
+}
+
+// Pad the cells of a table so the columns line up, numbers are right aligned
+// like org mode does it.
+func alignTableLines(indent string, lines []string) []string {
+	widths := []int{}
+	numbers := []int{}
+	others := []int{}
+	rows := [][]string{}
+	for i, l := range lines {
+		if isTableSeparator(l) {
+			rows = append(rows, nil)
+			continue
+		}
+		cells := splitTableLine(l)
+		rows = append(rows, cells)
+		for len(widths) < len(cells) {
+			widths = append(widths, 1)
+			numbers = append(numbers, 0)
+			others = append(others, 0)
+		}
+		header := i == 0 && len(lines) > 1 && isTableSeparator(lines[1])
+		for c, cell := range cells {
+			widths[c] = max(widths[c], utf8.RuneCountInString(cell))
+			if header || cell == "" {
+				continue
+			}
+			if _, err := strconv.ParseFloat(cell, 64); err == nil {
+				numbers[c]++
+			} else {
+				others[c]++
+			}
+		}
+	}
+	out := []string{}
+	for _, cells := range rows {
+		if cells == nil {
+			dashes := []string{}
+			for _, w := range widths {
+				dashes = append(dashes, strings.Repeat("-", w+2))
+			}
+			out = append(out, indent+"|"+strings.Join(dashes, "+")+"|")
+			continue
+		}
+		padded := []string{}
+		for c, w := range widths {
+			cell := ""
+			if c < len(cells) {
+				cell = cells[c]
+			}
+			pad := strings.Repeat(" ", w-utf8.RuneCountInString(cell))
+			if numbers[c] > others[c] {
+				padded = append(padded, pad+cell)
+			} else {
+				padded = append(padded, cell+pad)
+			}
+		}
+		out = append(out, indent+"| "+strings.Join(padded, " | ")+" |")
+	}
+	return out
+}
+
+// The lines of a file and the line ending it uses.
+func splitOrgLines(data []byte) ([]string, string) {
+	eol := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	return strings.Split(string(data), eol), eol
+}
+
+// Find the lines of a table in the text of its file, from the first line of
+// the table to the last line starting with a |.
+func tableLineRange(lines []string, tbl *org.Table) (int, int, error) {
+	start := tbl.GetPos().Row
+	if start < 0 || start >= len(lines) || !strings.HasPrefix(strings.TrimSpace(lines[start]), "|") {
+		return 0, 0, fmt.Errorf("table is not where we expected it, reload and try again")
+	}
+	end := start
+	for end < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[end]), "|") {
+		end++
+	}
+	return start, end, nil
+}
+
+// The tables under some nodes, in the order they appear.
+func nodeTables(nodes []org.Node) []*org.Table {
+	res := []*org.Table{}
+	for _, n := range nodes {
+		if tbl, ok := n.(*org.Table); ok {
+			res = append(res, tbl)
+			continue
+		}
+		res = append(res, nodeTables(n.GetChildren())...)
+	}
+	return res
+}
+
+// Find a table of a parsed document in a fresh parse of its file, by name
+// if it has one and by its place among the tables of the file if not.
+func tableInText(doc *org.Document, tbl *org.Table, data []byte, filename string) (*org.Table, error) {
+	fresh := GetConfig().Parse(bytes.NewReader(data), filename)
+	for name, n := range doc.NamedNodes {
+		if n == org.Node(tbl) {
+			if t, ok := fresh.NamedNodes[name].(*org.Table); ok {
+				return t, nil
+			}
+			return nil, fmt.Errorf("no table named %s in %s", name, filename)
+		}
+	}
+	was := nodeTables(doc.Nodes)
+	now := nodeTables(fresh.Nodes)
+	if i := slices.Index(was, tbl); i >= 0 && len(was) == len(now) {
+		return now[i], nil
+	}
+	return nil, fmt.Errorf("%s has changed, reload it and try again", filename)
+}
+
+// Replace or append to the rows of a named table and run its formulas again.
+func ImportTable(ctx context.Context, tf *TableFile, req *common.TableImportRequest) (common.ResultMsg, error) {
+	header, rows, err := parseTableImport(req)
+	if err != nil {
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	filename := tf.File.Filename
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	// The file may have been written since it was last parsed, find the
+	// table in what is on disk now rather than trusting the old position.
+	tbl, ok := GetConfig().Parse(bytes.NewReader(data), filename).NamedNodes[req.Name].(*org.Table)
+	if !ok {
+		err := fmt.Errorf("no table named %s in %s", req.Name, filename)
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	lines, eol := splitOrgLines(data)
+	start, end, err := tableLineRange(lines, tbl)
+	if err != nil {
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	indent := lines[start][:len(lines[start])-len(strings.TrimLeft(lines[start], " \t"))]
+	tblHeader, _ := tableRecords(tbl)
+	newHeader, rows := matchColumns(tblHeader, header, rows)
+	if newHeader == nil {
+		newHeader = header
+	}
+	tblLines := []string{}
+	if req.Append {
+		tblLines = append(tblLines, lines[start:end]...)
+		if tblHeader != nil && len(newHeader) > len(tblHeader) {
+			tblLines[0] = tableRowLine(newHeader)
+		}
+	} else {
+		if newHeader != nil {
+			tblLines = append(tblLines, tableRowLine(newHeader), "|-")
+		}
+		// Keep the rows that name columns or hold parameters.
+		for _, l := range lines[start:end] {
+			if cells := splitTableLine(l); !isTableSeparator(l) && len(cells) > 0 && ShouldSkipAdvancedRow(cells[0]) {
+				tblLines = append(tblLines, l)
+			}
+		}
+	}
+	for _, row := range rows {
+		tblLines = append(tblLines, tableRowLine(row))
+	}
+	if len(tblLines) == 0 {
+		tblLines = append(tblLines, "|")
+	}
+	out := append([]string{}, lines[:start]...)
+	out = append(out, alignTableLines(indent, tblLines)...)
+	out = append(out, lines[end:]...)
+	if err := writeOrgFile(ctx, filename, []byte(strings.Join(out, eol)), 0644); err != nil {
+		return common.ResultMsg{Ok: false, Msg: err.Error()}, err
+	}
+	db := GetDb()
+	db.ReloadFile(filename)
+	msg := common.ResultMsg{Ok: true, Msg: fmt.Sprintf("imported %d rows into %s", len(rows), req.Name)}
+	// Run the formulas of the table over the new rows.
+	if ntf := db.GetNamedTable(req.Name, filename); ntf != nil && ntf.Table.Formulas != nil {
+		if sec := db.tableSection(ntf); sec != nil {
+			if _, err := ExecTable(db, ntf.File, sec, ntf.Table); err != nil {
+				msg.Msg += ", formulas failed: " + err.Error()
+				return msg, nil
+			}
+			if WriteOutOrgFile(ctx, ntf.File) {
+				db.ReloadFile(filename)
+			}
+		}
+	}
+	return msg, nil
+}
+
+// The heading a table lives under, the root of the file for a table
+// before the first heading.
+func (self *OrgDb) tableSection(tf *TableFile) *org.Section {
+	self.dblock.RLock()
+	defer self.dblock.RUnlock()
+	if t, ok := self.TableDeps.tables[tableKey(tf.File.Filename, tf.Table)]; ok {
+		return t.Sec
+	}
+	return nil
+}
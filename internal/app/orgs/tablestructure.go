@@ -0,0 +1,439 @@
+//lint:file-ignore ST1006 allow the use of self
+package orgs
+
+/* SDOC: Tables
+* Table Operations
+
+  =POST /api/table/op= changes the structure of a table the way the org-table
+  commands do, so clients other than Emacs do not have to rewrite the table
+  themselves. The table is found the same way as for =/api/exectable=.
+
+  - sort - sort the rows between the separators around =row= by column =col=.
+    =sort= is =a= (alphabetic), =n= (numeric), =t= (dates and durations) or
+    =c= (custom, in the order given in =order=). Upper case sorts in reverse.
+  - insertrow - insert a row before =row=, one past the last row appends. =values= fills it in.
+  - deleterow - delete =row=
+  - moverow - move =row= so it becomes row =to=
+  - insertcol - insert a column before =col=, =values= fills it in from the top.
+  - deletecol - delete =col=
+  - movecol - move =col= so it becomes column =to=
+  - align - line the columns up
+
+  Rows are numbered like =@r= in formulas, every row but the separators counts
+  starting from 1. Inserting, deleting and moving rows and columns rewrites the
+  absolute =@r= and =$c= references in the =#+TBLFM:= lines like org-table does,
+  references to a deleted row or column become =@INVALID= or =$INVALID=.
+  Relative references, references into other tables and sorting are left alone.
+
+  #+BEGIN_SRC json
+  {"table": {"Target": {"Type": "hash", "Id": "..."}, "Row": 3}, "op": "sort", "row": 2, "col": 3, "sort": "N"}
+  #+END_SRC
+EDOC */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ihdavids/go-org/org"
+	"github.com/ihdavids/orgs/internal/common"
+)
+
+// A line of a table, either a separator or a row of cells.
+type tableLine struct {
+	hline bool
+	cells []string
+}
+
+func parseTableText(lines []string) []tableLine {
+	rows := []tableLine{}
+	for _, l := range lines {
+		if isTableSeparator(l) {
+			rows = append(rows, tableLine{hline: true})
+		} else {
+			rows = append(rows, tableLine{cells: splitTableLine(l)})
+		}
+	}
+	return rows
+}
+
+func renderTableText(indent string, rows []tableLine) []string {
+	lines := []string{}
+	for _, r := range rows {
+		if r.hline {
+			lines = append(lines, "|-")
+		} else {
+			lines = append(lines, "| "+strings.Join(r.cells, " | ")+" |")
+		}
+	}
+	return alignTableLines(indent, lines)
+}
+
+// The index of the line holding row @r, -1 if there is no such row.
+func rowLine(rows []tableLine, r int) int {
+	n := 0
+	for i, l := range rows {
+		if !l.hline {
+			n++
+			if n == r {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func rowCount(rows []tableLine) int {
+	n := 0
+	for _, l := range rows {
+		if !l.hline {
+			n++
+		}
+	}
+	return n
+}
+
+func colCount(rows []tableLine) int {
+	n := 0
+	for _, l := range rows {
+		n = max(n, len(l.cells))
+	}
+	return n
+}
+
+func isFormulaLine(line string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "#+TBLFM:")
+}
+
+var tblfmRefRe = regexp.MustCompile(`([@$])([0-9]+)`)
+var tblfmRemoteRe = regexp.MustCompile(`remote\([^)]*\)`)
+
+// Rewrite the absolute row (@) or column ($) references in a formula line,
+// like org-table-fix-formulas. References inside remote() are to another table.
+func fixFormulaRefs(line string, kind byte, remap func(int) string) string {
+	skip := tblfmRemoteRe.FindAllStringIndex(line, -1)
+	var sb strings.Builder
+	last := 0
+	for _, m := range tblfmRefRe.FindAllStringSubmatchIndex(line, -1) {
+		if line[m[2]] != kind {
+			continue
+		}
+		inRemote := false
+		for _, s := range skip {
+			inRemote = inRemote || (m[0] >= s[0] && m[1] <= s[1])
+		}
+		if inRemote {
+			continue
+		}
+		n, _ := strconv.Atoi(line[m[4]:m[5]])
+		sb.WriteString(line[last:m[4]])
+		sb.WriteString(remap(n))
+		last = m[5]
+	}
+	sb.WriteString(line[last:])
+	return sb.String()
+}
+
+// How references move when a row or column is inserted at, deleted from or
+// moved between positions.
+func insertRemap(at int) func(int) string {
+	return func(n int) string {
+		if n >= at {
+			n++
+		}
+		return strconv.Itoa(n)
+	}
+}
+
+func deleteRemap(at int) func(int) string {
+	return func(n int) string {
+		switch {
+		case n == at:
+			return "INVALID"
+		case n > at:
+			n--
+		}
+		return strconv.Itoa(n)
+	}
+}
+
+func moveRemap(from int, to int) func(int) string {
+	return func(n int) string {
+		switch {
+		case n == from:
+			n = to
+		case from < to && n > from && n <= to:
+			n--
+		case to < from && n >= to && n < from:
+			n++
+		}
+		return strconv.Itoa(n)
+	}
+}
+
+func sortTableRows(rows []tableLine, req *common.TableOpRequest) error {
+	// The rows between the separators around the row, by default the rows under the header.
+	at := rowLine(rows, req.Row)
+	if req.Row == 0 {
+		at = rowLine(rows, 1)
+		if len(rows) > 2 && rows[1].hline {
+			at = rowLine(rows, 2)
+		}
+	}
+	if at < 0 {
+		return fmt.Errorf("table has no row %d", req.Row)
+	}
+	if req.Col < 1 || req.Col > colCount(rows) {
+		return fmt.Errorf("table has no column %d", req.Col)
+	}
+	start, end := at, at+1
+	for start > 0 && !rows[start-1].hline {
+		start--
+	}
+	for end < len(rows) && !rows[end].hline {
+		end++
+	}
+	kind := req.Sort
+	if kind == "" {
+		kind = "a"
+	}
+	reverse := strings.ToUpper(kind) == kind
+	orderOf := map[string]int{}
+	for i, o := range req.Order {
+		orderOf[strings.ToLower(strings.TrimSpace(o))] = i
+	}
+	var key func(string) interface{}
+	switch strings.ToLower(kind) {
+	case "a":
+		key = func(s string) interface{} { return strings.ToLower(s) }
+	case "n":
+		key = func(s string) interface{} {
+			f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			return f
+		}
+	case "t":
+		key = func(s string) interface{} {
+			if tm, err := common.ParseDateString(s); err == nil {
+				return float64(tm.Unix())
+			}
+			if d := common.ParseDuration(s); d != nil {
+				return d.Mins * 60
+			}
+			return 0.0
+		}
+	case "c":
+		key = func(s string) interface{} {
+			if i, ok := orderOf[strings.ToLower(s)]; ok {
+				return float64(i)
+			}
+			return float64(len(req.Order))
+		}
+	default:
+		return fmt.Errorf("unknown sort [%s] expected a, n, t or c", req.Sort)
+	}
+	cell := func(l tableLine) string {
+		if req.Col-1 < len(l.cells) {
+			return l.cells[req.Col-1]
+		}
+		return ""
+	}
+	group := rows[start:end]
+	sort.SliceStable(group, func(i, j int) bool {
+		a, b := key(cell(group[i])), key(cell(group[j]))
+		if reverse {
+			a, b = b, a
+		}
+		if fa, ok := a.(float64); ok {
+			return fa < b.(float64)
+		}
+		return a.(string) < b.(string)
+	})
+	return nil
+}
+
+// Apply a table operation to the lines of a table. Returns the new lines and
+// how to fix the row and column references of the formulas, nil if they stay.
+func applyTableOp(rows []tableLine, req *common.TableOpRequest) ([]tableLine, func(int) string, func(int) string, error) {
+	nrows, ncols := rowCount(rows), colCount(rows)
+	checkRow := func(r int, extra int) error {
+		if r < 1 || r > nrows+extra {
+			return fmt.Errorf("table has no row %d", r)
+		}
+		return nil
+	}
+	checkCol := func(c int, extra int) error {
+		if c < 1 || c > ncols+extra {
+			return fmt.Errorf("table has no column %d", c)
+		}
+		return nil
+	}
+	// Every row gets all of the columns so columns can be moved around.
+	for i := range rows {
+		for !rows[i].hline && len(rows[i].cells) < ncols {
+			rows[i].cells = append(rows[i].cells, "")
+		}
+	}
+	switch req.Op {
+	case "align":
+		return rows, nil, nil, nil
+	case "sort":
+		return rows, nil, nil, sortTableRows(rows, req)
+	case "insertrow":
+		if err := checkRow(req.Row, 1); err != nil {
+			return nil, nil, nil, err
+		}
+		cells := make([]string, ncols)
+		copy(cells, req.Values)
+		if len(req.Values) > ncols {
+			cells = append(cells[:ncols], req.Values[ncols:]...)
+		}
+		at := len(rows)
+		if req.Row <= nrows {
+			at = rowLine(rows, req.Row)
+		} else if last := rowLine(rows, nrows); last >= 0 {
+			at = last + 1
+		}
+		rows = append(rows[:at], append([]tableLine{{cells: cells}}, rows[at:]...)...)
+		return rows, insertRemap(req.Row), nil, nil
+	case "deleterow":
+		if err := checkRow(req.Row, 0); err != nil {
+			return nil, nil, nil, err
+		}
+		at := rowLine(rows, req.Row)
+		rows = append(rows[:at], rows[at+1:]...)
+		return rows, deleteRemap(req.Row), nil, nil
+	case "moverow":
+		if err := checkRow(req.Row, 0); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := checkRow(req.To, 0); err != nil {
+			return nil, nil, nil, err
+		}
+		from := rowLine(rows, req.Row)
+		moved := rows[from]
+		rows = append(rows[:from], rows[from+1:]...)
+		at := len(rows)
+		if req.To < nrows {
+			at = rowLine(rows, req.To)
+		} else if last := rowLine(rows, nrows-1); last >= 0 {
+			at = last + 1
+		}
+		rows = append(rows[:at], append([]tableLine{moved}, rows[at:]...)...)
+		return rows, moveRemap(req.Row, req.To), nil, nil
+	case "insertcol":
+		if err := checkCol(req.Col, 1); err != nil {
+			return nil, nil, nil, err
+		}
+		n := 0
+		for i := range rows {
+			if rows[i].hline {
+				continue
+			}
+			val := ""
+			if n < len(req.Values) {
+				val = req.Values[n]
+			}
+			n++
+			c := rows[i].cells
+			rows[i].cells = append(c[:req.Col-1:req.Col-1], append([]string{val}, c[req.Col-1:]...)...)
+		}
+		return rows, nil, insertRemap(req.Col), nil
+	case "deletecol":
+		if err := checkCol(req.Col, 0); err != nil {
+			return nil, nil, nil, err
+		}
+		for i := range rows {
+			if !rows[i].hline {
+				c := rows[i].cells
+				rows[i].cells = append(c[:req.Col-1:req.Col-1], c[req.Col:]...)
+			}
+		}
+		return rows, nil, deleteRemap(req.Col), nil
+	case "movecol":
+		if err := checkCol(req.Col, 0); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := checkCol(req.To, 0); err != nil {
+			return nil, nil, nil, err
+		}
+		for i := range rows {
+			if rows[i].hline {
+				continue
+			}
+			c := rows[i].cells
+			val := c[req.Col-1]
+			c = append(c[:req.Col-1:req.Col-1], c[req.Col:]...)
+			rows[i].cells = append(c[:req.To-1:req.To-1], append([]string{val}, c[req.To-1:]...)...)
+		}
+		return rows, nil, moveRemap(req.Col, req.To), nil
+	}
+	return nil, nil, nil, fmt.Errorf("unknown table operation [%s]", req.Op)
+}
+
+// Change the structure of the table at a target and write it back.
+// The message holds the new table and its formulas, Pos and End the lines
+// they replaced.
+func TableOpAt(ctx context.Context, db common.ODb, req *common.TableOpRequest) (common.ResultMsg, error) {
+	res := common.ResultMsg{Ok: false, Msg: "Unknown table op error"}
+	ofile, _, node := db.GetFromPreciseTarget(&req.Table, org.TableNode)
+	tbl, ok := node.(*org.Table)
+	if ofile == nil || !ok {
+		err := fmt.Errorf("did not find table, cannot change it")
+		res.Msg = err.Error()
+		return res, err
+	}
+	data, err := os.ReadFile(ofile.Filename)
+	if err != nil {
+		res.Msg = err.Error()
+		return res, err
+	}
+	// The file may have been written since it was last parsed, find the
+	// table in what is on disk now rather than trusting the old position.
+	if tbl, err = tableInText(ofile.Doc, tbl, data, ofile.Filename); err != nil {
+		res.Msg = err.Error()
+		return res, err
+	}
+	lines, eol := splitOrgLines(data)
+	start, end, err := tableLineRange(lines, tbl)
+	if err != nil {
+		res.Msg = err.Error()
+		return res, err
+	}
+	fend := end
+	for fend < len(lines) && isFormulaLine(lines[fend]) {
+		fend++
+	}
+	indent := lines[start][:len(lines[start])-len(strings.TrimLeft(lines[start], " \t"))]
+	rows, rowMap, colMap, err := applyTableOp(parseTableText(lines[start:end]), req)
+	if err != nil {
+		res.Msg = err.Error()
+		return res, err
+	}
+	changed := renderTableText(indent, rows)
+	for _, l := range lines[end:fend] {
+		if rowMap != nil {
+			l = fixFormulaRefs(l, '@', rowMap)
+		}
+		if colMap != nil {
+			l = fixFormulaRefs(l, '$', colMap)
+		}
+		changed = append(changed, l)
+	}
+	out := append([]string{}, lines[:start]...)
+	out = append(out, changed...)
+	out = append(out, lines[fend:]...)
+	if err := writeOrgFile(ctx, ofile.Filename, []byte(strings.Join(out, eol)), 0644); err != nil {
+		res.Msg = err.Error()
+		return res, err
+	}
+	GetDb().ReloadFile(ofile.Filename)
+	res.Ok = true
+	res.Msg = strings.Join(changed, "\n")
+	res.Pos = org.Pos{Row: start, Col: 0}
+	res.End = org.Pos{Row: fend - 1, Col: len(lines[fend-1])}
+	return res, nil
+}
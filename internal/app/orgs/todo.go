@@ -65,16 +65,26 @@ package orgs
   - *HasBlock* - Checks if the node contains a block object.
   - *MatchProperty* - MatchProperty(NAME, REGEX) returns true if the property value matches the implied regex
   - *MatchHeadline* - Run an RE against each headline and check for a match
-  - *OnDate* - Check if a todo is targetting a specific date
+  - *OnDate* - Check if a todo is targetting a specific date, OnDate('2024-01-15') or OnDate('+1d')
   - *Today* - returns true if a node is scheduled for today
   - *Yesterday* - returns true if a node is scheduled for yesterday
-  - *ThisWeek* - returns true if a node is scheduled for sometime this week
+  - *Tomorrow* - returns true if a node is scheduled for tomorrow
+  - *ThisWeek* - returns true if a node is scheduled for sometime this week (Monday to Sunday)
+  - *LastWeek*, *NextWeek*, *ThisMonth*, *NextMonth* - like ThisWeek for other periods
+  - *InRange* - InRange(RANGE) the general form of ThisWeek, InRange('-2w..today')
+  - *ScheduledBefore*, *ScheduledAfter* - ScheduledBefore(DATE) compares the SCHEDULED date
+  - *ScheduledIn* - ScheduledIn(RANGE) returns true if the SCHEDULED date falls in the range
+  - *DeadlineBefore*, *DeadlineAfter*, *DeadlineIn* - the same for the DEADLINE date
+  - *DeadlineWithin* - DeadlineWithin('3d') returns true if the deadline is at most that far away, overdue deadlines count
+  - *ClosedBefore*, *ClosedAfter*, *ClosedIn* - the same for the CLOSED date, ClosedIn('last-week')
+  - *Overdue* - returns true if a node is not done and its deadline has passed
+  - *HasRepeater* - returns true if the SCHEDULED, DEADLINE or timestamp of a node repeats
 EDOC */
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
@@ -612,15 +622,15 @@ func ParseString(expString *common.StringQuery) (*Expr, error) {
 		// Check if a todo is targetting a specific date
 		"OnDate": func(args ...interface{}) (interface{}, error) {
 			p := exp.Sec
-			tm := args[0].(string)
-			//p := args[0].(*org.Section)
-			var now time.Time
-			var err error
-			if now, err = time.Parse("2006 02 01", tm); err != nil {
+			tm, err := stringArg("OnDate", args, 0)
+			if err != nil {
 				return false, err
 			}
-
-			return IsOn(p, now), nil
+			now, _, err := ParseQueryDate(tm, time.Now())
+			if err != nil {
+				return false, err
+			}
+			return IsOn(p, GetBeginOfDay(now)), nil
 		},
 		"Today": func(args ...interface{}) (interface{}, error) {
 			p := exp.Sec
@@ -634,12 +644,69 @@ func ParseString(expString *common.StringQuery) (*Expr, error) {
 			now := Yesterday()
 			return IsOn(p, now), nil
 		},
-		"ThisWeek": func(args ...interface{}) (interface{}, error) {
+		"Tomorrow": func(args ...interface{}) (interface{}, error) {
 			p := exp.Sec
-			//p := args[0].(*org.Section)
-			start := AWeekAgo()
-			now := EndOfToday()
-			return IsIn(p, start, now), nil
+			now := GetBeginOfDay(time.Now().AddDate(0, 0, 1))
+			return IsOn(p, now), nil
+		},
+		// InRange(RANGE) is the general form of ThisWeek, NextWeek etc.
+		"InRange": func(args ...interface{}) (interface{}, error) {
+			return inQueryRange(exp.Sec, "InRange", args)
+		},
+		"ThisWeek": func(args ...interface{}) (interface{}, error) {
+			return inQueryRange(exp.Sec, "ThisWeek", []interface{}{"this-week"})
+		},
+		"LastWeek": func(args ...interface{}) (interface{}, error) {
+			return inQueryRange(exp.Sec, "LastWeek", []interface{}{"last-week"})
+		},
+		"NextWeek": func(args ...interface{}) (interface{}, error) {
+			return inQueryRange(exp.Sec, "NextWeek", []interface{}{"next-week"})
+		},
+		"ThisMonth": func(args ...interface{}) (interface{}, error) {
+			return inQueryRange(exp.Sec, "ThisMonth", []interface{}{"this-month"})
+		},
+		"NextMonth": func(args ...interface{}) (interface{}, error) {
+			return inQueryRange(exp.Sec, "NextMonth", []interface{}{"next-month"})
+		},
+		"ScheduledBefore": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("ScheduledBefore", args, exp.Sec.Headline.Scheduled, DateBefore)
+		},
+		"ScheduledAfter": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("ScheduledAfter", args, exp.Sec.Headline.Scheduled, DateAfter)
+		},
+		"ScheduledIn": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("ScheduledIn", args, exp.Sec.Headline.Scheduled, DateIn)
+		},
+		"DeadlineBefore": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("DeadlineBefore", args, exp.Sec.Headline.Deadline, DateBefore)
+		},
+		"DeadlineAfter": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("DeadlineAfter", args, exp.Sec.Headline.Deadline, DateAfter)
+		},
+		"DeadlineIn": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("DeadlineIn", args, exp.Sec.Headline.Deadline, DateIn)
+		},
+		"DeadlineWithin": func(args ...interface{}) (interface{}, error) {
+			str, err := stringArg("DeadlineWithin", args, 0)
+			if err != nil {
+				return false, err
+			}
+			return DeadlineWithin(exp.Sec, str)
+		},
+		"ClosedBefore": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("ClosedBefore", args, exp.Sec.Headline.Closed, DateBefore)
+		},
+		"ClosedAfter": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("ClosedAfter", args, exp.Sec.Headline.Closed, DateAfter)
+		},
+		"ClosedIn": func(args ...interface{}) (interface{}, error) {
+			return sdcQuery("ClosedIn", args, exp.Sec.Headline.Closed, DateIn)
+		},
+		"Overdue": func(args ...interface{}) (interface{}, error) {
+			return IsOverdue(exp.Sec, exp.File), nil
+		},
+		"HasRepeater": func(args ...interface{}) (interface{}, error) {
+			return HasRepeater(exp.Sec), nil
 		},
 	}
 	//expString := "strlen('someReallyLongInputString') <= 16"
@@ -648,6 +715,36 @@ func ParseString(expString *common.StringQuery) (*Expr, error) {
 	return exp, err
 }
 
+func stringArg(name string, args []interface{}, i int) (string, error) {
+	if i >= len(args) {
+		return "", fmt.Errorf("%s: missing argument %d", name, i+1)
+	}
+	if str, ok := args[i].(string); ok {
+		return str, nil
+	}
+	return "", fmt.Errorf("%s: argument %d must be a string", name, i+1)
+}
+
+func sdcQuery(name string, args []interface{}, sdc *org.SDC, test func(*org.SDC, string) (bool, error)) (interface{}, error) {
+	str, err := stringArg(name, args, 0)
+	if err != nil {
+		return false, err
+	}
+	return test(sdc, str)
+}
+
+func inQueryRange(p *org.Section, name string, args []interface{}) (interface{}, error) {
+	str, err := stringArg(name, args, 0)
+	if err != nil {
+		return false, err
+	}
+	start, end, err := ParseDateRange(str, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return IsIn(p, start, end), nil
+}
+
 func EvalString(exp *Expr, v *org.Section, f *common.OrgFile) bool {
 	parameters := make(map[string]interface{}, 8)
 	parameters["section"] = v
@@ -664,7 +761,7 @@ func EvalString(exp *Expr, v *org.Section, f *common.OrgFile) bool {
 
 func QueryFullTodo(query *common.TodoHash) (common.FullTodo, error) {
 	var td common.FullTodo
-	if s, ok := GetDb().ByHash[(string)(*query)]; ok {
+	if s, _ := GetDb().LookupHash((string)(*query)); s != nil {
 		var title string
 		for _, n := range s.Headline.Title {
 			title += n.String()
@@ -697,7 +794,7 @@ func QueryFullTodo(query *common.TodoHash) (common.FullTodo, error) {
 
 func QueryFullTodoHtml(query *common.TodoHash) (common.FullTodo, error) {
 	var td common.FullTodo
-	if s, ok := GetDb().ByHash[(string)(*query)]; ok {
+	if s, _ := GetDb().LookupHash((string)(*query)); s != nil {
 		var title string
 		for _, n := range s.Headline.Title {
 			title += n.String()
@@ -935,6 +1032,9 @@ func QueryStringTodos(query *common.StringQuery) (*common.Todos, error) {
 		return &todos, err
 	}
 	for _, file := range files {
+		if query.User != "" && !CanReadFile(query.User, file) {
+			continue
+		}
 		f := GetDb().GetFile(file)
 		for _, v := range f.Doc.Outline.Children {
 			todos, _ = ProcessNode(exp, v, f, todos)
@@ -943,13 +1043,16 @@ func QueryStringTodos(query *common.StringQuery) (*common.Todos, error) {
 	return &todos, nil
 }
 
-func Grep(query string, delimeter string) ([]string, error) {
+// Grep the raw text of the given files, all files when files is nil.
+func Grep(query string, delimeter string, files []string) ([]string, error) {
 	res := []string{}
 	if re, err := regexp.Compile(query); err != nil {
 		fmt.Printf("ERROR: failed to compile query: %v", err)
 		return res, err
 	} else {
-		files := GetDb().GetFiles()
+		if files == nil {
+			files = GetDb().GetFiles()
+		}
 		for _, file := range files {
 			fh, err := os.Open(file)
 			if err != nil {
@@ -1054,7 +1157,9 @@ func QueryProjects() common.Todos {
 	return todos
 }
 
-func WriteOutOrgFile(f *common.OrgFile) bool {
+// Serialize a file back to disk. The hashes of any headings that were
+// changed are passed along so listeners can be told exactly what moved.
+func WriteOutOrgFile(ctx context.Context, f *common.OrgFile, changed ...string) bool {
 	if f == nil {
 		fmt.Printf("INVALID (NIL) DOCUMENT PASSED TO WRITEOUTORGFILE, SKIPPING!")
 		return false
@@ -1063,10 +1168,34 @@ func WriteOutOrgFile(f *common.OrgFile) bool {
 	w := org.NewOrgWriter()
 	//w.Indent = "  "
 	f.Doc.Write(w)
-	err := ioutil.WriteFile(f.Filename, []byte(w.String()), os.ModePerm)
+	data := []byte(w.String())
+	err := writeOrgFile(ctx, f.Filename, data, os.ModePerm)
+	if err == nil {
+		f.Revision = revisions.remember(f.Filename, data)
+		if len(changed) == 0 {
+			common.PublishEvent(common.Event{Type: common.EventFileWritten, Filename: f.Filename})
+		}
+		for _, h := range changed {
+			common.PublishEvent(common.Event{Type: common.EventHeadingChanged, Filename: f.Filename, Hash: h})
+		}
+		GetDb().autoRecalc(ctx, f.Filename)
+	} else {
+		Log().Errorf("failed to write %s: %v", f.Filename, err)
+	}
 	return err == nil
 }
 
+// The hashes of the non nil sections passed in, for WriteOutOrgFile.
+func sectionHashes(secs ...*org.Section) []string {
+	res := []string{}
+	for _, s := range secs {
+		if s != nil {
+			res = append(res, s.Hash)
+		}
+	}
+	return res
+}
+
 func SetThingChildren(n *org.Headline, s *org.Section, doit func(head *org.Headline) org.Headline) bool {
 	for i := range n.Children {
 		switch nn := n.Children[i].(type) {
@@ -1119,43 +1248,46 @@ func SetThing(f *common.OrgFile, s *org.Section, doit func(head *org.Headline) o
 	return false
 }
 
-func ChangeStatus(query *common.TodoItemChange) (common.Result, error) {
+func ChangeStatus(ctx context.Context, query *common.TodoItemChange) (common.Result, error) {
 	didWrite := true
 	hh := common.TodoHash(query.Hash)
 	if !IsStatusValid(&hh, query.Value) {
 		return common.Result{Ok: false}, fmt.Errorf("status value is not valid for this item")
 	}
-	if s, ok := GetDb().ByHash[(string)(query.Hash)]; ok {
-		// Change the status
-		f := GetDb().ByHashToFile[(string)(query.Hash)]
+	if s, f := GetDb().LookupHash((string)(query.Hash)); s != nil {
+		// Change the status, repeating tasks go back to an active state instead of DONE
 		if set := SetThing(f, s, func(n *org.Headline) org.Headline {
+			from := n.Status
+			now := time.Now()
+			if isDoneState(f, query.Value) && !isDoneState(f, from) && RepeatTask(n, f, from, query.Value, query.Note, now) {
+				return *n
+			}
 			n.Status = query.Value
+			LogStateChange(n, f, from, query.Value, query.Note, now)
 			return *n
 		}); set {
-			didWrite = WriteOutOrgFile(f)
+			didWrite = WriteOutOrgFile(ctx, f, s.Hash)
 		}
 	}
 	return common.Result{Ok: didWrite}, nil
 }
 
-func RenameHeadline(query *common.TodoItemChange) (common.Result, error) {
+func RenameHeadline(ctx context.Context, query *common.TodoItemChange) (common.Result, error) {
 	didWrite := true
-	if s, ok := GetDb().ByHash[(string)(query.Hash)]; ok {
-		f := GetDb().ByHashToFile[(string)(query.Hash)]
+	if s, f := GetDb().LookupHash((string)(query.Hash)); s != nil {
 		if set := SetThing(f, s, func(n *org.Headline) org.Headline {
 			n.Title = []org.Node{org.Text{Content: query.Value}}
 			return *n
 		}); set {
-			didWrite = WriteOutOrgFile(f)
+			didWrite = WriteOutOrgFile(ctx, f, s.Hash)
 		}
 	}
 	return common.Result{Ok: didWrite}, nil
 }
 
-func ChangeBody(query *common.TodoItemChange) (common.Result, error) {
+func ChangeBody(ctx context.Context, query *common.TodoItemChange) (common.Result, error) {
 	didWrite := true
-	if s, ok := GetDb().ByHash[(string)(query.Hash)]; ok {
-		f := GetDb().ByHashToFile[(string)(query.Hash)]
+	if s, f := GetDb().LookupHash((string)(query.Hash)); s != nil {
 		// Parse the new body content as org-mode text
 		bodyDoc := org.New().Parse(strings.NewReader(query.Value), "./")
 		if set := SetThing(f, s, func(n *org.Headline) org.Headline {
@@ -1171,7 +1303,7 @@ func ChangeBody(query *common.TodoItemChange) (common.Result, error) {
 			n.Children = append(bodyDoc.Nodes, childHeadlines...)
 			return *n
 		}); set {
-			didWrite = WriteOutOrgFile(f)
+			didWrite = WriteOutOrgFile(ctx, f, s.Hash)
 		}
 	}
 	return common.Result{Ok: didWrite}, nil
@@ -1262,10 +1394,9 @@ func insertSDCChild(n *org.Headline, sdc *org.SDC) {
 	}
 }
 
-func ChangeDate(query *common.TodoDateChange) (common.Result, error) {
+func ChangeDate(ctx context.Context, query *common.TodoDateChange) (common.Result, error) {
 	didWrite := true
-	if s, ok := GetDb().ByHash[(string)(query.Hash)]; ok {
-		f := GetDb().ByHashToFile[(string)(query.Hash)]
+	if s, f := GetDb().LookupHash((string)(query.Hash)); s != nil {
 		if set := SetThing(f, s, func(n *org.Headline) org.Headline {
 			if query.Value == "" {
 				// Clear the date — remove from struct and children
@@ -1356,7 +1487,7 @@ func ChangeDate(query *common.TodoDateChange) (common.Result, error) {
 			}
 			return *n
 		}); set {
-			didWrite = WriteOutOrgFile(f)
+			didWrite = WriteOutOrgFile(ctx, f, s.Hash)
 		}
 	}
 	return common.Result{Ok: didWrite}, nil
@@ -1372,10 +1503,10 @@ func IsPropertyValueValid(hash *common.TodoHash, val string) bool {
 
 // The core lib does not have this option, we want it, eventually move this up!
 func SetProperty(n *org.Headline, key string, val string) {
-	props := &n.Properties.Properties
-	if props == nil {
-		return
+	if n.Properties == nil {
+		n.Properties = &org.PropertyDrawer{}
 	}
+	props := &n.Properties.Properties
 	for _, kvPair := range *props {
 		if kvPair[0] == key {
 			kvPair[1] = val
@@ -1386,7 +1517,7 @@ func SetProperty(n *org.Headline, key string, val string) {
 	*props = append(*props, kvPair)
 }
 
-func ChangeProperty(query *common.TodoPropertyChange) (common.Result, error) {
+func ChangeProperty(ctx context.Context, query *common.TodoPropertyChange) (common.Result, error) {
 	didWrite := true
 	hh := common.TodoHash(query.Hash)
 	if !IsPropertyNameValid(&hh, query.Name) {
@@ -1395,19 +1526,36 @@ func ChangeProperty(query *common.TodoPropertyChange) (common.Result, error) {
 	if !IsPropertyValueValid(&hh, query.Value) {
 		return common.Result{Ok: false}, fmt.Errorf("property value is not valid for this item")
 	}
-	if s, ok := GetDb().ByHash[(string)(query.Hash)]; ok {
+	if s, f := GetDb().LookupHash((string)(query.Hash)); s != nil {
 		// Change the status
-		f := GetDb().ByHashToFile[(string)(query.Hash)]
 		if set := SetThing(f, s, func(n *org.Headline) org.Headline {
 			SetProperty(n, query.Name, query.Value)
 			return *n
 		}); set {
-			didWrite = WriteOutOrgFile(f)
+			didWrite = WriteOutOrgFile(ctx, f, s.Hash)
 		}
 	}
 	return common.Result{Ok: didWrite}, nil
 }
 
+// Set several properties on a heading and write the file out once,
+// used by exporters that record where they put a heading.
+func SetHeadingProperties(ctx context.Context, hash string, props map[string]string) error {
+	s, f := GetDb().LookupHash(hash)
+	if s == nil {
+		return fmt.Errorf("could not find heading [%s]", hash)
+	}
+	if set := SetThing(f, s, func(n *org.Headline) org.Headline {
+		for k, v := range props {
+			SetProperty(n, k, v)
+		}
+		return *n
+	}); set && !WriteOutOrgFile(ctx, f, s.Hash) {
+		return fmt.Errorf("failed to write %s", f.Filename)
+	}
+	return nil
+}
+
 func contains(s []string, str string) bool {
 	for _, v := range s {
 		if v == str {
@@ -1433,12 +1581,11 @@ func remove(slice []string, s string) []string {
 	return slice
 }
 
-func ToggleTag(query *common.TodoItemChange) (common.Result, error) {
+func ToggleTag(ctx context.Context, query *common.TodoItemChange) (common.Result, error) {
 	fmt.Printf("TOGGLE TAG CALLED: %s\n", query.Value)
 	didWrite := true
-	if s, ok := GetDb().ByHash[(string)(query.Hash)]; ok {
+	if s, f := GetDb().LookupHash((string)(query.Hash)); s != nil {
 		// Change a tag
-		f := GetDb().ByHashToFile[(string)(query.Hash)]
 		if set := SetThing(f, s, func(n *org.Headline) org.Headline {
 			if contains(n.Tags, query.Value) {
 				n.Tags = remove(n.Tags, query.Value)
@@ -1447,23 +1594,61 @@ func ToggleTag(query *common.TodoItemChange) (common.Result, error) {
 			}
 			return *n
 		}); set {
-			didWrite = WriteOutOrgFile(f)
+			didWrite = WriteOutOrgFile(ctx, f, s.Hash)
 		}
 	}
 	return common.Result{didWrite}, nil
 }
 
-func Reformat(query *common.FileList) (common.Result, error) {
+func Reformat(ctx context.Context, query *common.FileList) (common.Result, error) {
 	fmt.Printf("[REFORMAT CALLED]\n")
 	didWrite := true
 	for _, filename := range *query {
 		fmt.Printf("  reformat: [%v]\n", filename)
 		f := GetDb().FindByFile(filename)
-		didWrite = didWrite && WriteOutOrgFile(f)
+		didWrite = didWrite && WriteOutOrgFile(ctx, f)
 	}
 	return common.Result{didWrite}, nil
 }
 
+// How a todo keyword wants changes logged, from the org TODO(t!) syntax.
+// Enter and Leave are "", "!" to log the time or "@" to log a note.
+type TodoStateLog struct {
+	Enter string
+	Leave string
+}
+
+// Split a keyword like WAIT(w@/!) into its name and logging spec.
+func splitTodoKeyword(x string) (string, TodoStateLog) {
+	var spec TodoStateLog
+	open := strings.Index(x, "(")
+	if open < 0 || !strings.HasSuffix(x, ")") {
+		return x, spec
+	}
+	inner := x[open+1 : len(x)-1]
+	enter, leave, _ := strings.Cut(inner, "/")
+	for _, c := range "@!" {
+		if strings.ContainsRune(enter, c) && spec.Enter == "" {
+			spec.Enter = string(c)
+		}
+		if strings.ContainsRune(leave, c) && spec.Leave == "" {
+			spec.Leave = string(c)
+		}
+	}
+	return x[:open], spec
+}
+
+func ParseTodoStateLogging(ftagstr string) map[string]TodoStateLog {
+	logging := map[string]TodoStateLog{}
+	for _, x := range strings.Fields(strings.ReplaceAll(ftagstr, "|", " ")) {
+		name, spec := splitTodoKeyword(x)
+		if spec.Enter != "" || spec.Leave != "" {
+			logging[name] = spec
+		}
+	}
+	return logging
+}
+
 func ParseTodoStates(ftagstr string) ([]string, []string) {
 
 	var active []string
@@ -1473,7 +1658,7 @@ func ParseTodoStates(ftagstr string) ([]string, []string) {
 	if len(ss) >= 1 {
 		sss := strings.Fields(ss[0])
 		for _, x := range sss {
-			x = strings.TrimSpace(x)
+			x, _ = splitTodoKeyword(strings.TrimSpace(x))
 			if x != "" {
 				if !contains(active, x) {
 					active = append(active, x)
@@ -1484,7 +1669,7 @@ func ParseTodoStates(ftagstr string) ([]string, []string) {
 	if len(ss) >= 2 {
 		sss := strings.Fields(ss[1])
 		for _, x := range sss {
-			x = strings.TrimSpace(x)
+			x, _ = splitTodoKeyword(strings.TrimSpace(x))
 			if x != "" {
 				if !contains(done, x) {
 					done = append(done, x)
@@ -1512,6 +1697,15 @@ func ValidStatusFromFile(f *common.OrgFile) ([]string, []string) {
 	return active, done
 }
 
+func StateLoggingFromFile(f *common.OrgFile) map[string]TodoStateLog {
+	if f != nil {
+		if ftagstr := f.Doc.Get("TODO"); ftagstr != "" {
+			return ParseTodoStateLogging(ftagstr)
+		}
+	}
+	return ParseTodoStateLogging(Conf().Server.DefaultTodoStates)
+}
+
 func NextStatusFromFile(f *common.OrgFile) ([]string, []string) {
 	var active []string
 	var done []string
@@ -1532,8 +1726,7 @@ func NextStatusFromFile(f *common.OrgFile) ([]string, []string) {
 func ValidStatus(query *common.TodoHash) (common.TodoStatesResult, error) {
 	var active []string
 	var done []string
-	if _, ok := GetDb().ByHash[(string)(*query)]; ok {
-		f := GetDb().ByHashToFile[(string)(*query)]
+	if s, f := GetDb().LookupHash((string)(*query)); s != nil {
 		if f != nil {
 			active, done = ValidStatusFromFile(f)
 		}
@@ -1558,7 +1751,7 @@ func IsStatusValid(query *common.TodoHash, status string) bool {
 	return false
 }
 
-func SetMarkerTag(target *common.ExclusiveTagMarker) (common.Result, error) {
+func SetMarkerTag(ctx context.Context, target *common.ExclusiveTagMarker) (common.Result, error) {
 	res := common.Result{}
 	res.Ok = false
 	// Only do anything if you have a valid target!
@@ -1571,7 +1764,7 @@ func SetMarkerTag(target *common.ExclusiveTagMarker) (common.Result, error) {
 			if reply != nil {
 				for _, t := range *reply {
 					toggle.Hash = t.Hash
-					ToggleTag(&toggle)
+					ToggleTag(ctx, &toggle)
 				}
 			}
 		}
@@ -1579,7 +1772,7 @@ func SetMarkerTag(target *common.ExclusiveTagMarker) (common.Result, error) {
 		// as modifying tags could have invalidated our section
 		_, sec = GetDb().GetFromTarget(&target.ToId, true)
 		toggle.Hash = sec.Hash
-		return ToggleTag(&toggle)
+		return ToggleTag(ctx, &toggle)
 	}
 	return res, nil
 }
@@ -1,12 +1,44 @@
 package orgs
 
+/* SDOC: Settings
+* User Management
+	Passwords in the yaml keystore are stored as salted bcrypt hashes. Each user
+	has their own salt and the =orgSalt= setting is mixed into every hash, so changing
+	=orgSalt= invalidates every stored password.
+
+	Existing keystores holding plaintext passwords keep working, each entry is
+	converted to a hash the first time that user logs in successfully.
+
+	Users can be managed without hand editing the yaml file:
+	#+BEGIN_SRC bash
+	oc useradd -user bob -password secret
+	oc passwd -user bob
+	oc userdisable -user bob
+	oc userdisable -user bob -enable
+	oc userrole -user bob -role read-only
+	oc users
+	#+END_SRC
+	EDOC */
+
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	b64 "encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ihdavids/orgs/internal/common"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v2"
 )
 
@@ -19,6 +51,14 @@ type Credentials struct {
 type KeyStore interface {
 	Validate(user, pass string) bool
 	GetSalt(user string) (string, error)
+	AddUser(user, pass string, role string) error
+	SetPassword(user, pass string) error
+	SetDisabled(user string, disabled bool) error
+	SetRole(user string, role string) error
+	GetRole(user string) string
+	IsAdmin(user string) bool
+	IsDisabled(user string) bool
+	Users() []common.UserInfo
 }
 
 func GetKeystore() KeyStore {
@@ -29,13 +69,47 @@ func GetKeystore() KeyStore {
 type Cred struct {
 	Password string `json:"password"`
 	Salt     string `json:"salt"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
 }
 type YamlKeystore struct {
+	mu     sync.Mutex
 	Creds  map[string]Cred
 	Logins map[string]time.Time
 }
 
+func newSalt() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return common.KBAD_SALT
+	}
+	return b64.StdEncoding.EncodeToString(b)
+}
+
+// The server salt is mixed in with an HMAC before handing the password to bcrypt.
+// This also keeps long passwords and salts under the bcrypt 72 byte input limit.
+func pepperPassword(pass, salt string) []byte {
+	mac := hmac.New(sha256.New, []byte(Conf().Server.OrgSalt))
+	mac.Write([]byte(salt))
+	mac.Write([]byte(pass))
+	return []byte(b64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func HashPassword(pass, salt string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(pepperPassword(pass, salt), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func isHashedPassword(p string) bool {
+	return strings.HasPrefix(p, "$2a$") || strings.HasPrefix(p, "$2b$") || strings.HasPrefix(p, "$2y$")
+}
+
 func (s *YamlKeystore) GetSalt(user string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if p, ok := s.Creds[user]; ok {
 		return p.Salt, nil
 	}
@@ -43,24 +117,166 @@ func (s *YamlKeystore) GetSalt(user string) (string, error) {
 }
 
 func (s *YamlKeystore) Validate(user, pass string) bool {
-	if p, ok := s.Creds[user]; ok {
-		valid := p.Password == pass
-		if valid {
-			s.Logins[user] = time.Now()
-			s.Save()
-			return true
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Creds[user]
+	if !ok || p.Disabled {
+		return false
+	}
+	if isHashedPassword(p.Password) {
+		if bcrypt.CompareHashAndPassword([]byte(p.Password), pepperPassword(pass, p.Salt)) != nil {
+			return false
 		}
+	} else {
+		if subtle.ConstantTimeCompare([]byte(p.Password), []byte(pass)) != 1 {
+			return false
+		}
+		// Legacy plaintext entry, migrate it now that we know the password.
+		if p.Salt == "" || p.Salt == common.KBAD_SALT {
+			p.Salt = newSalt()
+		}
+		if hash, err := HashPassword(pass, p.Salt); err == nil {
+			p.Password = hash
+			s.Creds[user] = p
+			fmt.Printf("Keystore: migrated plaintext password for %s\n", user)
+		} else {
+			fmt.Printf("Keystore: failed to hash password for %s: %v\n", user, err)
+		}
+	}
+	s.Logins[user] = time.Now()
+	s.save()
+	return true
+}
+
+func (s *YamlKeystore) AddUser(user, pass string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user == "" || pass == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	if role == "" {
+		role = RoleReadWrite
+	}
+	if !IsValidRole(role) {
+		return fmt.Errorf("unknown role %s", role)
+	}
+	if _, ok := s.Creds[user]; ok {
+		return fmt.Errorf("user %s already exists", user)
+	}
+	salt := newSalt()
+	hash, err := HashPassword(pass, salt)
+	if err != nil {
+		return err
+	}
+	s.Creds[user] = Cred{Password: hash, Salt: salt, Role: role}
+	return s.save()
+}
+
+func (s *YamlKeystore) SetPassword(user, pass string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Creds[user]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	if pass == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+	p.Salt = newSalt()
+	hash, err := HashPassword(pass, p.Salt)
+	if err != nil {
+		return err
+	}
+	p.Password = hash
+	s.Creds[user] = p
+	return s.save()
+}
+
+func (s *YamlKeystore) SetDisabled(user string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Creds[user]
+	if !ok {
+		return fmt.Errorf("user not found")
 	}
-	return false
+	p.Disabled = disabled
+	s.Creds[user] = p
+	return s.save()
+}
+
+func (s *YamlKeystore) SetRole(user string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Creds[user]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	if !IsValidRole(role) {
+		return fmt.Errorf("unknown role %s", role)
+	}
+	p.Role = role
+	s.Creds[user] = p
+	return s.save()
+}
+
+// Must be called with mu held.
+// Entries without a role are read-write, the admin account predates roles so it is always an admin.
+func (s *YamlKeystore) role(user string) string {
+	p, ok := s.Creds[user]
+	if !ok || p.Disabled {
+		return ""
+	}
+	if p.Role != "" {
+		return p.Role
+	}
+	if user == "admin" {
+		return RoleAdmin
+	}
+	return RoleReadWrite
+}
+
+func (s *YamlKeystore) GetRole(user string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.role(user)
+}
+
+func (s *YamlKeystore) IsAdmin(user string) bool {
+	return s.GetRole(user) == RoleAdmin
+}
+
+func (s *YamlKeystore) IsDisabled(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Creds[user]
+	return !ok || p.Disabled
+}
+
+func (s *YamlKeystore) Users() []common.UserInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []common.UserInfo{}
+	for name, p := range s.Creds {
+		users = append(users, common.UserInfo{Username: name, Role: s.role(name), Disabled: p.Disabled, LastLogin: s.Logins[name]})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
 }
 
 func (s *YamlKeystore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// Must be called with mu held.
+func (s *YamlKeystore) save() error {
 	if out, err := yaml.Marshal(s); err == nil {
 		if Conf().Server.Keystore != "" {
 			if filepath.Ext(Conf().Server.Keystore) == ".yaml" {
-				return os.WriteFile(Conf().Server.Keystore, out, os.ModePerm)
+				return os.WriteFile(Conf().Server.Keystore, out, 0600)
 			} else {
-				return fmt.Errorf("keystore path is not a yaml file: %s")
+				return fmt.Errorf("keystore path is not a yaml file: %s", Conf().Server.Keystore)
 			}
 		} else {
 			return fmt.Errorf("keystore path not set, cannot save")
@@ -73,8 +289,232 @@ func (s *YamlKeystore) Save() error {
 func DefaultKeystore() {
 	// Give us A keystore when we start up at least.
 	currentKeystore = &YamlKeystore{Creds: map[string]Cred{
-		"admin": Cred{Password: "default", Salt: common.KBAD_SALT},
+		"admin": Cred{Password: "default", Salt: common.KBAD_SALT, Role: RoleAdmin},
 	}, Logins: map[string]time.Time{}}
 }
 
+// Replace the default keystore with the one configured in the settings file, if any.
+func LoadKeystore() {
+	path := Conf().Server.Keystore
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Keystore: could not read %s, using default keystore: %v\n", path, err)
+		return
+	}
+	ks := &YamlKeystore{}
+	if err := yaml.Unmarshal(data, ks); err != nil {
+		fmt.Printf("Keystore: failed to parse %s, using default keystore: %v\n", path, err)
+		return
+	}
+	if ks.Creds == nil {
+		ks.Creds = map[string]Cred{}
+	}
+	if ks.Logins == nil {
+		ks.Logins = map[string]time.Time{}
+	}
+	currentKeystore = ks
+	fmt.Printf("Keystore: loaded from %s (%d users)\n", path, len(ks.Creds))
+}
+
 var currentKeystore KeyStore = nil
+
+// With auth disabled everyone is effectively an admin.
+func isAdminRequest(r *http.Request) bool {
+	if Conf().Server.NoAuth {
+		return true
+	}
+	return GetKeystore().IsAdmin(GetUsername(r))
+}
+
+func readUserChange(w http.ResponseWriter, r *http.Request) (*common.UserChange, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: err.Error()})
+		return nil, false
+	}
+	var change common.UserChange
+	if err := json.Unmarshal(body, &change); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: err.Error()})
+		return nil, false
+	}
+	if change.Username == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "missing username"})
+		return nil, false
+	}
+	return &change, true
+}
+
+func writeUserResult(w http.ResponseWriter, err error, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(common.ResultMsg{Ok: true, Msg: msg})
+}
+
+/* SDOC: API
+* GET /users — List Users
+	Returns every account in the keystore. Password hashes are never returned.
+
+	*Method:* =GET=
+
+	*Response:* A JSON array of =UserInfo= objects:
+	#+BEGIN_SRC json
+	[{"username": "admin", "role": "admin", "disabled": false, "lastLogin": "2024-01-15T09:00:00Z"}]
+	#+END_SRC
+
+	*Errors:* =403= if the caller is not an admin.
+	EDOC */
+func RequestUsers(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "admin access required"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetKeystore().Users())
+}
+
+/* SDOC: API
+* POST /user/add — Add a User
+	Creates a new account in the keystore. The password is salted and hashed
+	before it is written to the keystore file.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field      | Type   | Required | Description                          |
+	|------------+--------+----------+--------------------------------------|
+	| =username= | string | yes      | The new account name.                |
+	| =password= | string | yes      | The initial password.                |
+	| =role=     | string | no       | =read-only=, =read-write= or =admin= |
+
+	*Response:* A =ResultMsg= JSON object.
+
+	*Errors:*
+	- =403= if the caller is not an admin.
+	- =400= if the user already exists or the request is invalid.
+	EDOC */
+func PostAddUser(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "admin access required"})
+		return
+	}
+	if change, ok := readUserChange(w, r); ok {
+		err := GetKeystore().AddUser(change.Username, change.Password, change.Role)
+		writeUserResult(w, err, fmt.Sprintf("added user %s", change.Username))
+	}
+}
+
+/* SDOC: API
+* POST /user/password — Reset a Password
+	Sets a new password for an account. Admins can reset any password, other users
+	can only change their own and must supply their current password.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field         | Type   | Required | Description                                    |
+	|---------------+--------+----------+------------------------------------------------|
+	| =username=    | string | yes      | The account to change.                         |
+	| =password=    | string | yes      | The new password.                              |
+	| =oldPassword= | string | no       | Current password, required for non admins.     |
+
+	*Response:* A =ResultMsg= JSON object.
+
+	*Errors:*
+	- =403= if the caller may not change this password.
+	- =400= if the user does not exist or the request is invalid.
+	EDOC */
+func PostSetPassword(w http.ResponseWriter, r *http.Request) {
+	change, ok := readUserChange(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRequest(r) {
+		if GetUsername(r) != change.Username || !GetKeystore().Validate(change.Username, change.OldPassword) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "not allowed to change this password"})
+			return
+		}
+	}
+	err := GetKeystore().SetPassword(change.Username, change.Password)
+	writeUserResult(w, err, fmt.Sprintf("password changed for %s", change.Username))
+}
+
+/* SDOC: API
+* POST /user/disable — Disable or Enable an Account
+	Disabled accounts cannot log in and their existing tokens are rejected.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field      | Type   | Required | Description                                 |
+	|------------+--------+----------+---------------------------------------------|
+	| =username= | string | yes      | The account to change.                      |
+	| =disabled= | bool   | yes      | =true= to disable, =false= to re-enable.    |
+
+	*Response:* A =ResultMsg= JSON object.
+
+	*Errors:*
+	- =403= if the caller is not an admin.
+	- =400= if the user does not exist or the request is invalid.
+	EDOC */
+func PostDisableUser(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "admin access required"})
+		return
+	}
+	if change, ok := readUserChange(w, r); ok {
+		if change.Disabled && change.Username == GetUsername(r) {
+			writeUserResult(w, fmt.Errorf("cannot disable your own account"), "")
+			return
+		}
+		err := GetKeystore().SetDisabled(change.Username, change.Disabled)
+		state := "enabled"
+		if change.Disabled {
+			state = "disabled"
+		}
+		writeUserResult(w, err, fmt.Sprintf("%s %s", state, change.Username))
+	}
+}
+
+/* SDOC: API
+* POST /user/role — Change a Users Role
+	Sets the role of an account. See [[Access Control]] for what each role allows.
+
+	*Method:* =POST=
+
+	*Request Body (JSON):*
+	| Field      | Type   | Required | Description                              |
+	|------------+--------+----------+------------------------------------------|
+	| =username= | string | yes      | The account to change.                   |
+	| =role=     | string | yes      | =read-only=, =read-write= or =admin=     |
+
+	*Response:* A =ResultMsg= JSON object.
+
+	*Errors:*
+	- =403= if the caller is not an admin.
+	- =400= if the user does not exist or the role is unknown.
+	EDOC */
+func PostSetRole(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(common.ResultMsg{Ok: false, Msg: "admin access required"})
+		return
+	}
+	if change, ok := readUserChange(w, r); ok {
+		err := GetKeystore().SetRole(change.Username, change.Role)
+		writeUserResult(w, err, fmt.Sprintf("%s is now %s", change.Username, change.Role))
+	}
+}
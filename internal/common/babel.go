@@ -0,0 +1,28 @@
+package common
+
+import "time"
+
+// A single :var binding handed to a babel language executor.
+// Value is either a string or a [][]string when the variable
+// references a named table.
+type BabelVar struct {
+	Name  string
+	Value interface{}
+}
+
+// Everything a babel language executor needs to run a SRC block.
+// Header arguments have already been merged from the document,
+// the owning heading and the block itself.
+type BabelBlock struct {
+	Lang     string
+	Body     string
+	Params   map[string]string
+	Vars     []BabelVar
+	Dir      string
+	Session  string
+	Results  string // output or value
+	Timeout  time.Duration
+	Filename string
+}
+
+type BabelExecMethod func(*BabelBlock) (string, error)
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on the server event bus
+const (
+	EventFileReloaded   = "file.reloaded"
+	EventFileWritten    = "file.written"
+	EventHeadingChanged = "heading.changed"
+	EventClockIn        = "clock.in"
+	EventClockOut       = "clock.out"
+	EventCaptureAdded   = "capture.added"
+	EventPluginRun      = "plugin.finished"
+)
+
+// An Event is a change notification pushed to clients
+// listening on the events endpoint.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Filename string    `json:"filename,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Msg      string    `json:"msg,omitempty"`
+	Target   *Target   `json:"target,omitempty"`
+}
+
+// Listeners that fall this far behind start dropping events
+const eventBufferSize = 64
+
+type EventBus struct {
+	lock sync.Mutex
+	subs map[chan Event]bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: map[chan Event]bool{}}
+}
+
+func (self *EventBus) Subscribe() chan Event {
+	ch := make(chan Event, eventBufferSize)
+	self.lock.Lock()
+	self.subs[ch] = true
+	self.lock.Unlock()
+	return ch
+}
+
+func (self *EventBus) Unsubscribe(ch chan Event) {
+	self.lock.Lock()
+	if _, ok := self.subs[ch]; ok {
+		delete(self.subs, ch)
+		close(ch)
+	}
+	self.lock.Unlock()
+}
+
+// Publish never blocks, a slow listener simply misses events.
+func (self *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for ch := range self.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+var eventBus = NewEventBus()
+
+func Events() *EventBus {
+	return eventBus
+}
+
+func PublishEvent(e Event) {
+	eventBus.Publish(e)
+}
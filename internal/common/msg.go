@@ -9,6 +9,16 @@ import (
 type OrgFile struct {
 	Filename string
 	Doc      *org.Document
+	Revision string // hash of the content the file was loaded from, see Revisions
+}
+
+// Returned with a 409 when an edit was made against an old revision of a file.
+type RevisionConflict struct {
+	Ok       bool   `json:"ok"`
+	Msg      string `json:"msg"`
+	Filename string `json:"filename"`
+	Revision string `json:"revision"` // the current revision of the file
+	Diff     string `json:"diff"`     // what changed since the revision in If-Match, if known
 }
 
 type Empty struct{}
@@ -31,6 +41,62 @@ type Logbook struct {
 	TotalMin float64        `json:"totalMin"`
 }
 
+// A recently clocked task, newest first in the clock history.
+type ClockHistoryEntry struct {
+	Target      Target    `json:"target"`
+	Headline    string    `json:"headline"`
+	Filename    string    `json:"filename"`
+	LastClocked time.Time `json:"lastClocked"`
+	Mins        float64   `json:"mins"`
+}
+
+// A change that can be undone, or redone if Undone is set.
+type JournalEntry struct {
+	Id     int       `json:"id"`
+	Op     string    `json:"op"` // the request that made the change
+	Time   time.Time `json:"time"`
+	Files  []string  `json:"files"`
+	Undone bool      `json:"undone"`
+}
+
+// A checkbox list item in the body of a heading.
+type CheckboxItem struct {
+	Row    int    `json:"row"`
+	Status string `json:"status"` // "X", " " or "-"
+	Text   string `json:"text"`
+	Depth  int    `json:"depth"`
+}
+
+// A column from a COLUMNS definition, see Column View.
+type ColumnDef struct {
+	Property string `json:"property"`
+	Title    string `json:"title"`
+	Width    int    `json:"width"`
+	Summary  string `json:"summary"`
+}
+
+type ColumnRow struct {
+	Hash        string   `json:"hash"`
+	Headline    string   `json:"headline"`
+	Level       int      `json:"level"`
+	Values      []string `json:"values"`
+	EffortMins  float64  `json:"effortMins"`
+	ClockedMins float64  `json:"clockedMins"`
+	Progress    float64  `json:"progress"` // Clocked time as a percent of the Effort
+}
+
+type ColumnView struct {
+	Columns []ColumnDef `json:"columns"`
+	Rows    []ColumnRow `json:"rows"`
+}
+
+// How to resolve a dangling clock, see the Dangling Clocks docs.
+type ClockResolveRequest struct {
+	Action   string `json:"action"`   // keep, subtract, clockout or cancel
+	IdleMins int    `json:"idleMins"` // minutes to drop from the end of the clock
+	At       string `json:"at"`       // or the time the clock should have stopped
+}
+
 type ClockReport struct {
 	Entries  []ClockEntry `json:"entries"`
 	TotalMin float64      `json:"totalMin"`
@@ -88,6 +154,8 @@ type TodoHash string
 type TodoItemChange struct {
 	Hash  string
 	Value string
+	// Optional note logged with a status change.
+	Note string
 }
 
 type TodoPropertyChange struct {
@@ -106,7 +174,16 @@ type Todos []Todo
 
 type StringQuery struct {
 	Query string `yaml:"query"`
+	// Restrict results to files this user can read, empty means no restriction.
+	User string `yaml:"-" json:"-"`
 }
+// A ranked hit from the full text index.
+type SearchResult struct {
+	Todo    Todo    `json:"todo"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
 type Result struct {
 	Ok bool `yaml:"status"`
 }
@@ -200,13 +277,51 @@ type CaptureTemplate struct {
 // file+olp+datetree "filename" [ "Level 1 heading" ...]          - This target83 creates a heading in a date tree84 for today’s date. If the optional outline path is given, the tree will be built under the node it is pointing to, instead of at top level. Check out the :time-prompt and :tree-type properties below for additional options.
 // clock                                                          - insert at position of active clock
 // hash              dynamically assigned hash                    - during a run of the server nodes are dynamically assigned a hash
-//                                                                  use the current dynamic hash to id the node
+//                                                                  use the current dynamic hash to id the node, stale hashes
+//                                                                  from an earlier reload or run are redirected (see identity.go)
 
 type Refile struct {
 	FromId Target
 	ToId   Target
 }
 
+// Archive all the done headings that match a query, see Archiving.
+type ArchiveBulkRequest struct {
+	Query  string `json:"query"`  // defaults to the done headings that are not archived
+	Days   int    `json:"days"`   // only headings closed more than this many days ago
+	Mode   string `json:"mode"`   // archive, sibling or tag
+	DryRun bool   `json:"dryRun"` // list what would be archived without changing anything
+}
+
+type ArchiveBulkResult struct {
+	Ok       bool     `json:"ok"`
+	Msg      string   `json:"msg"`
+	Archived []string `json:"archived"` // file::outline path of each heading
+	Failed   []string `json:"failed"`
+}
+
+// Replace or append to the rows of a named table, see Import and Export.
+type TableImportRequest struct {
+	Name     string `json:"name"`
+	Filename string `json:"filename"` // picks between tables with the same name
+	Format   string `json:"format"`   // csv, tsv or json
+	Data     string `json:"data"`
+	Append   bool   `json:"append"` // add to the rows rather than replacing them
+	Header   bool   `json:"header"` // the first csv or tsv record is a header
+}
+
+// A change to the structure of a table, see Table Operations.
+type TableOpRequest struct {
+	Table  PreciseTarget `json:"table"`  // the table to change, as for exectable
+	Op     string        `json:"op"`     // sort, insertrow, deleterow, moverow, insertcol, deletecol, movecol or align
+	Row    int           `json:"row"`    // @r of the row, counting every row but separators from 1
+	Col    int           `json:"col"`    // $c of the column, from 1
+	To     int           `json:"to"`     // where moverow and movecol move the row or column to
+	Sort   string        `json:"sort"`   // a alpha, n numeric, t time or c custom, upper case sorts in reverse
+	Order  []string      `json:"order"`  // the order of the values for a custom sort
+	Values []string      `json:"values"` // the cells of an inserted row or column
+}
+
 // An Update is an updater plugin that takes a section and does something to it.
 // Jira for instance might update jira.
 type Update struct {
@@ -221,3 +336,21 @@ type ExclusiveTagMarker struct {
 	ToId Target
 	Name string
 }
+
+// Account details for a user in the keystore. Passwords are never returned.
+type UserInfo struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Disabled  bool      `json:"disabled"`
+	LastLogin time.Time `json:"lastLogin"`
+}
+
+// Used to add users, reset passwords and enable or disable accounts.
+// OldPassword is required when a non admin user changes their own password.
+type UserChange struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	OldPassword string `json:"oldPassword"`
+	Role        string `json:"role"`
+	Disabled    bool   `json:"disabled"`
+}
@@ -32,15 +32,19 @@ type PluginLookup interface {
 	GetUpdater(name string) Updater
 }
 
-type BlockExecMethod func(*OrgFile, *org.Section, *org.Block) *ResultMsg
+// Dynamic block generators are told who asked so they can leave out
+// files that user cannot read.
+type BlockExecMethod func(ofile *OrgFile, sec *org.Section, blk *org.Block, username string) *ResultMsg
 type PluginManager struct {
 	HomeDir        string
 	Out            *logging.Logger
 	Tempo          *templates.TemplateManager
 	BlockExec      map[string]BlockExecMethod
+	BabelExec      map[string]BabelExecMethod
 	Port           int
 	TLSPort        int
 	OrgDirs        []string
+	ClockDrawer    string // the drawer clocks are logged in
 	cachedPassword map[string]string
 	Plugs          PluginLookup
 	Filters        map[string]string
@@ -64,6 +68,15 @@ func (o *PluginManager) AddBlockMethod(name string, method BlockExecMethod) {
 	o.BlockExec[name] = method
 }
 
+func (o *PluginManager) AddBabelMethod(method BabelExecMethod, langs ...string) {
+	if o.BabelExec == nil {
+		o.BabelExec = make(map[string]BabelExecMethod)
+	}
+	for _, lang := range langs {
+		o.BabelExec[lang] = method
+	}
+}
+
 func (o *PluginManager) GetPassFromStdIn(name string) string {
 	o.Out.Info("Reading password from stdin.")
 	allBytes, err := ioutil.ReadAll(os.Stdin)
@@ -302,6 +315,7 @@ func (self *PluginDef) Start(db ODb) {
 			case <-t.C:
 				fmt.Printf("UPDATE [%s:%d]: <%v>\n", name, frequency, time.Now())
 				p.Plugin.Update(db)
+				PublishEvent(Event{Type: EventPluginRun, Name: name, Msg: "poller"})
 			}
 		}
 	}(*self, self.Name, ticker, self.Frequency)
@@ -13,6 +13,15 @@ import (
 
 const KBAD_SALT = "THIS IS A DEFAULT SALT DO NOT USE THIS! SET YOUR OWN"
 
+// Grants a role on a set of files to a set of users.
+// A rule without paths or filetags applies to every file.
+type AccessRule struct {
+	Users    []string `yaml:"users"`
+	Role     string   `yaml:"role"`
+	Paths    []string `yaml:"paths"`
+	FileTags []string `yaml:"filetags"`
+}
+
 type ServerSettings struct {
 	/* SDOC: Settings
 	* Orgs Keys
@@ -42,6 +51,30 @@ type ServerSettings struct {
 		EDOC */
 	Keystore string `yaml:"keystore"`
 
+	/* SDOC: Settings
+	* Access Control
+		Every user in the keystore has a role: =read-only=, =read-write= or =admin=.
+		Admins can see and change everything and are the only users that can manage
+		accounts. No one, admins included, can reach a file outside your org dirs
+		through the api.
+
+		Without any access rules every user can see every file, limited only by their role.
+		Once rules are present a user can only see files granted to them by a rule.
+		Rules match files by directory glob or by =#+FILETAGS=, relative paths are relative
+		to your org dirs and =**= matches everything below a directory. The role in a rule
+		can never raise a user above their keystore role.
+		#+BEGIN_SRC yaml
+	  access:
+	    - users: ["*"]
+	      role: read-write
+	      paths: ["shared/**"]
+	    - users: ["bob", "alice"]
+	      role: read-only
+	      filetags: ["team"]
+		#+END_SRC
+		EDOC */
+	Access []AccessRule `yaml:"access"`
+
 	// Configuration options
 	ServePath string `yaml:"servepath"`
 	Port      int    `yaml:"port"`
@@ -23,6 +23,39 @@ func GetHeadlineTitle(h *org.Headline) string {
 	return title
 }
 
+// The finished clocks logged in a drawer of a heading, running clocks are
+// left out. Every report built on clocks reads them through here so the
+// reports agree with each other.
+func HeadlineClocks(h *org.Headline, drawer string) []org.Clock {
+	clocks := []org.Clock{}
+	if h == nil {
+		return clocks
+	}
+	d := h.FindDrawer(drawer)
+	if d == nil {
+		return clocks
+	}
+	for _, c := range d.Children {
+		if clk, ok := c.(org.Clock); ok && clk.Date != nil && !clk.Date.End.IsZero() {
+			clocks = append(clocks, clk)
+		}
+	}
+	return clocks
+}
+
+// The part of a clock that falls between from and to, a zero from or to
+// leaves that end open. Nothing falls inside when end is not after start.
+func ClipClock(clk *org.OrgDateClock, from time.Time, to time.Time) (time.Time, time.Time) {
+	start, end := clk.Start, clk.End
+	if !from.IsZero() && start.Before(from) {
+		start = from
+	}
+	if !to.IsZero() && end.After(to) {
+		end = to
+	}
+	return start, end
+}
+
 func GetHeadlineBody(h *org.Headline) string {
 	if h != nil && h.Children != nil && len(h.Children) > 0 {
 		w := org.NewOrgWriter()
@@ -1,10 +1,15 @@
+{%- if title %}{{title}}
+{% endif -%}
 {%table -%}
 {%headers -%}
-    {%h%}Title{%endh%}{%h%}Local{%endh%}{%h%}Total{%endh%}
+    {%h%}Title{%endh%}{%- for p in properties %}{%h%}{{p}}{%endh%}{%- endfor %}{%h%}Local{%endh%}{%h%}Total{%endh%}{%- if percent %}{%h%}%{%endh%}{%- endif %}
 {%- endheaders%}
+{%- row -%}
+    {%- c%}*Total time*{%endc%}{%- for p in properties %}{%c%}{%endc%}{%- endfor %}{%c%}{%endc%}{%c%}*{{total}}*{%endc%}{%- if percent %}{%c%}{%endc%}{%- endif %}
+{%- endrow%}
 {%- for i in data -%}
     {%- row -%}
-        {%- c%}{{i.title}}{%endc%}{%c%}{{i.local}}{%endc%}{%c%}{{i.total}}{%- endc%}
+        {%- c%}{{i.title}}{%endc%}{%- for p in i.props %}{%c%}{{p}}{%endc%}{%- endfor %}{%c%}{{i.local}}{%endc%}{%c%}{{i.total}}{%- endc%}{%- if percent %}{%c%}{{i.percent}}{%endc%}{%- endif %}
     {%- endrow%}
 {%- endfor %}
-{%- endtable%}
\ No newline at end of file
+{%- endtable%}